@@ -17,6 +17,18 @@ variable "os" {
 	description = "ubuntu | redhat | centos | debian"
 }
 
+variable "node_os" {
+	description = "OS of each node, defaults to os"
+	type = "list"
+	default = []
+}
+
+variable "node_instance_types" {
+	description = "instance type of each node, empty entries default to instance_type"
+	type = "list"
+	default = []
+}
+
 variable "instance_type" {
   default = "c3.xlarge"
 }
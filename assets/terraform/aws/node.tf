@@ -1,7 +1,7 @@
 
 resource "aws_instance" "node" {
-    ami                  = "${lookup(var.ami, var.os)}"
-    instance_type        = "${var.instance_type}"
+    ami                  = "${lookup(var.ami, element(concat(var.node_os, list(var.os)), count.index))}"
+    instance_type        = "${coalesce(element(concat(var.node_instance_types, list("")), count.index), var.instance_type)}"
     source_dest_check    = "false"
     ebs_optimized        = true
     security_groups      = ["${aws_security_group.cluster.name}"]
@@ -16,7 +16,7 @@ resource "aws_instance" "node" {
         Origin = "robotest"
     }
 
-    user_data = "${file("./bootstrap/${var.os}.sh")}"
+    user_data = "${file("./bootstrap/${element(concat(var.node_os, list(var.os)), count.index)}.sh")}"
 
     # OS
     # /var/lib/gravity device
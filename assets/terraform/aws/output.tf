@@ -1,7 +1,24 @@
+#
+# Output Variables
+# Read with `terraform output -json`; lists are ordered by node index
+#
+
 output "private_ips" {
-  value = "${join(" ", aws_instance.node.*.private_ip)}"
+  value = ["${aws_instance.node.*.private_ip}"]
 }
 
 output "public_ips" {
-  value = "${join(" ", aws_instance.node.*.public_ip)}"
+  value = ["${aws_instance.node.*.public_ip}"]
+}
+
+output "hostnames" {
+  value = ["${aws_instance.node.*.private_dns}"]
+}
+
+output "instance_ids" {
+  value = ["${aws_instance.node.*.id}"]
+}
+
+output "availability_zones" {
+  value = ["${aws_instance.node.*.availability_zone}"]
 }
@@ -21,6 +21,18 @@ variable "os" {
 	description = "ubuntu | redhat | centos | debian"
 }
 
+variable "node_os" {
+	description = "OS of each node, defaults to os"
+	type = "list"
+	default = []
+}
+
+variable "node_instance_types" {
+	description = "instance type of each node, empty entries default to vm_type"
+	type = "list"
+	default = []
+}
+
 # 
 # Access credentials:
 #   https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-group-create-service-principal-portal
@@ -32,16 +32,16 @@ resource "azurerm_virtual_machine" "node" {
   location              = "${var.location}"
   resource_group_name   = "${azurerm_resource_group.robotest.name}"
   network_interface_ids = ["${azurerm_network_interface.node.*.id[count.index]}"]
-  vm_size               = "${var.vm_type}"
+  vm_size               = "${coalesce(element(concat(var.node_instance_types, list("")), count.index), var.vm_type)}"
 
   delete_os_disk_on_termination    = "true"
   delete_data_disks_on_termination = "true"
 
   storage_image_reference {
-    publisher = "${lookup(var.os_publisher, var.os)}"
-    offer     = "${lookup(var.os_offer,     var.os)}"
-    sku       = "${lookup(var.os_sku,       var.os)}"
-    version   = "${lookup(var.os_version,   var.os)}"
+    publisher = "${lookup(var.os_publisher, element(concat(var.node_os, list(var.os)), count.index))}"
+    offer     = "${lookup(var.os_offer,     element(concat(var.node_os, list(var.os)), count.index))}"
+    sku       = "${lookup(var.os_sku,       element(concat(var.node_os, list(var.os)), count.index))}"
+    version   = "${lookup(var.os_version,   element(concat(var.node_os, list(var.os)), count.index))}"
   }
 
   storage_os_disk {
@@ -53,7 +53,7 @@ resource "azurerm_virtual_machine" "node" {
   }
 
   os_profile {
-    custom_data    = "${file("./bootstrap/${var.os}.sh")}"
+    custom_data    = "${file("./bootstrap/${element(concat(var.node_os, list(var.os)), count.index)}.sh")}"
     computer_name  = "node-${count.index}"
     # REQUIRED ...
     admin_username = "${var.ssh_user}"
@@ -1,12 +1,24 @@
-
 #
-# Output Variables 
-# 
+# Output Variables
+# Read with `terraform output -json`; lists are ordered by node index
+#
 
 output "private_ips" {
-  value = "${join(" ", azurerm_network_interface.node.*.private_ip_address)}"
+  value = ["${azurerm_network_interface.node.*.private_ip_address}"]
 }
 
 output "public_ips" {
-  value = "${join(" ", azurerm_public_ip.node.*.ip_address)}"
+  value = ["${azurerm_public_ip.node.*.ip_address}"]
+}
+
+output "hostnames" {
+  value = ["${azurerm_virtual_machine.node.*.name}"]
+}
+
+output "instance_ids" {
+  value = ["${azurerm_virtual_machine.node.*.id}"]
+}
+
+output "availability_zones" {
+  value = ["${azurerm_virtual_machine.node.*.location}"]
 }
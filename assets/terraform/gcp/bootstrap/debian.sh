@@ -0,0 +1 @@
+./ubuntu.sh
\ No newline at end of file
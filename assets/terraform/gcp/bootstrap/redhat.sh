@@ -0,0 +1 @@
+./centos.sh
\ No newline at end of file
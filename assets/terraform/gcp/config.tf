@@ -0,0 +1,51 @@
+#
+# Uses Google Cloud provider
+#   https://www.terraform.io/docs/providers/google
+#
+variable "project"          { }
+variable "credentials"      { }
+variable "region"           { }
+variable "zone"             { }
+
+variable "vm_type"          { default = "n1-standard-4" }
+
+variable "ssh_user"         { default = "robotest" }
+variable "ssh_pub_key_path" { }
+
+variable "docker_device" {
+	description = "block device used by docker"
+}
+
+# all resources are named and labeled after the cluster
+# which makes it easy to clean them up
+variable "cluster_name"     { }
+
+variable "nodes" {
+	description = "number of nodes in cluster"
+}
+
+variable "os" {
+	description = "ubuntu | redhat | centos | debian"
+}
+
+variable "node_os" {
+	description = "OS of each node, defaults to os"
+	type = "list"
+	default = []
+}
+
+variable "node_instance_types" {
+	description = "instance type of each node, empty entries default to vm_type"
+	type = "list"
+	default = []
+}
+
+#
+# Access credentials:
+#   https://cloud.google.com/docs/authentication/getting-started
+#
+provider "google" {
+  credentials = "${file(var.credentials)}"
+  project     = "${var.project}"
+  region      = "${var.region}"
+}
@@ -0,0 +1,49 @@
+resource "google_compute_network" "robotest" {
+  name                    = "${var.cluster_name}"
+  auto_create_subnetworks = "true"
+}
+
+# ALL UDP and TCP traffic is allowed within the network
+resource "google_compute_firewall" "internal" {
+  name    = "${var.cluster_name}-internal"
+  network = "${google_compute_network.robotest.name}"
+
+  allow {
+    protocol = "tcp"
+  }
+
+  allow {
+    protocol = "udp"
+  }
+
+  allow {
+    protocol = "icmp"
+  }
+
+  source_tags = ["${var.cluster_name}"]
+}
+
+resource "google_compute_firewall" "external" {
+  name    = "${var.cluster_name}-external"
+  network = "${google_compute_network.robotest.name}"
+
+  allow {
+    protocol = "tcp"
+
+    ports = [
+      # SSH
+      "22",
+      # installer ports
+      "61008-61010",
+      "61022-61024",
+      # bandwidth checker (for pre-checks)
+      "4242",
+      # k8s health check
+      "10250",
+      # internal k8s services
+      "30000-32767",
+    ]
+  }
+
+  source_ranges = ["0.0.0.0/0"]
+}
@@ -0,0 +1,77 @@
+resource "google_compute_instance" "node" {
+  count          = "${var.nodes}"
+  name           = "${var.cluster_name}-node-${count.index}"
+  machine_type   = "${coalesce(element(concat(var.node_instance_types, list("")), count.index), var.vm_type)}"
+  zone           = "${var.zone}"
+  can_ip_forward = true
+  tags           = ["${var.cluster_name}"]
+
+  labels {
+    robotest-cluster = "${var.cluster_name}"
+    origin           = "robotest"
+  }
+
+  # OS
+  # /var/lib/gravity device
+  # /var/lib/data device
+  boot_disk {
+    initialize_params {
+      image = "${lookup(var.os_image, element(concat(var.node_os, list(var.os)), count.index))}"
+      type  = "pd-ssd"
+      size  = "60"
+    }
+  }
+
+  # gravity/docker data device
+  attached_disk {
+    source = "${element(google_compute_disk.docker.*.self_link, count.index)}"
+  }
+
+  # etcd device
+  attached_disk {
+    source = "${element(google_compute_disk.etcd.*.self_link, count.index)}"
+  }
+
+  network_interface {
+    network = "${google_compute_network.robotest.name}"
+
+    # ephemeral public IP
+    access_config {}
+  }
+
+  metadata {
+    ssh-keys = "${var.ssh_user}:${file(var.ssh_pub_key_path)}"
+  }
+
+  metadata_startup_script = "${file("./bootstrap/${element(concat(var.node_os, list(var.os)), count.index)}.sh")}"
+
+  service_account {
+    scopes = ["compute-ro", "storage-ro"]
+  }
+}
+
+resource "google_compute_disk" "docker" {
+  count = "${var.nodes}"
+  name  = "${var.cluster_name}-docker-${count.index}"
+  type  = "pd-ssd"
+  size  = "80"
+  zone  = "${var.zone}"
+
+  labels {
+    robotest-cluster = "${var.cluster_name}"
+    origin           = "robotest"
+  }
+}
+
+resource "google_compute_disk" "etcd" {
+  count = "${var.nodes}"
+  name  = "${var.cluster_name}-etcd-${count.index}"
+  type  = "pd-ssd"
+  size  = "30"
+  zone  = "${var.zone}"
+
+  labels {
+    robotest-cluster = "${var.cluster_name}"
+    origin           = "robotest"
+  }
+}
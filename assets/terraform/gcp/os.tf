@@ -0,0 +1,11 @@
+# Images are global and come without a preset username:
+# the SSH user is created from the instance metadata
+
+variable "os_image" {
+  default = {
+    ubuntu = "ubuntu-os-cloud/ubuntu-1604-lts"
+    redhat = "rhel-cloud/rhel-7"
+    centos = "centos-cloud/centos-7"
+    debian = "debian-cloud/debian-9"
+  }
+}
@@ -0,0 +1,24 @@
+#
+# Output Variables
+# Read with `terraform output -json`; lists are ordered by node index
+#
+
+output "private_ips" {
+  value = ["${google_compute_instance.node.*.network_interface.0.network_ip}"]
+}
+
+output "public_ips" {
+  value = ["${google_compute_instance.node.*.network_interface.0.access_config.0.assigned_nat_ip}"]
+}
+
+output "hostnames" {
+  value = ["${google_compute_instance.node.*.name}"]
+}
+
+output "instance_ids" {
+  value = ["${google_compute_instance.node.*.instance_id}"]
+}
+
+output "availability_zones" {
+  value = ["${google_compute_instance.node.*.zone}"]
+}
@@ -0,0 +1,110 @@
+// Command reaper removes cloud resources leaked by crashed or interrupted
+// robotest runs.
+//
+// It destroys the terraform state directories left under the state directory,
+// the resources recorded in the resource list file of the gravity test suite
+// and, optionally, sweeps the cloud for resources tagged with a prefix:
+//
+//	robotest-reaper -provision="${CLOUD_CONFIG}" -resourcegroup-file=alloc.txt \
+//		-tag-prefix=${TAG} -older-than=24h -dry-run
+//
+// The cloud configuration has the same format as with robotest-suite
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/infra/terraform"
+
+	"github.com/go-yaml/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+var provision = flag.String("provision", "", "cloud configuration in YAML, as with robotest-suite")
+var stateDir = flag.String("state-dir", "", "directory with terraform state left behind, overrides state_dir of the cloud configuration")
+var resourceListFile = flag.String("resourcegroup-file", "", "file with list of resources created")
+var tagPrefix = flag.String("tag-prefix", "", "sweep cloud resources tagged with names starting with the prefix")
+var olderThan = flag.Duration("older-than", 0, "skip resources younger than the specified age")
+var dryRun = flag.Bool("dry-run", false, "only list the resources to remove")
+var timeout = flag.Duration("timeout", time.Hour, "timeout for the whole operation")
+var debug = flag.Bool("debug", false, "enable debug logging")
+
+// cloudConfig is the subset of the robotest-suite configuration
+// relevant to resource removal
+type cloudConfig struct {
+	CloudProvider string             `yaml:"cloud"`
+	AWS           *infra.AWSConfig   `yaml:"aws"`
+	Azure         *infra.AzureConfig `yaml:"azure"`
+	GCP           *infra.GCPConfig   `yaml:"gcp"`
+	StateDir      string             `yaml:"state_dir"`
+}
+
+func main() {
+	flag.Parse()
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	var config cloudConfig
+	err := yaml.Unmarshal([]byte(*provision), &config)
+	if err != nil {
+		log.Fatalf("failed to parse cloud configuration: %v", err)
+	}
+	if *stateDir != "" {
+		config.StateDir = *stateDir
+	}
+
+	reaper, err := terraform.NewReaper(terraform.ReaperConfig{
+		CloudProvider:    config.CloudProvider,
+		AWS:              config.AWS,
+		Azure:            config.Azure,
+		GCP:              config.GCP,
+		ResourceListFile: *resourceListFile,
+		StateDir:         config.StateDir,
+		TagPrefix:        *tagPrefix,
+		OlderThan:        *olderThan,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	leftovers, err := reaper.Find(ctx)
+	if err != nil {
+		log.Fatalf("failed to find leftover resources: %v", err)
+	}
+	printLeftovers(leftovers)
+	if *dryRun || len(leftovers) == 0 {
+		return
+	}
+
+	err = reaper.Reap(ctx, leftovers)
+	if err != nil {
+		log.Fatalf("failed to remove resources: %v", err)
+	}
+}
+
+func printLeftovers(leftovers []terraform.Leftover) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tCLOUD\tSOURCE\tAGE\tSTATE")
+	for _, leftover := range leftovers {
+		age := "-"
+		if !leftover.Created.IsZero() {
+			age = (time.Since(leftover.Created) / time.Minute * time.Minute).String()
+		}
+		state := leftover.StateDir
+		if state == "" {
+			state = "-"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", leftover.Tag, leftover.CloudProvider, leftover.Source, age, state)
+	}
+	w.Flush()
+}
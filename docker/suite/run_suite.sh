@@ -21,6 +21,11 @@ PARALLEL_TESTS=${PARALLEL_TESTS:-1}
 FAIL_FAST=${FAIL_FAST:-false}
 ALWAYS_COLLECT_LOGS=${ALWAYS_COLLECT_LOGS:-true}
 
+# how installers cached by the runner get to nodes: off, mirror or push
+INSTALLER_CACHE=${INSTALLER_CACHE:-off}
+# how installers get to nodes: direct or tree
+INSTALLER_DISTRIBUTION=${INSTALLER_DISTRIBUTION:-direct}
+
 # choose something relatively unique to avoid intersection with other people runs
 # tag would prefix cloud resource groups for your test runs
 TAG=${TAG:-$(id -run)}
@@ -46,7 +51,7 @@ check_files () {
 	fi
 }
 
-if [ $DEPLOY_TO != "azure" ] && [ $DEPLOY_TO != "aws" ] ; then
+if [ $DEPLOY_TO != "azure" ] && [ $DEPLOY_TO != "aws" ] && [ $DEPLOY_TO != "gcp" ] ; then
 	echo "Unsupported deployment cloud ${DEPLOY_TO}"
 	exit 1
 fi
@@ -79,6 +84,20 @@ AZURE_CONFIG="azure:
   docker_device: /dev/sdd"
 fi
 
+if [ $DEPLOY_TO == "gcp" ] ; then
+check_files ${SSH_KEY} ${SSH_PUB} ${GCP_CREDENTIALS}
+GCP_CONFIG="gcp:
+  project: ${GCP_PROJECT}
+  credentials: /robotest/config/gcp-credentials.json
+  region: ${GCP_REGION:-us-central1}
+  zone: ${GCP_ZONE:-us-central1-a}
+  vm_type: ${GCP_VM:-n1-standard-4}
+  ssh_user: robotest
+  key_path: /robotest/config/ops.pem
+  pub_key_path: /robotest/config/ops_rsa.pub
+  docker_device: /dev/sdb"
+fi
+
 if [ -n "${GCL_PROJECT_ID:-}" ] ; then
 	check_files ${GOOGLE_APPLICATION_CREDENTIALS}
 fi
@@ -90,6 +109,7 @@ state_dir: /robotest/state
 cloud: ${DEPLOY_TO}
 ${AWS_CONFIG:-}
 ${AZURE_CONFIG:-}
+${GCP_CONFIG:-}
 "
 
 # will make verbose logging to console, pass -test.v if needed
@@ -106,6 +126,7 @@ exec docker run ${DOCKER_RUN_FLAGS} \
 	-v ${P}/wd_suite/state:/robotest/state \
 	-v ${SSH_KEY}:/robotest/config/ops.pem \
 	${AZURE_CONFIG:+'-v' "${SSH_PUB}:/robotest/config/ops_rsa.pub"} \
+	${GCP_CONFIG:+'-v' "${SSH_PUB}:/robotest/config/ops_rsa.pub" '-v' "${GCP_CREDENTIALS}:/robotest/config/gcp-credentials.json"} \
 	${ROBOTEST_DEV:+'-v' "${P}/assets/terraform:/robotest/terraform"} \
 	${ROBOTEST_DEV:+'-v' "${P}/build/robotest-suite:/usr/bin/robotest-suite"} \
 	${INSTALLER_FILE:+'-v' "${INSTALLER_URL}:${INSTALLER_FILE}"} \
@@ -117,6 +138,8 @@ exec docker run ${DOCKER_RUN_FLAGS} \
 	-test.parallel=${PARALLEL_TESTS} -repeat=${REPEAT_TESTS} -fail-fast=${FAIL_FAST} \
 	-provision="${CLOUD_CONFIG}" -always-collect-logs=${ALWAYS_COLLECT_LOGS} \
 	-resourcegroup-file=/robotest/state/alloc.txt \
+	-installer-cache=${INSTALLER_CACHE} -installer-cache-dir=/robotest/state/installers \
+	-installer-distribution=${INSTALLER_DISTRIBUTION} \
 	-destroy-on-success=${DESTROY_ON_SUCCESS} -destroy-on-failure=${DESTROY_ON_FAILURE}  \
 	-tag=${TAG} -suite=sanity -os=${TEST_OS} -storage-driver=${STORAGE_DRIVER} \
 	$@
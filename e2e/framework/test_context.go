@@ -12,8 +12,7 @@ import (
 	"github.com/gravitational/configure"
 	"github.com/gravitational/robotest/e2e/framework/defaults"
 	"github.com/gravitational/robotest/infra"
-	"github.com/gravitational/robotest/infra/terraform"
-	"github.com/gravitational/robotest/infra/vagrant"
+	_ "github.com/gravitational/robotest/infra/provisioners"
 	"github.com/gravitational/robotest/lib/debug"
 	"github.com/gravitational/robotest/lib/loc"
 	"github.com/gravitational/trace"
@@ -159,7 +158,7 @@ type TestContextType struct {
 	// Provisioner defines the type of provisioner to use
 	Provisioner provisionerType `json:"provisioner" yaml:"provisioner" `
 	// CloudProvider defines cloud to deploy
-	CloudProvider string `json:"cloud_provider" yaml:"cloud_provider" validate:"omitempty,eq=aws|eq=azure"`
+	CloudProvider string `json:"cloud_provider" yaml:"cloud_provider" validate:"omitempty,eq=aws|eq=azure|eq=gcp"`
 	// DumpCore specifies a command to collect all installation/operation logs
 	DumpCore bool `json:"-" yaml:"-"`
 	// StateDir specifies the location for test-specific temporary data
@@ -204,6 +203,14 @@ type TestContextType struct {
 	AWS *infra.AWSConfig `json:"aws" yaml:"aws"`
 	// Azure defines Azure cloud specific parameters
 	Azure *infra.AzureConfig `yaml:"azure"`
+	// GCP defines Google Compute Engine specific parameters
+	GCP *infra.GCPConfig `json:"gcp" yaml:"gcp"`
+	// JumpHost optionally defines the SSH bastion host to connect to nodes through
+	JumpHost *infra.JumpHostConfig `json:"jump_host" yaml:"jump_host"`
+	// SSHAuth optionally configures additional methods to authenticate to nodes with
+	SSHAuth *infra.SSHAuthConfig `json:"ssh_auth" yaml:"ssh_auth"`
+	// VerifyHostKeys enables SSH host key verification with trust on first use
+	VerifyHostKeys bool `json:"verify_host_keys" yaml:"verify_host_keys"`
 
 	// Onprem defines the test configuration for bare metal tests
 	Onprem OnpremConfig `json:"onprem" yaml:"onprem"`
@@ -410,58 +417,33 @@ func initLogger(debug bool) {
 	log.SetLevel(level)
 }
 
-func makeTerraformConfig(infraConfig infra.Config) (config *terraform.Config, err error) {
-	if TestContext.CloudProvider == "" {
-		return nil, trace.Errorf("cloud_provider parameter is required for Terraform")
+// makeProvisionerConfig returns the configuration for the provisioner
+// to create a cluster with numNodes nodes
+func makeProvisionerConfig(infraConfig infra.Config, numNodes int) infra.ProvisionerConfig {
+	return infra.ProvisionerConfig{
+		Config:         infraConfig,
+		ScriptPath:     TestContext.Onprem.ScriptPath,
+		InstallerURL:   TestContext.Onprem.InstallerURL,
+		NumNodes:       numNodes,
+		OS:             TestContext.Onprem.OS,
+		CloudProvider:  TestContext.CloudProvider,
+		AWS:            TestContext.AWS,
+		Azure:          TestContext.Azure,
+		GCP:            TestContext.GCP,
+		JumpHost:       TestContext.JumpHost,
+		SSHAuth:        TestContext.SSHAuth,
+		VerifyHostKeys: TestContext.VerifyHostKeys,
 	}
-
-	config = &terraform.Config{
-		Config:        infraConfig,
-		ScriptPath:    TestContext.Onprem.ScriptPath,
-		InstallerURL:  TestContext.Onprem.InstallerURL,
-		NumNodes:      TestContext.Onprem.NumNodes,
-		OS:            TestContext.Onprem.OS,
-		CloudProvider: TestContext.CloudProvider,
-		AWS:           TestContext.AWS,
-		Azure:         TestContext.Azure,
-	}
-
-	err = config.Validate()
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-
-	return config, nil
 }
 
 func provisionerFromConfig(infraConfig infra.Config, stateDir string, provisionerName provisionerType) (provisioner infra.Provisioner, err error) {
-	switch provisionerName {
-	case provisionerTerraform:
-		config, err := makeTerraformConfig(infraConfig)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-
-		provisioner, err = terraform.New(stateDir, *config)
-	case provisionerVagrant:
-		config := vagrant.Config{
-			Config:       infraConfig,
-			ScriptPath:   TestContext.Onprem.ScriptPath,
-			InstallerURL: TestContext.Onprem.InstallerURL,
-			NumNodes:     TestContext.Onprem.NumNodes,
-		}
-		err := config.Validate()
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-		provisioner, err = vagrant.New(stateDir, config)
-	default:
+	if provisionerName == "" {
 		// no provisioner when the cluster has already been provisioned
 		// or automatic provisioning is used
-		if provisionerName != "" {
-			return nil, trace.BadParameter("unknown provisioner %q", provisionerName)
-		}
+		return nil, nil
 	}
+	config := makeProvisionerConfig(infraConfig, TestContext.Onprem.NumNodes)
+	provisioner, err = infra.NewProvisioner(string(provisionerName), stateDir, config)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -473,37 +455,18 @@ func provisionerFromState(infraConfig infra.Config, testState TestState) (provis
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	if testState.Provisioner == "" {
+		// no provisioner when the cluster has already been provisioned
+		// or automatic provisioning is used
+		return nil, nil
+	}
 	numNodes := len(testState.ProvisionerState.Nodes)
 	if TestContext.Onprem.NumNodes > 0 {
 		// Always override from configuration if available
 		numNodes = TestContext.Onprem.NumNodes
 	}
-	switch testState.Provisioner {
-	case provisionerTerraform:
-		config, err := makeTerraformConfig(infraConfig)
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-		provisioner, err = terraform.NewFromState(*config, *testState.ProvisionerState)
-	case provisionerVagrant:
-		config := vagrant.Config{
-			Config:       infraConfig,
-			ScriptPath:   TestContext.Onprem.ScriptPath,
-			InstallerURL: TestContext.Onprem.InstallerURL,
-			NumNodes:     numNodes,
-		}
-		err := config.Validate()
-		if err != nil {
-			return nil, trace.Wrap(err)
-		}
-		provisioner, err = vagrant.NewFromState(config, *testState.ProvisionerState)
-	default:
-		// no provisioner when the cluster has already been provisioned
-		// or automatic provisioning is used
-		if testState.Provisioner != "" {
-			return nil, trace.BadParameter("unknown provisioner %q", testState.Provisioner)
-		}
-	}
+	config := makeProvisionerConfig(infraConfig, numNodes)
+	provisioner, err = infra.NewProvisionerFromState(string(testState.Provisioner), config, *testState.ProvisionerState)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -513,6 +476,7 @@ func provisionerFromState(infraConfig infra.Config, testState TestState) (provis
 func outputSensitiveConfig(testConfig TestContextType) {
 	testConfig.AWS = nil
 	testConfig.Azure = nil
+	testConfig.GCP = nil
 	testConfig.Login.Password = mask
 	testConfig.ServiceLogin.Password = mask
 	var buf bytes.Buffer
@@ -542,11 +506,6 @@ const mask = "****"
 
 type provisionerType string
 
-const (
-	provisionerTerraform provisionerType = "terraform"
-	provisionerVagrant   provisionerType = "vagrant"
-)
-
 func (r *modeType) String() string {
 	return string(*r)
 }
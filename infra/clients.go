@@ -0,0 +1,43 @@
+package infra
+
+import (
+	"context"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// clients caches SSH clients of nodes for the lifetime of the process
+var clients = sshutils.NewClientManager(log.NewEntry(defaultLogger))
+
+// Client returns the cached SSH client of node, connecting on first use
+// and reconnecting transparently once the connection has been lost.
+// The client is shared by all users of the node and must not be closed
+func Client(ctx context.Context, node Node) (*ssh.Client, error) {
+	client, err := clients.Client(ctx, node.Addr(), node.Client)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to %v", node)
+	}
+	return client, nil
+}
+
+// Session returns a new session of the cached SSH client of node
+func Session(ctx context.Context, node Node) (*ssh.Session, error) {
+	session, err := clients.Session(ctx, node.Addr(), node.Client)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to %v", node)
+	}
+	return session, nil
+}
+
+// Disconnect closes the cached SSH clients of nodes,
+// i.e. once the nodes have been powered off or removed
+// as their addresses might be reused by other nodes
+func Disconnect(nodes ...Node) {
+	for _, node := range nodes {
+		clients.Forget(node.Addr())
+	}
+}
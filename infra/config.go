@@ -1,6 +1,13 @@
 package infra
 
-import "github.com/gravitational/trace"
+import (
+	"net"
+	"path/filepath"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+)
 
 func (r *Config) Validate() error {
 	if r.ClusterName == "" {
@@ -21,6 +28,8 @@ type ProvisionerState struct {
 	Dir string `json:"state_dir"`
 	// InstallerAddr is the address of the installer node
 	InstallerAddr string `json:"installer_addr,omitempty"`
+	// InstallerPath is the location of the installer tarball on the installer node
+	InstallerPath string `json:"installer_path,omitempty"`
 	// Nodes is a list of all nodes in the cluster
 	Nodes []StateNode `json:"nodes"`
 	// Allocated defines the allocated subset
@@ -31,8 +40,46 @@ type ProvisionerState struct {
 type StateNode struct {
 	// Addr is the address of this node
 	Addr string `json:"addr"`
+	// PrivateAddr is the private address of this node.
+	// Only recorded by provisioners that distinguish public and private addresses
+	PrivateAddr string `json:"private_addr,omitempty"`
 	// KeyPath defines the location of the SSH key
 	KeyPath string `json:"key_path"`
+	// User defines the SSH user to connect as
+	User string `json:"user,omitempty"`
+	// Labels is an optional set of arbitrary labels attached to this node
+	Labels map[string]string `json:"labels,omitempty"`
+	// Hostname is the hostname of this node
+	Hostname string `json:"hostname,omitempty"`
+	// OS is the OS distribution of this node
+	OS string `json:"os,omitempty"`
+	// OSVersion is the version of the OS distribution of this node
+	OSVersion string `json:"os_version,omitempty"`
+	// InstanceType is the cloud-specific instance type of this node
+	InstanceType string `json:"instance_type,omitempty"`
+}
+
+// Meta returns the metadata recorded for this node
+func (r StateNode) Meta() NodeMeta {
+	return NodeMeta{
+		Hostname:     r.Hostname,
+		OS:           r.OS,
+		OSVersion:    r.OSVersion,
+		InstanceType: r.InstanceType,
+		SSHUser:      r.User,
+		Labels:       r.Labels,
+	}
+}
+
+// WithMeta returns a copy of this node with the specified metadata recorded
+func (r StateNode) WithMeta(meta NodeMeta) StateNode {
+	r.Hostname = meta.Hostname
+	r.OS = meta.OS
+	r.OSVersion = meta.OSVersion
+	r.InstanceType = meta.InstanceType
+	r.User = meta.SSHUser
+	r.Labels = meta.Labels
+	return r
 }
 
 // AWSConfig describes AWS EC2 test configuration
@@ -101,3 +148,105 @@ type AzureConfig struct {
 	// DockerDevice block device for docker data - set to /dev/sdd
 	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
 }
+
+// GCPConfig specifies Google Compute Engine specific parameters
+type GCPConfig struct {
+	// Project is the ID of the project to create resources in
+	Project string `json:"project" yaml:"project" validate:"required"`
+	// Credentials is the path to the service account key file
+	// https://cloud.google.com/docs/authentication/getting-started
+	Credentials string `json:"credentials" yaml:"credentials" validate:"required"`
+	// Region specifies the region to install into
+	// https://cloud.google.com/compute/docs/regions-zones/
+	Region string `json:"region" yaml:"region" validate:"required"`
+	// Zone specifies the zone within the region to install into
+	Zone string `json:"zone" yaml:"zone" validate:"required"`
+	// VMType defines the machine type of the instances
+	// https://cloud.google.com/compute/docs/machine-types
+	VMType string `json:"vm_type,omitempty" yaml:"vm_type"`
+	// SSHKeyPath specifies the location of the SSH private key to use for remote access
+	SSHKeyPath string `json:"-" yaml:"key_path" validate:"required"`
+	// SSHPublicKeyPath specifies the location of the SSH public key to place on instances
+	SSHPublicKeyPath string `json:"ssh_pub_key_path" yaml:"pub_key_path" validate:"required"`
+	// SSHUser defines SSH user used to connect to the provisioned machines
+	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// ClusterName names and labels all resources of the cluster
+	ClusterName string `json:"cluster_name" yaml:"cluster_name"`
+	// DockerDevice block device for docker data - set to /dev/sdb
+	DockerDevice string `json:"docker_device" yaml:"docker_device" validate:"required"`
+}
+
+// JumpHostConfig describes the SSH bastion host to connect to nodes through
+// when nodes are not directly reachable
+type JumpHostConfig struct {
+	// Addr is the address of the jump host. Port defaults to 22
+	Addr string `json:"addr" yaml:"addr" validate:"required"`
+	// SSHUser defines SSH user to connect to the jump host as
+	SSHUser string `json:"ssh_user" yaml:"ssh_user" validate:"required"`
+	// SSHKeyPath specifies the location of the SSH private key for the jump host
+	SSHKeyPath string `json:"key_path" yaml:"key_path"`
+	// Auth optionally configures additional methods to authenticate to the jump host with
+	Auth *SSHAuthConfig `json:"auth,omitempty" yaml:"auth"`
+}
+
+// JumpHost returns the SSH configuration of the jump host
+// or nil if no jump host is configured
+func (r *JumpHostConfig) JumpHost() *sshutils.JumpHost {
+	if r == nil {
+		return nil
+	}
+	addr := r.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return &sshutils.JumpHost{
+		Addr: addr,
+		User: r.SSHUser,
+		Auth: r.Auth.Auth(r.SSHKeyPath),
+	}
+}
+
+// SSHAuthConfig configures how to authenticate SSH connections
+// in addition to or instead of the SSH private key, i.e. to avoid
+// keeping unencrypted keys on test runners
+type SSHAuthConfig struct {
+	// KeyPassphrase decrypts the SSH private key if it is encrypted
+	KeyPassphrase string `json:"-" yaml:"key_passphrase"`
+	// CertPath specifies the location of the OpenSSH certificate issued for the SSH private key
+	CertPath string `json:"cert_path,omitempty" yaml:"cert_path"`
+	// Agent enables authentication with the keys of the SSH agent listening on SSH_AUTH_SOCK
+	Agent bool `json:"agent,omitempty" yaml:"agent"`
+	// Password enables password authentication, i.e. for lab machines
+	Password string `json:"-" yaml:"password"`
+}
+
+// Auth returns the SSH authentication with the private key at keyPath
+// complemented by this configuration.
+// Only the private key is used if no configuration is specified
+func (r *SSHAuthConfig) Auth(keyPath string) *sshutils.Auth {
+	config := sshutils.AuthConfig{KeyPath: keyPath}
+	if r != nil {
+		config.KeyPassphrase = r.KeyPassphrase
+		config.CertPath = r.CertPath
+		config.Agent = r.Agent
+		config.Password = r.Password
+	}
+	return sshutils.NewAuth(config)
+}
+
+// KnownHostsFile names the file in the provisioner state directory
+// that records the SSH host keys of nodes
+const KnownHostsFile = "known_hosts"
+
+// LoadKnownHosts returns the SSH host keys recorded in the state directory
+// or nil if host keys are not to be verified
+func LoadKnownHosts(stateDir string, verify bool) (*sshutils.KnownHosts, error) {
+	if !verify {
+		return nil, nil
+	}
+	knownHosts, err := sshutils.NewKnownHosts(filepath.Join(stateDir, KnownHostsFile))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return knownHosts, nil
+}
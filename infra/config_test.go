@@ -0,0 +1,46 @@
+package infra
+
+import (
+	"reflect"
+	"testing"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+)
+
+func TestConfiguresJumpHost(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		config   *JumpHostConfig
+		expected *sshutils.JumpHost
+	}{
+		{comment: "No jump host"},
+		{
+			comment: "Default port",
+			config:  &JumpHostConfig{Addr: "bastion.example.com", SSHUser: "ubuntu", SSHKeyPath: "/keys/bastion"},
+			expected: &sshutils.JumpHost{
+				Addr: "bastion.example.com:22",
+				User: "ubuntu",
+				Auth: sshutils.NewAuth(sshutils.AuthConfig{KeyPath: "/keys/bastion"}),
+			},
+		},
+		{
+			comment: "Custom port with agent",
+			config: &JumpHostConfig{
+				Addr:    "10.0.0.1:2222",
+				SSHUser: "ubuntu",
+				Auth:    &SSHAuthConfig{Agent: true},
+			},
+			expected: &sshutils.JumpHost{
+				Addr: "10.0.0.1:2222",
+				User: "ubuntu",
+				Auth: sshutils.NewAuth(sshutils.AuthConfig{Agent: true}),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		jumpHost := testCase.config.JumpHost()
+		if !reflect.DeepEqual(jumpHost, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, jumpHost)
+		}
+	}
+}
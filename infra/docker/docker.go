@@ -0,0 +1,608 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/system"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// New creates a new provisioner which runs cluster nodes as privileged systemd
+// containers on the local docker daemon.
+// Nodes are addressed by their container IP on a dedicated bridge network
+// which requires that the docker daemon runs on the same (Linux) host
+func New(stateDir string, config Config) (*docker, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateDir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &docker{
+		Entry: log.WithFields(log.Fields{
+			constants.FieldProvisioner: "docker",
+			constants.FieldCluster:     config.ClusterName,
+		}),
+		Config:   config,
+		stateDir: stateDir,
+		// will be reset in Create
+		pool:       infra.NewNodePool(nil, nil),
+		knownHosts: knownHosts,
+	}, nil
+}
+
+// NewFromState reattaches to the containers of a previously created cluster.
+// All nodes recorded in stateConfig are expected to be running
+func NewFromState(config Config, stateConfig infra.ProvisionerState) (*docker, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateConfig.Dir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	r := &docker{
+		Entry: log.WithFields(log.Fields{
+			constants.FieldProvisioner: "docker",
+			constants.FieldCluster:     config.ClusterName,
+		}),
+		Config:      config,
+		stateDir:    stateConfig.Dir,
+		installerIP: stateConfig.InstallerAddr,
+		knownHosts:  knownHosts,
+	}
+
+	containers, err := r.discoverContainers(context.TODO())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	running := make(map[string]container, len(containers))
+	for _, c := range containers {
+		if c.running {
+			running[c.addrIP] = c
+		}
+	}
+
+	nodes := make([]infra.Node, 0, len(stateConfig.Nodes))
+	for _, n := range stateConfig.Nodes {
+		c, ok := running[n.Addr]
+		if !ok {
+			return nil, trace.NotFound("no running container with address %v", n.Addr)
+		}
+		nodes = append(nodes, &node{name: c.name, addrIP: n.Addr, identityFile: n.KeyPath, meta: n.Meta(), knownHosts: knownHosts})
+	}
+	r.pool = infra.NewNodePool(nodes, stateConfig.Allocated)
+	return r, nil
+}
+
+func (r *docker) Create(ctx context.Context, withInstaller bool) (installer infra.Node, err error) {
+	err = os.MkdirAll(r.shareDir(), constants.SharedDirMask)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	if withInstaller {
+		err = r.syncInstallerTarball()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	authorizedKey, err := r.generateSSHKey()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	image, err := r.buildImage(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	err = r.createNetwork(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	for i := 1; i <= r.NumNodes; i++ {
+		err = r.startNode(ctx, image, r.nodeName(i), authorizedKey)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	containers, err := r.discoverContainers(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(containers) == 0 {
+		return nil, trace.NotFound("failed to discover any nodes")
+	}
+
+	nodes := make([]infra.Node, 0, len(containers))
+	for _, c := range containers {
+		if !c.running {
+			return nil, trace.BadParameter("container %v is not running", c.name)
+		}
+		n := &node{name: c.name, addrIP: c.addrIP, identityFile: r.sshKeyPath(), knownHosts: r.knownHosts}
+		n.meta.OS, n.meta.OSVersion, err = r.detectOS(ctx, c.name)
+		if err != nil {
+			r.WithError(err).Warnf("failed to detect OS of %v", c.name)
+		}
+		nodes = append(nodes, n)
+	}
+
+	r.forgetHostKeys(nodes)
+	r.pool = infra.NewNodePool(nodes, nil)
+	r.Debugf("cluster: %#v", r.pool)
+
+	if !withInstaller {
+		// No need to pick installer node
+		return nil, nil
+	}
+
+	// Use first node as installer
+	r.installerIP = nodes[0].Addr()
+	node, err := r.pool.Node(r.installerIP)
+
+	return node, trace.Wrap(err)
+}
+
+func (r *docker) Destroy(ctx context.Context) error {
+	r.Debugf("destroying docker cluster: %v", r.stateDir)
+	infra.Disconnect(r.pool.Nodes()...)
+
+	var errors []error
+	out, err := r.command(ctx, args("ps", "--all", "--quiet", "--filter", fmt.Sprintf("label=%v", r.clusterLabel())))
+	if err != nil {
+		return trace.Wrap(err, "failed to list containers: %s", out)
+	}
+	if ids := strings.Fields(string(out)); len(ids) != 0 {
+		out, err = r.command(ctx, append(args("rm", "--force", "--volumes"), ids...))
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "failed to remove containers: %s", out))
+		}
+	}
+
+	err = r.deleteSnapshots(ctx)
+	if err != nil {
+		errors = append(errors, trace.Wrap(err))
+	}
+
+	out, err = r.command(ctx, args("network", "ls", "--quiet", "--filter", fmt.Sprintf("name=%v", r.networkName())))
+	if err == nil && len(bytes.TrimSpace(out)) != 0 {
+		out, err = r.command(ctx, args("network", "rm", r.networkName()))
+	}
+	if err != nil {
+		errors = append(errors, trace.Wrap(err, "failed to remove network: %s", out))
+	}
+
+	return trace.NewAggregate(errors...)
+}
+
+func (r *docker) SelectInterface(installer infra.Node, addrs []string) (int, error) {
+	for i, addr := range addrs {
+		if addr == installer.(*node).addrIP {
+			return i, nil
+		}
+	}
+	return -1, trace.NotFound("failed to select installer interface from %v", addrs)
+}
+
+// Connect establishes an SSH connection to the specified address
+func (r *docker) Connect(addrIP string) (*ssh.Session, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Connect()
+}
+
+// Client establishes an SSH connection to the specified address
+func (r *docker) Client(addrIP string) (*ssh.Client, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Client()
+}
+
+func (r *docker) StartInstall(session *ssh.Session) error {
+	return session.Start(installerCommand)
+}
+
+func (r *docker) UploadUpdate(session *ssh.Session) error {
+	// the shared directory is mounted on all nodes
+	if err := r.syncInstallerTarball(); err != nil {
+		return trace.Wrap(err)
+	}
+	return session.Run(uploadUpdateCommand)
+}
+
+func (r *docker) NodePool() infra.NodePool {
+	return r.pool
+}
+
+func (r *docker) InstallerLogPath() string {
+	return installerLogPath
+}
+
+func (r *docker) State() infra.ProvisionerState {
+	nodes := make([]infra.StateNode, 0, r.pool.Size())
+	for _, n := range r.pool.Nodes() {
+		nodes = append(nodes, infra.StateNode{Addr: n.(*node).addrIP, KeyPath: n.(*node).identityFile}.WithMeta(n.Meta()))
+	}
+	allocated := make([]string, 0, r.pool.SizeAllocated())
+	for _, node := range r.pool.AllocatedNodes() {
+		allocated = append(allocated, node.Addr())
+	}
+	return infra.ProvisionerState{
+		Dir:           r.stateDir,
+		InstallerAddr: r.installerIP,
+		Nodes:         nodes,
+		Allocated:     allocated,
+	}
+}
+
+// buildImage builds the node image from ScriptPath
+// and returns the name of the image
+func (r *docker) buildImage(ctx context.Context) (image string, err error) {
+	out, err := r.command(ctx, args("build", "--tag", defaultImage, r.ScriptPath))
+	if err != nil {
+		return "", trace.Wrap(err, "failed to build node image: %s", out)
+	}
+	return defaultImage, nil
+}
+
+// createNetwork creates the cluster network.
+// Docker only accepts static container addresses (required to restore
+// snapshots) on networks with an explicit subnet, so the subnet docker
+// allocates is pinned by recreating the network with it
+func (r *docker) createNetwork(ctx context.Context) error {
+	out, err := r.command(ctx, args("network", "create", "--label", r.clusterLabel(), r.networkName()))
+	if err != nil {
+		return trace.Wrap(err, "failed to create network: %s", out)
+	}
+	out, err = r.command(ctx, args("network", "inspect",
+		"--format", "{{range .IPAM.Config}}{{.Subnet}} {{end}}", r.networkName()))
+	if err != nil {
+		return trace.Wrap(err, "failed to inspect network: %s", out)
+	}
+	subnets := strings.Fields(string(out))
+	if len(subnets) == 0 {
+		return trace.NotFound("no subnet allocated for network %v", r.networkName())
+	}
+	subnet := subnets[0]
+	out, err = r.command(ctx, args("network", "rm", r.networkName()))
+	if err != nil {
+		return trace.Wrap(err, "failed to remove network: %s", out)
+	}
+	out, err = r.command(ctx, args("network", "create", "--label", r.clusterLabel(),
+		"--subnet", subnet, r.networkName()))
+	if err != nil {
+		return trace.Wrap(err, "failed to create network with subnet %v: %s", subnet, out)
+	}
+	return nil
+}
+
+// startNode starts a new node container and authorizes the provisioner's SSH key
+// for the node user
+func (r *docker) startNode(ctx context.Context, image, name string, authorizedKey []byte) error {
+	err := r.runNode(ctx, image, name, "")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", name, "/bin/sh", "-c", authorizeKeyCommand)
+	err = system.ExecWithInput(cmd, string(authorizedKey), io.MultiWriter(&buf, r))
+	if err != nil {
+		return trace.Wrap(err, "failed to authorize SSH key on node %v: %s", name, buf.Bytes())
+	}
+	return nil
+}
+
+// runNode starts a new node container from the specified image.
+// If addrIP is not empty, the container is started with this address
+// on the cluster network, otherwise docker allocates one
+func (r *docker) runNode(ctx context.Context, image, name, addrIP string) error {
+	runArgs := args("run", "--detach", "--privileged",
+		"--name", name, "--hostname", name,
+		"--network", r.networkName())
+	if addrIP != "" {
+		runArgs = append(runArgs, "--ip", addrIP)
+	}
+	runArgs = append(runArgs,
+		"--label", r.clusterLabel(),
+		"--tmpfs", "/run", "--tmpfs", "/run/lock",
+		"--volume", "/sys/fs/cgroup:/sys/fs/cgroup:ro",
+		"--volume", fmt.Sprintf("%v:%v:ro", r.shareDir(), shareMountPath),
+		image)
+	out, err := r.command(ctx, runArgs)
+	if err != nil {
+		return trace.Wrap(err, "failed to start node %v: %s", name, out)
+	}
+	return nil
+}
+
+// discoverContainers returns all containers of this cluster ordered by name
+func (r *docker) discoverContainers(ctx context.Context) ([]container, error) {
+	out, err := r.command(ctx, args("ps", "--all", "--quiet", "--filter", fmt.Sprintf("label=%v", r.clusterLabel())))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list containers: %s", out)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err = r.command(ctx, append(args("inspect"), ids...))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to inspect containers: %s", out)
+	}
+
+	containers, err := parseContainers(out, r.networkName())
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to parse container details")
+	}
+	return containers, nil
+}
+
+// generateSSHKey creates a new SSH key pair in the state directory
+// and returns the public key in authorized_keys format
+func (r *docker) generateSSHKey() (authorizedKey []byte, err error) {
+	err = os.MkdirAll(filepath.Dir(r.sshKeyPath()), constants.SharedDirMask)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, sshKeyBits)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	err = ioutil.WriteFile(r.sshKeyPath(), keyPEM, 0600)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return ssh.MarshalAuthorizedKey(publicKey), nil
+}
+
+func (r *docker) syncInstallerTarball() error {
+	if r.InstallerURL == "" {
+		return nil
+	}
+	target := filepath.Join(r.shareDir(), "installer.tar.gz")
+	log.Debugf("copy %v -> %v", r.InstallerURL, target)
+	err := system.CopyFile(r.InstallerURL, target)
+	if err != nil {
+		return trace.Wrap(err, "failed to copy installer tarball %q to %q", r.InstallerURL, target)
+	}
+	return nil
+}
+
+func (r *docker) command(ctx context.Context, args []string, opts ...system.CommandOptionSetter) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var out bytes.Buffer
+	opts = append(opts, system.Dir(r.stateDir))
+	err := system.ExecL(cmd, io.MultiWriter(&out, r), r.Entry, opts...)
+	if err != nil {
+		return out.Bytes(), trace.Wrap(err, "command %q failed (args %q, wd %q)", cmd.Path, cmd.Args, cmd.Dir)
+	}
+	return out.Bytes(), nil
+}
+
+// Write implements io.Writer
+func (r *docker) Write(p []byte) (int, error) {
+	fmt.Fprint(os.Stderr, string(p))
+	return len(p), nil
+}
+
+func (r *docker) nodeName(index int) string {
+	return fmt.Sprintf("%v-node-%v", r.ClusterName, index)
+}
+
+func (r *docker) networkName() string {
+	return fmt.Sprintf("robotest-%v", r.ClusterName)
+}
+
+// detectOS returns the OS distribution and version of the container
+func (r *docker) detectOS(ctx context.Context, name string) (os, version string, err error) {
+	out, err := r.command(ctx, args("exec", name, "cat", "/etc/os-release"))
+	if err != nil {
+		return "", "", trace.Wrap(err, "failed to read OS release: %s", out)
+	}
+	os, version = infra.ParseOSRelease(out)
+	return os, version, nil
+}
+
+func (r *docker) clusterLabel() string {
+	return fmt.Sprintf("%v=%v", labelCluster, r.ClusterName)
+}
+
+func (r *docker) shareDir() string {
+	return filepath.Join(r.stateDir, "share")
+}
+
+func (r *docker) sshKeyPath() string {
+	return filepath.Join(r.stateDir, "ssh", "id_rsa")
+}
+
+func (r *node) Addr() string {
+	return r.addrIP
+}
+
+func (r *node) PrivateAddr() string {
+	return r.addrIP
+}
+
+// Meta returns the node metadata.
+// Containers are named after their hosts and form a single node group
+func (r *node) Meta() infra.NodeMeta {
+	meta := r.meta
+	meta.Hostname = r.name
+	meta.SSHUser = sshUser
+	return meta.WithLabels("0")
+}
+
+func (r *node) Connect() (*ssh.Session, error) {
+	client, err := r.Client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return session, nil
+}
+
+func (r *node) Client() (*ssh.Client, error) {
+	auth := sshutils.NewAuth(sshutils.AuthConfig{KeyPath: r.identityFile})
+	return sshutils.Client(fmt.Sprintf("%v:22", r.addrIP), sshUser, auth, sshutils.HostKeys(r.knownHosts))
+}
+
+func (r node) String() string {
+	return fmt.Sprintf("node(name=%v, addr=%v)", r.name, r.addrIP)
+}
+
+func args(opts ...string) (result []string) {
+	return opts
+}
+
+// parseContainers interprets the output of `docker inspect` and returns
+// containers ordered by name.
+// network specifies the network to take container addresses from
+func parseContainers(data []byte, network string) (containers []container, err error) {
+	var results []struct {
+		Name  string
+		State struct {
+			Running bool
+		}
+		NetworkSettings struct {
+			Networks map[string]struct {
+				IPAddress string
+			}
+		}
+	}
+	err = json.Unmarshal(data, &results)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	for _, result := range results {
+		name := strings.TrimPrefix(result.Name, "/")
+		settings, ok := result.NetworkSettings.Networks[network]
+		if result.State.Running && (!ok || settings.IPAddress == "") {
+			return nil, trace.NotFound("container %v has no address on network %v", name, network)
+		}
+		containers = append(containers, container{
+			name:    name,
+			addrIP:  settings.IPAddress,
+			running: result.State.Running,
+		})
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		if len(containers[i].name) != len(containers[j].name) {
+			return len(containers[i].name) < len(containers[j].name)
+		}
+		return containers[i].name < containers[j].name
+	})
+	return containers, nil
+}
+
+type docker struct {
+	*log.Entry
+	Config
+
+	pool        infra.NodePool
+	stateDir    string
+	installerIP string
+	// knownHosts records host keys of nodes if host keys are verified
+	knownHosts *sshutils.KnownHosts
+}
+
+type node struct {
+	name         string
+	identityFile string
+	addrIP       string
+	// meta is the node metadata besides host name and SSH user
+	meta infra.NodeMeta
+	// knownHosts records host keys of nodes if host keys are verified
+	knownHosts *sshutils.KnownHosts
+}
+
+// forgetHostKeys forgets the host keys of the specified nodes
+// as the docker network reuses addresses of removed containers
+func (r *docker) forgetHostKeys(nodes []infra.Node) {
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr())
+	}
+	if err := r.knownHosts.Forget(addrs...); err != nil {
+		r.WithError(err).Warn("failed to forget host keys")
+	}
+}
+
+// container describes a node container as reported by docker
+type container struct {
+	name    string
+	addrIP  string
+	running bool
+}
+
+const (
+	// labelCluster is the container label that groups containers of a single cluster
+	labelCluster = "io.robotest.cluster"
+	// defaultImage names the node image built from ScriptPath
+	defaultImage = "robotest-node:latest"
+	// sshUser is the node user as created by the node image
+	sshUser    = "robotest"
+	sshKeyBits = 2048
+	// shareMountPath is the location of the shared state directory on nodes
+	shareMountPath = "/robotest"
+)
+
+const authorizeKeyCommand = `
+install -d -m 0700 -o robotest -g robotest /home/robotest/.ssh && \
+cat >> /home/robotest/.ssh/authorized_keys && \
+chown robotest:robotest /home/robotest/.ssh/authorized_keys && \
+chmod 0600 /home/robotest/.ssh/authorized_keys`
+
+const installerCommand = `
+mkdir -p /home/robotest/installer; \
+tar -xvf /robotest/installer.tar.gz -C /home/robotest/installer; \
+/home/robotest/installer/install`
+
+const uploadUpdateCommand = `
+rm -rf /home/robotest/installer; mkdir -p /home/robotest/installer; \
+tar -xvf /robotest/installer.tar.gz -C /home/robotest/installer; \
+cd /home/robotest/installer/; sudo ./upload`
+
+const installerLogPath = "/home/robotest/installer/gravity.log"
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsesContainers(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		inspect  []byte
+		expected []container
+	}{
+		{
+			comment: "Orders containers by name",
+			inspect: []byte(`[
+  {
+    "Name": "/test-node-10",
+    "State": {"Running": true},
+    "NetworkSettings": {"Networks": {"robotest-test": {"IPAddress": "172.18.0.11"}}}
+  },
+  {
+    "Name": "/test-node-2",
+    "State": {"Running": true},
+    "NetworkSettings": {"Networks": {"robotest-test": {"IPAddress": "172.18.0.3"}}}
+  }
+]`),
+			expected: []container{
+				{name: "test-node-2", addrIP: "172.18.0.3", running: true},
+				{name: "test-node-10", addrIP: "172.18.0.11", running: true},
+			},
+		},
+		{
+			comment: "Handles stopped containers",
+			inspect: []byte(`[
+  {
+    "Name": "/test-node-1",
+    "State": {"Running": false},
+    "NetworkSettings": {"Networks": {}}
+  }
+]`),
+			expected: []container{{name: "test-node-1"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		obtained, err := parseContainers(testCase.inspect, "robotest-test")
+		if err != nil {
+			t.Errorf("%v: failed to parse containers: %v", testCase.comment, err)
+		}
+		if !reflect.DeepEqual(obtained, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, obtained)
+		}
+	}
+}
+
+func TestFailsOnMissingAddress(t *testing.T) {
+	inspect := []byte(`[
+  {
+    "Name": "/test-node-1",
+    "State": {"Running": true},
+    "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.2"}}}
+  }
+]`)
+
+	_, err := parseContainers(inspect, "robotest-test")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestParsesSnapshots(t *testing.T) {
+	images := []byte(`robotest-snapshot/test-node-1:installed
+robotest-snapshot/test-node-2:installed
+robotest-snapshot/test-node-1:bootstrapped
+robotest-snapshot/test-node-2:bootstrapped
+robotest-snapshot/test-node-1:partial
+robotest-node:latest
+`)
+	expected := []string{"bootstrapped", "installed"}
+
+	obtained := parseSnapshots(images, []string{"test-node-1", "test-node-2"})
+	if !reflect.DeepEqual(obtained, expected) {
+		t.Errorf("expected %v but got %v", expected, obtained)
+	}
+}
@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// Snapshot commits all node containers as images tagged with the snapshot name
+func (r *docker) Snapshot(ctx context.Context, name string) error {
+	if !snapshotName.MatchString(name) {
+		return trace.BadParameter("invalid snapshot name %q", name)
+	}
+	for _, node := range r.nodes() {
+		out, err := r.command(ctx, args("commit",
+			"--change", fmt.Sprintf("LABEL %v", r.clusterLabel()),
+			node.name, snapshotImage(node.name, name)))
+		if err != nil {
+			return trace.Wrap(err, "failed to snapshot node %v: %s", node.name, out)
+		}
+	}
+	return nil
+}
+
+// Restore replaces all node containers with containers started from the
+// snapshot images.
+// Containers are restarted with their previous addresses as the cluster
+// installed on the nodes is bound to them
+func (r *docker) Restore(ctx context.Context, name string) error {
+	nodes := r.nodes()
+	for _, node := range nodes {
+		image := snapshotImage(node.name, name)
+		out, err := r.command(ctx, args("images", "--quiet", image))
+		if err != nil {
+			return trace.Wrap(err, "failed to query image %v: %s", image, out)
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			return trace.NotFound("no snapshot %v for node %v", name, node.name)
+		}
+	}
+
+	// all containers are removed first to release their addresses
+	for _, node := range nodes {
+		out, err := r.command(ctx, args("rm", "--force", "--volumes", node.name))
+		if err != nil {
+			return trace.Wrap(err, "failed to remove node %v: %s", node.name, out)
+		}
+	}
+	for _, node := range nodes {
+		err := r.runNode(ctx, snapshotImage(node.name, name), node.name, node.addrIP)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	containers, err := r.discoverContainers(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	addrs := make(map[string]string, len(containers))
+	for _, c := range containers {
+		if c.running {
+			addrs[c.name] = c.addrIP
+		}
+	}
+	for _, node := range nodes {
+		if addrs[node.name] != node.addrIP {
+			return trace.BadParameter("container %v is not running with address %v", node.name, node.addrIP)
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns the names of the snapshots available for all node containers
+func (r *docker) ListSnapshots(ctx context.Context) ([]string, error) {
+	out, err := r.command(ctx, args("images",
+		"--filter", fmt.Sprintf("label=%v", r.clusterLabel()),
+		"--format", "{{.Repository}}:{{.Tag}}"))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list images: %s", out)
+	}
+	var names []string
+	for _, node := range r.nodes() {
+		names = append(names, node.name)
+	}
+	return parseSnapshots(out, names), nil
+}
+
+// deleteSnapshots removes all snapshot images of this cluster
+func (r *docker) deleteSnapshots(ctx context.Context) error {
+	out, err := r.command(ctx, args("images", "--quiet",
+		"--filter", fmt.Sprintf("label=%v", r.clusterLabel()),
+		"--filter", fmt.Sprintf("reference=%v/*", snapshotRepository)))
+	if err != nil {
+		return trace.Wrap(err, "failed to list snapshots: %s", out)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil
+	}
+	out, err = r.command(ctx, append(args("rmi", "--force"), ids...))
+	if err != nil {
+		return trace.Wrap(err, "failed to remove snapshots: %s", out)
+	}
+	return nil
+}
+
+// nodes returns all nodes of the pool
+func (r *docker) nodes() []*node {
+	var nodes []*node
+	for _, n := range r.pool.Nodes() {
+		nodes = append(nodes, n.(*node))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes
+}
+
+// parseSnapshots interprets the list of images as <repository>:<tag> lines
+// and returns the sorted names of snapshots available for all specified containers
+func parseSnapshots(images []byte, containers []string) []string {
+	counts := make(map[string]int)
+	s := bufio.NewScanner(bytes.NewReader(images))
+	for s.Scan() {
+		image := strings.TrimSpace(s.Text())
+		for _, container := range containers {
+			prefix := snapshotImage(container, "")
+			if strings.HasPrefix(image, prefix) {
+				counts[strings.TrimPrefix(image, prefix)]++
+			}
+		}
+	}
+	var snapshots []string
+	for snapshot, count := range counts {
+		if count == len(containers) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots
+}
+
+// snapshotImage returns the name of the image with the snapshot of the specified container
+func snapshotImage(container, snapshot string) string {
+	return fmt.Sprintf("%v/%v:%v", snapshotRepository, container, snapshot)
+}
+
+const (
+	// snapshotRepository is the repository prefix for snapshot images
+	snapshotRepository = "robotest-snapshot"
+)
+
+// snapshotName defines valid snapshot names as snapshots are image tags
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
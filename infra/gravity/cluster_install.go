@@ -24,8 +24,17 @@ func (c *TestContext) FromPreviousInstall(nodes []Gravity, subdir string) {
 	}
 }
 
-// ProvisionInstaller deploys a specific installer
+// SetInstaller deploys a specific installer to nodes,
+// see SetInstallerDistribution for how the installer gets to nodes
 func (c *TestContext) SetInstaller(nodes []Gravity, installerUrl string, tag string) error {
+	switch c.distribution.Strategy {
+	case "", DistributeDirect:
+	case DistributeTree:
+		return trace.Wrap(c.setInstallerTree(nodes, installerUrl, tag))
+	default:
+		return trace.BadParameter("unknown installer distribution strategy %q", c.distribution.Strategy)
+	}
+
 	ctx, cancel := context.WithTimeout(c.parent, c.timeouts.Install)
 	defer cancel()
 
@@ -58,12 +67,12 @@ func (c *TestContext) OfflineInstall(nodes []Gravity, param InstallParam) error
 		return trace.NotFound("at least one node")
 	}
 
-	master := nodes[0].(*gravity)
+	master := nodes[0]
 	if param.Token == "" {
 		param.Token = "ROBOTEST"
 	}
 	if param.Cluster == "" {
-		param.Cluster = master.param.Tag()
+		param.Cluster = c.clusterTag(master)
 	}
 
 	errs := make(chan error, len(nodes))
@@ -96,12 +105,21 @@ func (c *TestContext) OfflineInstall(nodes []Gravity, param InstallParam) error
 		_, err = master.RunInPlanet(ctx, "/usr/bin/gravity",
 			"site", "complete", "--support=on", "--insecure",
 			fmt.Sprintf("--ops-url=%s", localOpsCenterURL),
-			master.param.Tag())
+			c.clusterTag(master))
 	}
 
 	return trace.Wrap(err)
 }
 
+// clusterTag returns the tag of the configuration the node was provisioned with.
+// Nodes of the fake cluster use the configuration of the test context set by provisionFake
+func (c *TestContext) clusterTag(node Gravity) string {
+	if g, ok := node.(*gravity); ok {
+		return g.param.Tag()
+	}
+	return c.params.Tag()
+}
+
 func makePassword() string {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	const chars = "0123456789abcdefghijklmnopqrstuvwxyz"
@@ -45,7 +45,7 @@ func (c *TestContext) Expand(current, extra []Gravity, p InstallParam) error {
 
 func waitEtcdHealthOk(ctx context.Context, node Gravity) func() error {
 	return func() error {
-		exitCode, err := sshutils.RunAndParse(ctx, node.Client(), node.Logger(),
+		exitCode, err := sshutils.RunAndParse(ctx, node.Client(ctx), node.Logger(),
 			`sudo /usr/bin/gravity enter -- --notty /usr/bin/etcdctl -- cluster-health`,
 			nil, sshutils.ParseDiscard)
 		if err == nil {
@@ -3,31 +3,54 @@ package gravity
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"unicode"
 
 	"github.com/gravitational/robotest/infra"
 
 	"github.com/go-yaml/yaml"
+	"github.com/gravitational/trace"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/go-playground/validator.v9"
 )
 
 // ProvisionerConfig defines parameters required to provision hosts
-// CloudProvider, AWS, Azure, ScriptPath and InstallerURL
+// Provisioner, CloudProvider, AWS, Azure, GCP, ScriptPath and InstallerURL
 type ProvisionerConfig struct {
-	// DeployTo defines cloud to deploy to
-	CloudProvider string `yaml:"cloud" validate:"required,eq=aws|eq=azure"`
+	// Provisioner names the provisioner to create nodes with, defaults to terraform
+	Provisioner string `yaml:"provisioner"`
+	// DeployTo defines cloud to deploy to. Required with cloud provisioners, i.e. terraform
+	CloudProvider string `yaml:"cloud" validate:"omitempty,eq=aws|eq=azure|eq=gcp"`
 	// AWS defines AWS connection parameters
 	AWS *infra.AWSConfig `yaml:"aws"`
 	// Azure defines Azure connection parameters
 	Azure *infra.AzureConfig `yaml:"azure"`
+	// GCP defines Google Compute Engine connection parameters
+	GCP *infra.GCPConfig `yaml:"gcp"`
+	// JumpHost optionally defines the SSH bastion host to connect to nodes through
+	JumpHost *infra.JumpHostConfig `yaml:"jump_host"`
+	// SSHAuth optionally configures additional methods to authenticate to nodes with
+	SSHAuth *infra.SSHAuthConfig `yaml:"ssh_auth"`
+	// VerifyHostKeys enables SSH host key verification with trust on first use
+	VerifyHostKeys bool `yaml:"verify_host_keys"`
+	// NodeSelector optionally selects the provisioned nodes to test with by their labels,
+	// i.e. "os=centos" or "role=master" for the inventory hosts with the label, see infra.ParseSelector
+	NodeSelector string `yaml:"node_selector"`
 
-	// ScriptPath is the path to the terraform script or directory for provisioning
+	// ScriptPath is the path to the provisioner script:
+	// terraform directory, Vagrantfile, Dockerfile directory or the inventory file
 	ScriptPath string `yaml:"script_path" validate:"required"`
 	// InstallerURL is AWS S3 URL with the installer
 	InstallerURL string `yaml:"installer_url" validate:"required,url`
 	// StateDir defines base directory where to keep state (i.e. terraform configs/vars)
 	StateDir string `yaml:"state_dir" validate:"required"`
+	// SSHUser optionally overrides the SSH user to connect to nodes as
+	SSHUser string `yaml:"ssh_user"`
+	// DockerDevice optionally overrides the block device for docker data.
+	// Cloud provisioners default to the device of the cloud configuration
+	DockerDevice string `yaml:"docker_device"`
 
 	// Tag will group provisioned resources under for easy removal afterwards
 	tag string `validate:"required"`
@@ -35,10 +58,18 @@ type ProvisionerConfig struct {
 	nodeCount uint `validate:"gte=1"`
 	// OS defines one of supported operating systems
 	os string `validate:"required,eq=ubuntu|eq=debian|eq=redhat|eq=centos"`
+	// nodeGroups optionally splits nodes into groups with own OS and instance type.
+	// The last group takes the nodes not claimed by other groups
+	nodeGroups []infra.NodeGroup
 	// dockerStorageDriver defines Docker storage driver
 	storageDriver string `validate:"required,eq=overlay|overlay2|devicemapper|loopback"`
 	// dockerDevice is a physical volume where docker data would be stored
 	dockerDevice string `validate:"required"`
+	// spareNodes is the number of nodes to provision up front for TestContext.AddNodes
+	// if the provisioner does not support adding nodes, see WithSpareNodes
+	spareNodes uint
+	// fakeSetup optionally scripts the simulated cluster of the fake provisioner, see WithFake
+	fakeSetup func(*FakeCluster)
 }
 
 // LoadConfig loads essential parameters from YAML
@@ -53,9 +84,21 @@ func LoadConfig(t *testing.T, configBytes []byte, cfg *ProvisionerConfig) {
 	case "aws":
 		require.NotNil(t, cfg.AWS)
 		cfg.dockerDevice = cfg.AWS.DockerDevice
+	case "gcp":
+		require.NotNil(t, cfg.GCP)
+		cfg.dockerDevice = cfg.GCP.DockerDevice
+	case "":
+		// not a cloud provisioner
 	default:
-		t.Fatal("unknown cloud provider %s", cfg.CloudProvider)
+		t.Fatalf("unknown cloud provider %s", cfg.CloudProvider)
 	}
+
+	if cfg.DockerDevice != "" {
+		cfg.dockerDevice = cfg.DockerDevice
+	}
+
+	_, err = infra.ParseSelector(cfg.NodeSelector)
+	require.NoError(t, err)
 }
 
 // Tag returns current tag of a config
@@ -88,6 +131,14 @@ func (config ProvisionerConfig) WithNodes(nodes uint) ProvisionerConfig {
 	return cfg
 }
 
+// WithSpareNodes returns copy of config that reserves nodes for TestContext.AddNodes.
+// Provisioners that cannot add nodes on demand create the spare nodes along with the others
+func (config ProvisionerConfig) WithSpareNodes(nodes uint) ProvisionerConfig {
+	cfg := config
+	cfg.spareNodes = nodes
+	return cfg
+}
+
 // WithOS returns copy of config with specific OS
 func (config ProvisionerConfig) WithOS(os string) ProvisionerConfig {
 	cfg := config
@@ -98,6 +149,87 @@ func (config ProvisionerConfig) WithOS(os string) ProvisionerConfig {
 	return cfg
 }
 
+// WithNodeGroups returns copy of config with nodes split into the specified groups.
+// The OS of the config is that of the first group
+func (config ProvisionerConfig) WithNodeGroups(groups ...infra.NodeGroup) ProvisionerConfig {
+	extra := nodeGroupsTag(groups)
+
+	cfg := config
+	cfg.nodeGroups = groups
+	if len(groups) != 0 {
+		cfg.os = groups[0].OS
+	}
+	cfg.tag = fmt.Sprintf("%s-%s", cfg.tag, extra)
+	cfg.StateDir = filepath.Join(cfg.StateDir, extra)
+
+	return cfg
+}
+
+// osTag returns the OS flavor or the mix of node groups of the config
+func (config ProvisionerConfig) osTag() string {
+	if len(config.nodeGroups) == 0 {
+		return config.os
+	}
+	return nodeGroupsTag(config.nodeGroups)
+}
+
+// ParseNodeGroups parses the node groups specification of the form
+// os[/instance_type][:count][+os[/instance_type][:count]...], i.e. centos/c4.2xlarge:1+ubuntu.
+// Only the last group may omit the count as it takes the remaining nodes
+func ParseNodeGroups(spec string) (groups []infra.NodeGroup, err error) {
+	parts := strings.Split(spec, "+")
+	for i, part := range parts {
+		var group infra.NodeGroup
+		flavor, count := part, ""
+		if idx := strings.Index(part, ":"); idx != -1 {
+			flavor, count = part[:idx], part[idx+1:]
+			group.Count, err = strconv.Atoi(count)
+			if err != nil || group.Count <= 0 {
+				return nil, trace.BadParameter("invalid node count %q in %q", count, spec)
+			}
+		} else if i != len(parts)-1 {
+			return nil, trace.BadParameter("node count is required for all but the last group in %q", spec)
+		}
+		if idx := strings.Index(flavor, "/"); idx != -1 {
+			flavor, group.InstanceType = flavor[:idx], flavor[idx+1:]
+			if group.InstanceType == "" {
+				return nil, trace.BadParameter("empty instance type in %q", spec)
+			}
+		}
+		switch flavor {
+		case "ubuntu", "debian", "redhat", "centos":
+		default:
+			return nil, trace.BadParameter("unsupported OS %q in %q", flavor, spec)
+		}
+		group.OS = flavor
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// nodeGroupsTag encodes node groups for use in resource tags and state directories,
+// i.e. centos/c4.2xlarge:1+ubuntu becomes centos1-c42xlarge-ubuntu.
+// A single group without count or instance type is encoded as its OS
+func nodeGroupsTag(groups []infra.NodeGroup) string {
+	var parts []string
+	for _, group := range groups {
+		part := group.OS
+		if group.Count != 0 {
+			part = fmt.Sprintf("%s%d", part, group.Count)
+		}
+		parts = append(parts, part)
+		if group.InstanceType != "" {
+			parts = append(parts, strings.Map(func(r rune) rune {
+				if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+					return unicode.ToLower(r)
+				}
+				return -1
+			}, group.InstanceType))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
 // WithStorageDriver returns copy of config with specific storage driver
 func (config ProvisionerConfig) WithStorageDriver(storageDriver string) ProvisionerConfig {
 	cfg := config
@@ -113,6 +245,15 @@ func (config ProvisionerConfig) WithStorageDriver(storageDriver string) Provisio
 	return cfg
 }
 
+// WithFake returns copy of config that provisions simulated nodes instead of VMs, see FakeCluster.
+// setup is called with the cluster of every provisioning, i.e. to script failures, and can be nil
+func (config ProvisionerConfig) WithFake(setup func(*FakeCluster)) ProvisionerConfig {
+	cfg := config
+	cfg.Provisioner = FakeProvisioner
+	cfg.fakeSetup = setup
+	return cfg
+}
+
 // validateConfig checks that key parameters are present
 func validateConfig(t *testing.T, config ProvisionerConfig) {
 	err := validator.New().Struct(&config)
@@ -13,9 +13,17 @@ var clockSyncTimeout = time.Minute * 15
 // default timeout to wait for I/O stabilize on VMs
 var diskWaitTimeout = time.Minute * 10
 
+// default timeout to take or restore a snapshot of all nodes
+var snapshotTimeout = time.Minute * 15
+
+// default timeout to add or remove nodes
+var scaleTimeout = time.Minute * 15
+
 const (
 	retrySSH    = time.Second * 10
 	deadlineSSH = time.Minute * 5 // abort if we can't get it within this reasonable period
+	// shutdownTimeout limits waiting for the connection to drop on shutdown or reboot
+	shutdownTimeout = time.Minute
 
 	// minimum required disk speed (10MB/s)
 	minDiskSpeed = uint64(1e7)
@@ -29,3 +37,10 @@ var DefaultTimeouts = OpTimeouts{
 	Leave:       time.Minute * 15, // threshold to leave cluster
 	CollectLogs: time.Minute * 7,  // to collect logs from node
 }
+
+// DefaultInstallerDistribution defines how tests distribute installers to nodes
+// unless they select another strategy with TestContext.SetInstallerDistribution
+var DefaultInstallerDistribution = InstallerDistribution{
+	Strategy: DistributeDirect,
+	Fanout:   2, // peers served by each node with DistributeTree
+}
@@ -0,0 +1,734 @@
+package gravity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/gravitational/trace"
+	"github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// FakeProvisioner names the provisioner of simulated nodes, see FakeCluster
+const FakeProvisioner = "fake"
+
+// provisionFake provisions the simulated nodes of a new FakeCluster
+func (c *TestContext) provisionFake(cfg ProvisionerConfig) ([]Gravity, DestroyFn, error) {
+	if cfg.nodeCount < 1 {
+		return nil, nil, trace.BadParameter("at least one node required")
+	}
+	cluster := newFakeCluster(int(cfg.nodeCount), c.Logger())
+	if cfg.fakeSetup != nil {
+		cfg.fakeSetup(cluster)
+	}
+	c.params = cloudDynamicParams{ProvisionerConfig: cfg, provisioner: FakeProvisioner}
+	nodes := cluster.Nodes()
+	c.nodes = append([]Gravity(nil), nodes...)
+	c.fake, c.scaler, c.spares = cluster, nil, nil
+
+	c.Logger().WithField("nodes", nodes).Debug("Provisioned simulated nodes")
+	return nodes, wrapDestroyFn(c, cfg.Tag(), cluster.destroy), nil
+}
+
+// FakeCluster simulates the nodes of a test and the cluster they form,
+// so that test suites can be unit-tested without cloud resources.
+//
+// Nodes implement Gravity and change the cluster state like real nodes:
+// the node that installs the cluster becomes the API master and the cluster master,
+// the first members to join run the backups of the cluster master,
+// and members that leave or are removed hand their roles over to the remaining ones.
+// Queries of TestContext.NodesByRole and RelocateClusterMaster are answered by RunInPlanet.
+// Operations are recorded with unique IDs, see Operations.
+// Any method fails as scripted with Fail
+type FakeCluster struct {
+	log logrus.FieldLogger
+
+	mu    sync.Mutex
+	nodes []*fakeNode
+	// name is the name of the installed cluster
+	name string
+	// token is the join token of the cluster
+	token string
+	// installed is closed once the cluster has been installed
+	installed chan struct{}
+	// members lists the cluster members in join order
+	members []*fakeNode
+	// apiMaster is the member running the Kubernetes API server
+	apiMaster *fakeNode
+	// sites lists the gravity-site pods, the ready one runs on the cluster master
+	sites []*fakePod
+	// podSeq generates the names of gravity-site pods
+	podSeq     int
+	operations []FakeOperation
+	failures   []*fakeFailure
+	// latency is how long operations take
+	latency   time.Duration
+	destroyed bool
+}
+
+// FakeOperation is an operation performed on the simulated cluster
+type FakeOperation struct {
+	// ID uniquely identifies the operation
+	ID string
+	// Type is the type of the operation: install, join, leave, remove, upload or upgrade
+	Type string
+	// Node is the private address of the node that ran the operation,
+	// or the removed node for remove operations
+	Node string
+}
+
+type fakePod struct {
+	name  string
+	node  *fakeNode
+	ready bool
+}
+
+type fakeFailure struct {
+	method string
+	addr   string
+	// left is the number of calls left to fail, negative to fail all calls
+	left int
+	err  error
+}
+
+// maxClusterMasters is the number of members running gravity-site
+const maxClusterMasters = 3
+
+func newFakeCluster(count int, log logrus.FieldLogger) *FakeCluster {
+	cluster := &FakeCluster{
+		log:       log,
+		installed: make(chan struct{}),
+	}
+	cluster.grow(count)
+	return cluster
+}
+
+// grow adds count nodes numbered after the existing ones and returns them
+func (r *FakeCluster) grow(count int) []Gravity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var nodes []Gravity
+	for i := len(r.nodes) + 1; len(nodes) < count; i++ {
+		node := &fakeNode{
+			cluster: r,
+			vm: fakeVM{
+				addr:        fmt.Sprintf("192.0.2.%d", i),
+				privateAddr: fmt.Sprintf("10.0.0.%d", i),
+				hostname:    fmt.Sprintf("node-%d", i),
+			},
+		}
+		node.log = r.log.WithFields(logrus.Fields{"ip": node.vm.privateAddr, "hostname": node.vm.hostname})
+		r.nodes = append(r.nodes, node)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// shrink destroys the specified nodes which must be the most recently added ones
+func (r *FakeCluster) shrink(nodes []Gravity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var addrs, removed []string
+	for _, node := range r.nodes {
+		addrs = append(addrs, node.vm.privateAddr)
+	}
+	for _, node := range nodes {
+		removed = append(removed, node.Node().PrivateAddr())
+	}
+	if err := infra.CheckTrailing(addrs, removed); err != nil {
+		return trace.Wrap(err)
+	}
+	r.nodes = r.nodes[:len(r.nodes)-len(nodes)]
+	return nil
+}
+
+// Nodes returns the simulated nodes
+func (r *FakeCluster) Nodes() []Gravity {
+	var nodes []Gravity
+	for _, node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Fail makes calls of the Gravity method, i.e. Install, fail with err on the node with the private address addr,
+// or on all nodes if addr is empty. times limits the number of failing calls, 0 fails all calls
+func (r *FakeCluster) Fail(method, addr string, times int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if times == 0 {
+		times = -1
+	}
+	r.failures = append(r.failures, &fakeFailure{method: method, addr: addr, left: times, err: err})
+}
+
+// SetLatency makes operations take d unless the context expires first
+func (r *FakeCluster) SetLatency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latency = d
+}
+
+// Operations returns the operations performed on the cluster in the order they completed
+func (r *FakeCluster) Operations() []FakeOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FakeOperation(nil), r.operations...)
+}
+
+// Members returns the private addresses of the cluster members in join order
+func (r *FakeCluster) Members() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var addrs []string
+	for _, member := range r.members {
+		addrs = append(addrs, member.vm.privateAddr)
+	}
+	return addrs
+}
+
+// APIMaster returns the private address of the member running the Kubernetes API server
+func (r *FakeCluster) APIMaster() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.apiMaster == nil {
+		return ""
+	}
+	return r.apiMaster.vm.privateAddr
+}
+
+// ClusterMaster returns the private address of the member running the gravity-site master
+func (r *FakeCluster) ClusterMaster() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, pod := range r.sites {
+		if pod.ready {
+			return pod.node.vm.privateAddr
+		}
+	}
+	return ""
+}
+
+// Destroyed returns true if the nodes have been destroyed
+func (r *FakeCluster) Destroyed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.destroyed
+}
+
+func (r *FakeCluster) destroy(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.destroyed = true
+	return nil
+}
+
+// call returns the scripted failure of the method on the node, if any
+func (r *FakeCluster) call(node *fakeNode, method string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, failure := range r.failures {
+		if failure.method != method || (failure.addr != "" && failure.addr != node.vm.privateAddr) || failure.left == 0 {
+			continue
+		}
+		if failure.left > 0 {
+			failure.left--
+		}
+		return trace.Wrap(failure.err)
+	}
+	if r.destroyed {
+		return trace.ConnectionProblem(nil, "%v has been destroyed", node)
+	}
+	return nil
+}
+
+// wait simulates the latency of an operation
+func (r *FakeCluster) wait(ctx context.Context) error {
+	r.mu.Lock()
+	latency := r.latency
+	r.mu.Unlock()
+	select {
+	case <-time.After(latency):
+		return nil
+	case <-ctx.Done():
+		return trace.Wrap(ctx.Err())
+	}
+}
+
+// record records the completed operation, r.mu is held
+func (r *FakeCluster) record(opType string, node *fakeNode) {
+	op := FakeOperation{ID: uuid.NewV4().String(), Type: opType, Node: node.vm.privateAddr}
+	r.operations = append(r.operations, op)
+	node.log.WithFields(logrus.Fields{"operation": op.ID, "type": op.Type}).Info("operation completed")
+}
+
+// member returns the index of the node in the members, -1 if not a member. r.mu is held
+func (r *FakeCluster) member(node *fakeNode) int {
+	for i, member := range r.members {
+		if member == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// join adds the node to the members, r.mu is held
+func (r *FakeCluster) join(node *fakeNode) {
+	r.members = append(r.members, node)
+	if r.apiMaster == nil {
+		r.apiMaster = node
+	}
+	r.scheduleSites()
+}
+
+// evict removes the node from the members and hands its roles over to the remaining ones. r.mu is held
+func (r *FakeCluster) evict(node *fakeNode) {
+	idx := r.member(node)
+	if idx == -1 {
+		return
+	}
+	r.members = append(r.members[:idx:idx], r.members[idx+1:]...)
+
+	wasMaster := false
+	sites := r.sites[:0]
+	for _, pod := range r.sites {
+		if pod.node == node {
+			wasMaster = pod.ready
+			continue
+		}
+		sites = append(sites, pod)
+	}
+	r.sites = sites
+	if wasMaster && len(r.sites) != 0 {
+		r.sites[0].ready = true
+	}
+	if r.apiMaster == node {
+		r.apiMaster = nil
+		if len(r.sites) != 0 {
+			r.apiMaster = r.sites[0].node
+		} else if len(r.members) != 0 {
+			r.apiMaster = r.members[0]
+		}
+	}
+	r.scheduleSites()
+}
+
+// scheduleSites runs gravity-site on members until there are enough, r.mu is held
+func (r *FakeCluster) scheduleSites() {
+	for _, member := range r.members {
+		if len(r.sites) >= maxClusterMasters {
+			return
+		}
+		if r.site(member) != nil {
+			continue
+		}
+		r.sites = append(r.sites, r.newPod(member, len(r.sites) == 0))
+	}
+}
+
+// site returns the gravity-site pod of the node, r.mu is held
+func (r *FakeCluster) site(node *fakeNode) *fakePod {
+	for _, pod := range r.sites {
+		if pod.node == node {
+			return pod
+		}
+	}
+	return nil
+}
+
+func (r *FakeCluster) newPod(node *fakeNode, ready bool) *fakePod {
+	r.podSeq++
+	return &fakePod{name: fmt.Sprintf("gravity-site-%d", r.podSeq), node: node, ready: ready}
+}
+
+// deletePod deletes the gravity-site pod, which is recreated on the same node.
+// Deleting the master pod moves the cluster master to the next node. r.mu is held
+func (r *FakeCluster) deletePod(name string) error {
+	for i, pod := range r.sites {
+		if pod.name != name {
+			continue
+		}
+		r.sites[i] = r.newPod(pod.node, false)
+		if pod.ready {
+			r.sites[(i+1)%len(r.sites)].ready = true
+		}
+		return nil
+	}
+	return trace.NotFound("pods %q not found", name)
+}
+
+// fakeNode is a simulated node of FakeCluster
+type fakeNode struct {
+	cluster *FakeCluster
+	vm      fakeVM
+	log     logrus.FieldLogger
+
+	// the following fields are guarded by cluster.mu
+	offline    bool
+	installer  string
+	installDir string
+}
+
+func (g *fakeNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"public_ip": g.vm.addr,
+		"ip":        g.vm.privateAddr,
+	})
+}
+
+func (g *fakeNode) SetInstaller(ctx context.Context, installerUrl string, subdir string) error {
+	if err := g.cluster.call(g, "SetInstaller"); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.cluster.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	if err := g.checkOnline(); err != nil {
+		return trace.Wrap(err)
+	}
+	g.installer = installerUrl
+	g.installDir = filepath.Join("/home/robotest", subdir)
+	return nil
+}
+
+func (g *fakeNode) Install(ctx context.Context, param InstallParam) error {
+	if err := g.cluster.call(g, "Install"); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.cluster.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkInstaller(); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.name != "" {
+		return trace.AlreadyExists("cluster %v has already been installed", r.name)
+	}
+	r.name, r.token = param.Cluster, param.Token
+	if r.token == "" {
+		r.token = uuid.NewV4().String()
+	}
+	r.join(g)
+	r.record("install", g)
+	close(r.installed)
+	return nil
+}
+
+func (g *fakeNode) Status(ctx context.Context) (*GravityStatus, error) {
+	if err := g.cluster.call(g, "Status"); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkMember(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	status := &GravityStatus{
+		Application: "fake",
+		Cluster:     r.name,
+		Status:      "active",
+		Token:       r.token,
+	}
+	for _, member := range r.members {
+		status.Nodes = append(status.Nodes, member.vm.privateAddr)
+	}
+	return status, nil
+}
+
+func (g *fakeNode) OfflineUpdate(ctx context.Context, installerUrl string) error {
+	return nil
+}
+
+// Join joins the cluster, waiting for the installation to complete like a real node
+func (g *fakeNode) Join(ctx context.Context, param JoinCmd) error {
+	if err := g.cluster.call(g, "Join"); err != nil {
+		return trace.Wrap(err)
+	}
+	r := g.cluster
+	select {
+	case <-r.installed:
+	case <-ctx.Done():
+		return trace.Wrap(ctx.Err(), "cluster has not been installed")
+	}
+	if err := r.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkInstaller(); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.member(g) != -1 {
+		return trace.AlreadyExists("%v is already a member of the cluster", g)
+	}
+	peer := r.lookup(param.PeerAddr)
+	if peer == nil || r.member(peer) == -1 || peer.offline {
+		return trace.ConnectionProblem(nil, "peer %v is not available", param.PeerAddr)
+	}
+	if param.Token != r.token {
+		return trace.AccessDenied("invalid join token")
+	}
+	r.join(g)
+	r.record("join", g)
+	return nil
+}
+
+func (g *fakeNode) Leave(ctx context.Context, graceful Graceful) error {
+	if err := g.cluster.call(g, "Leave"); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.cluster.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkMember(); err != nil {
+		return trace.Wrap(err)
+	}
+	r.evict(g)
+	r.record("leave", g)
+	return nil
+}
+
+func (g *fakeNode) Remove(ctx context.Context, node string, graceful Graceful) error {
+	if err := g.cluster.call(g, "Remove"); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.cluster.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkMember(); err != nil {
+		return trace.Wrap(err)
+	}
+	removed := r.lookup(node)
+	if removed == nil || r.member(removed) == -1 {
+		return trace.NotFound("%v is not a member of the cluster", node)
+	}
+	if bool(graceful) && removed.offline {
+		return trace.ConnectionProblem(nil, "%v is offline, remove it with force", node)
+	}
+	r.evict(removed)
+	r.record("remove", removed)
+	return nil
+}
+
+func (g *fakeNode) Uninstall(ctx context.Context) error {
+	if err := g.cluster.call(g, "Uninstall"); err != nil {
+		return trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkOnline(); err != nil {
+		return trace.Wrap(err)
+	}
+	if r.member(g) != -1 {
+		return trace.BadParameter("%v has to leave the cluster first", g)
+	}
+	g.installer, g.installDir = "", ""
+	return nil
+}
+
+func (g *fakeNode) PowerOff(ctx context.Context, graceful Graceful) error {
+	if err := g.cluster.call(g, "PowerOff"); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	g.offline = true
+	return nil
+}
+
+func (g *fakeNode) PowerOn(ctx context.Context) error {
+	if err := g.cluster.call(g, "PowerOn"); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	g.offline = false
+	return nil
+}
+
+func (g *fakeNode) Reboot(ctx context.Context, graceful Graceful) error {
+	if err := g.cluster.call(g, "Reboot"); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	return trace.Wrap(g.checkOnline())
+}
+
+func (g *fakeNode) CollectLogs(ctx context.Context, prefix string) (localPath string, err error) {
+	if err := g.cluster.call(g, "CollectLogs"); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return filepath.Join("node-logs", prefix, g.vm.privateAddr), nil
+}
+
+func (g *fakeNode) Upload(ctx context.Context) error {
+	return trace.Wrap(g.clusterOp(ctx, "Upload", "upload"))
+}
+
+func (g *fakeNode) Upgrade(ctx context.Context) error {
+	return trace.Wrap(g.clusterOp(ctx, "Upgrade", "upgrade"))
+}
+
+// clusterOp runs the cluster operation with the current installer of the node
+func (g *fakeNode) clusterOp(ctx context.Context, method, opType string) error {
+	if err := g.cluster.call(g, method); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.cluster.wait(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	if err := g.checkMember(); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := g.checkInstaller(); err != nil {
+		return trace.Wrap(err)
+	}
+	g.cluster.record(opType, g)
+	return nil
+}
+
+// RunInPlanet answers the DNS and kubectl queries of TestContext.NodesByRole and RelocateClusterMaster
+func (g *fakeNode) RunInPlanet(ctx context.Context, cmd string, args ...string) (string, error) {
+	if err := g.cluster.call(g, "RunInPlanet"); err != nil {
+		return "", trace.Wrap(err)
+	}
+	r := g.cluster
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := g.checkMember(); err != nil {
+		return "", trace.Wrap(err)
+	}
+	command := strings.Join(append([]string{cmd}, args...), " ")
+	switch {
+	case command == "/usr/bin/dig +short apiserver":
+		return fmt.Sprintf("%v\n", r.apiMaster.vm.privateAddr), nil
+	case strings.HasPrefix(command, "/usr/bin/kubectl get pods -n kube-system"):
+		var out []string
+		for _, pod := range r.sites {
+			ready := "False"
+			if pod.ready {
+				ready = "True"
+			}
+			out = append(out, fmt.Sprintf("%v,%v,%v", pod.name, ready, pod.node.vm.privateAddr))
+		}
+		return strings.Join(out, "\n"), nil
+	case len(args) == 5 && strings.HasPrefix(command, "/usr/bin/kubectl delete po -n kube-system"):
+		if err := r.deletePod(args[4]); err != nil {
+			return fmt.Sprintf("Error from server (NotFound): %v", err), nil
+		}
+		return fmt.Sprintf("pod %q deleted", args[4]), nil
+	}
+	return "", trace.NotImplemented("%q is not simulated", command)
+}
+
+func (g *fakeNode) Node() infra.Node {
+	return g.vm
+}
+
+func (g *fakeNode) Offline() bool {
+	g.cluster.mu.Lock()
+	defer g.cluster.mu.Unlock()
+	return g.offline
+}
+
+// Client returns nil as simulated nodes cannot be connected to
+func (g *fakeNode) Client(ctx context.Context) *ssh.Client {
+	return nil
+}
+
+func (g *fakeNode) String() string {
+	return fmt.Sprintf("%s(%s/%s)", g.vm.hostname, g.vm.privateAddr, g.vm.addr)
+}
+
+func (g *fakeNode) Logger() logrus.FieldLogger {
+	return g.log
+}
+
+// checkOnline returns an error if the node is powered off, cluster.mu is held
+func (g *fakeNode) checkOnline() error {
+	if g.offline {
+		return trace.ConnectionProblem(nil, "%v is powered off", g)
+	}
+	return nil
+}
+
+// checkInstaller returns an error if the node has no installer, cluster.mu is held
+func (g *fakeNode) checkInstaller() error {
+	if err := g.checkOnline(); err != nil {
+		return trace.Wrap(err)
+	}
+	if g.installer == "" {
+		return trace.NotFound("no installer on %v", g)
+	}
+	return nil
+}
+
+// checkMember returns an error unless the node is an online cluster member, cluster.mu is held
+func (g *fakeNode) checkMember() error {
+	if err := g.checkOnline(); err != nil {
+		return trace.Wrap(err)
+	}
+	if g.cluster.member(g) == -1 {
+		return trace.NotFound("%v is not a member of the cluster", g)
+	}
+	return nil
+}
+
+// lookup returns the node with the private address, r.mu is held
+func (r *FakeCluster) lookup(addr string) *fakeNode {
+	for _, node := range r.nodes {
+		if node.vm.privateAddr == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// fakeVM is the infra.Node of a simulated node
+type fakeVM struct {
+	addr, privateAddr, hostname string
+}
+
+func (r fakeVM) Addr() string {
+	return r.addr
+}
+
+func (r fakeVM) PrivateAddr() string {
+	return r.privateAddr
+}
+
+func (r fakeVM) Connect() (*ssh.Session, error) {
+	return nil, trace.NotImplemented("simulated node %v cannot be connected to", r.privateAddr)
+}
+
+func (r fakeVM) Client() (*ssh.Client, error) {
+	return nil, trace.NotImplemented("simulated node %v cannot be connected to", r.privateAddr)
+}
+
+func (r fakeVM) Meta() infra.NodeMeta {
+	return infra.NodeMeta{Hostname: r.hostname, OS: "fake"}
+}
@@ -0,0 +1,227 @@
+package gravity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClusterRoles(t *testing.T) {
+	ctx := context.TODO()
+	cluster, nodes := newTestCluster(t, 4)
+
+	require.NoError(t, nodes[0].Install(ctx, InstallParam{Cluster: "test"}))
+	status, err := nodes[0].Status(ctx)
+	require.NoError(t, err)
+	for _, node := range nodes[1:] {
+		err = node.Join(ctx, JoinCmd{PeerAddr: "10.0.0.1", Token: status.Token})
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, cluster.Members())
+
+	apiMaster, err := ResolveInPlanet(ctx, nodes[3], "apiserver")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", apiMaster)
+	pods, err := KubectlGetPods(ctx, nodes[3], kubeSystemNS, appGravityLabel)
+	require.NoError(t, err)
+	require.Len(t, pods, 3)
+	require.Equal(t, Pod{Name: "gravity-site-1", Ready: true, NodeIP: "10.0.0.1"}, pods[0])
+	require.Equal(t, Pod{Name: "gravity-site-2", Ready: false, NodeIP: "10.0.0.2"}, pods[1])
+
+	require.NoError(t, RelocateClusterMaster(ctx, nodes[1]))
+	require.Equal(t, "10.0.0.2", cluster.ClusterMaster())
+	require.Equal(t, "10.0.0.1", cluster.APIMaster())
+
+	require.NoError(t, nodes[0].PowerOff(ctx, Graceful(false)))
+	err = nodes[1].Remove(ctx, "10.0.0.1", Graceful(true))
+	require.True(t, trace.IsConnectionProblem(err), "expected graceful removal of offline node to fail but got %v", err)
+	require.NoError(t, nodes[1].Remove(ctx, "10.0.0.1", Graceful(false)))
+	require.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}, cluster.Members())
+	require.Equal(t, "10.0.0.2", cluster.APIMaster())
+	require.Equal(t, "10.0.0.2", cluster.ClusterMaster())
+
+	pods, err = KubectlGetPods(ctx, nodes[1], kubeSystemNS, appGravityLabel)
+	require.NoError(t, err)
+	require.Len(t, pods, 3, "expected gravity-site to be scheduled on the remaining members")
+
+	var types []string
+	for _, op := range cluster.Operations() {
+		types = append(types, op.Type)
+	}
+	require.Equal(t, []string{"install", "join", "join", "join", "remove"}, types)
+}
+
+func TestFakeClusterJoin(t *testing.T) {
+	ctx := context.TODO()
+	cluster, nodes := newTestCluster(t, 2)
+	cluster.Fail("Join", "10.0.0.2", 1, errors.New("network unreachable"))
+
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := nodes[1].Join(shortCtx, JoinCmd{PeerAddr: "10.0.0.1", Token: "token"})
+	require.Error(t, err, "expected scripted failure")
+	err = nodes[1].Join(shortCtx, JoinCmd{PeerAddr: "10.0.0.1", Token: "token"})
+	require.Error(t, err, "expected join to wait for installation")
+
+	require.NoError(t, nodes[0].Install(ctx, InstallParam{Token: "token"}))
+	err = nodes[1].Join(ctx, JoinCmd{PeerAddr: "10.0.0.1", Token: "invalid"})
+	require.True(t, trace.IsAccessDenied(err), "expected invalid token to be rejected but got %v", err)
+	require.NoError(t, nodes[1].Join(ctx, JoinCmd{PeerAddr: "10.0.0.1", Token: "token"}))
+
+	ops := cluster.Operations()
+	require.Len(t, ops, 2)
+	require.NotEqual(t, ops[0].ID, ops[1].ID)
+	require.Equal(t, FakeOperation{ID: ops[1].ID, Type: "join", Node: "10.0.0.2"}, ops[1])
+}
+
+func TestAddsAndReleasesNodes(t *testing.T) {
+	c := newTestContext(t)
+	nodes, _, err := c.Provision(ProvisionerConfig{}.WithTag("scale").WithNodes(2).WithFake(nil))
+	require.NoError(t, err)
+
+	added, err := c.AddNodes(2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.3", "10.0.0.4"}, privateAddrs(added))
+	require.Equal(t, append(nodes, added...), c.nodes)
+
+	err = c.ReleaseNodes(nodes[1:])
+	require.True(t, trace.IsBadParameter(err), "expected only the added nodes to be released but got %v", err)
+	require.NoError(t, c.ReleaseNodes(added[1:]))
+	require.Equal(t, append(nodes, added[0]), c.nodes)
+
+	added, err = c.AddNodes(1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.4"}, privateAddrs(added))
+}
+
+func TestDialsNodesInUse(t *testing.T) {
+	c := newTestContext(t)
+	_, nodes := newTestCluster(t, 2)
+	c.nodes = nodes[:1]
+
+	_, err := c.dialNode(context.TODO(), "10.0.0.2")
+	require.True(t, trace.IsNotFound(err), "expected only nodes in use to be dialed but got %v", err)
+	_, err = c.dialNode(context.TODO(), "192.0.2.1")
+	require.True(t, trace.IsConnectionProblem(err), "expected node without SSH client to fail but got %v", err)
+}
+
+func TestAddsSpareNodes(t *testing.T) {
+	c := newTestContext(t)
+	_, nodes := newTestCluster(t, 3)
+	c.nodes, c.spares = nodes[:1], nodes[1:]
+
+	added, err := c.AddNodes(1)
+	require.NoError(t, err)
+	require.Equal(t, nodes[1:2], added)
+	require.Equal(t, nodes[:2], c.nodes)
+
+	_, err = c.AddNodes(2)
+	require.True(t, trace.IsNotImplemented(err), "expected to run out of spare nodes but got %v", err)
+	require.True(t, trace.IsNotImplemented(c.ReleaseNodes(added)))
+}
+
+// TestFailFastCancelsOtherTests runs TestFailFastSuite in a separate process
+// as the failure of its test fails the test binary
+func TestFailFastCancelsOtherTests(t *testing.T) {
+	if os.Getenv(failFastEnv) != "" {
+		t.Skip("running in the fail fast suite")
+	}
+	// both tests of the suite have to run at the same time
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFailFastSuite$", "-test.parallel=2")
+	cmd.Env = append(os.Environ(), failFastEnv+"=1")
+	out, err := cmd.Output()
+	require.Error(t, err, "expected the fail fast suite to fail")
+
+	var status map[string]string
+	require.NoError(t, json.Unmarshal(suiteStatus(out), &status), "%s", out)
+	require.Equal(t, map[string]string{
+		"failfast-failing": TestStatusFailed,
+		"failfast-slow":    TestStatusCancelled,
+	}, status)
+}
+
+func TestFailFastSuite(t *testing.T) {
+	if os.Getenv(failFastEnv) == "" {
+		t.Skip("run by TestFailFastCancelsOtherTests")
+	}
+	// the slow test would run for an hour unless canceled
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	suite := NewSuite(ctx, t, "", logrus.Fields{}, true)
+	installer := func(c *TestContext, cfg ProvisionerConfig) {
+		nodes, destroyFn, err := c.Provision(cfg.WithNodes(1))
+		c.OK("provision nodes", err)
+		defer destroyFn()
+		c.OK("installer", c.SetInstaller(nodes, "installer.tar", "install"))
+		c.OK("install", c.OfflineInstall(nodes, InstallParam{}))
+	}
+	suite.Schedule(installer, ProvisionerConfig{}.WithTag("failfast").WithTag("failing").WithFake(
+		func(cluster *FakeCluster) {
+			cluster.Fail("Install", "", 0, errors.New("installation failed"))
+		}), nil)
+	suite.Schedule(installer, ProvisionerConfig{}.WithTag("failfast").WithTag("slow").WithFake(
+		func(cluster *FakeCluster) {
+			cluster.SetLatency(time.Hour)
+		}), nil)
+
+	status := map[string]string{}
+	for _, test := range suite.Run() {
+		status[test.Name] = test.Status
+	}
+	data, err := json.Marshal(status)
+	require.NoError(t, err)
+	fmt.Printf("\n%s%s\n", statusPrefix, data)
+}
+
+const (
+	// failFastEnv is the environment variable that enables TestFailFastSuite
+	failFastEnv = "ROBOTEST_FAIL_FAST_SUITE"
+	// statusPrefix prefixes the output line with the statuses of TestFailFastSuite
+	statusPrefix = "suite status: "
+)
+
+func newTestCluster(t *testing.T, count int) (*FakeCluster, []Gravity) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	cluster := newFakeCluster(count, logrus.NewEntry(log))
+	nodes := cluster.Nodes()
+	for _, node := range nodes {
+		require.NoError(t, node.SetInstaller(context.TODO(), "installer.tar", "install"))
+	}
+	return cluster, nodes
+}
+
+func newTestContext(t *testing.T) *TestContext {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return &TestContext{t: t, parent: context.TODO(), log: logrus.NewEntry(log)}
+}
+
+func privateAddrs(nodes []Gravity) []string {
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.Node().PrivateAddr())
+	}
+	return addrs
+}
+
+// suiteStatus returns the test statuses printed by TestFailFastSuite
+func suiteStatus(out []byte) []byte {
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte(statusPrefix)) {
+			return bytes.TrimPrefix(line, []byte(statusPrefix))
+		}
+	}
+	return nil
+}
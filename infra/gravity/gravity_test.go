@@ -3,9 +3,21 @@ package gravity
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 var testStatusStr = []byte(`
@@ -37,3 +49,258 @@ func TestGravityOutput(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedStatus, &status, "parseStatus")
 }
+
+func TestProvisionerUsers(t *testing.T) {
+	var testCases = []struct {
+		config ProvisionerConfig
+		user   string
+	}{
+		{config: ProvisionerConfig{CloudProvider: "aws", os: "centos"}, user: "centos"},
+		{config: ProvisionerConfig{CloudProvider: "azure", os: "ubuntu"}, user: "robotest"},
+		{config: ProvisionerConfig{Provisioner: "vagrant", os: "ubuntu"}, user: ""},
+		{config: ProvisionerConfig{Provisioner: "inventory", os: "centos"}, user: ""},
+		{config: ProvisionerConfig{Provisioner: "inventory", os: "centos", SSHUser: "admin"}, user: "admin"},
+	}
+
+	for _, testCase := range testCases {
+		param := makeDynamicParams(t, testCase.config)
+		assert.Equal(t, testCase.user, param.user, "%+v", testCase.config)
+	}
+}
+
+func TestParsesNodeGroups(t *testing.T) {
+	var testCases = []struct {
+		spec   string
+		groups []infra.NodeGroup
+		tag    string
+		check  func(error) bool
+	}{
+		{spec: "centos", groups: []infra.NodeGroup{{OS: "centos"}}, tag: "centos"},
+		{
+			spec: "centos:1+ubuntu",
+			groups: []infra.NodeGroup{
+				{Count: 1, OS: "centos"},
+				{OS: "ubuntu"},
+			},
+			tag: "centos1-ubuntu",
+		},
+		{
+			spec: "centos/c4.2xlarge:1+ubuntu/c4.large",
+			groups: []infra.NodeGroup{
+				{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+				{OS: "ubuntu", InstanceType: "c4.large"},
+			},
+			tag: "centos1-c42xlarge-ubuntu-c4large",
+		},
+		{spec: "centos+ubuntu", check: trace.IsBadParameter},
+		{spec: "centos:0+ubuntu", check: trace.IsBadParameter},
+		{spec: "windows", check: trace.IsBadParameter},
+		{spec: "centos/", check: trace.IsBadParameter},
+	}
+
+	for _, testCase := range testCases {
+		groups, err := ParseNodeGroups(testCase.spec)
+		if testCase.check != nil {
+			assert.True(t, testCase.check(err), "%v: unexpected error %v", testCase.spec, err)
+			continue
+		}
+		assert.NoError(t, err, testCase.spec)
+		assert.Equal(t, testCase.groups, groups, testCase.spec)
+		assert.Equal(t, testCase.tag, nodeGroupsTag(groups), testCase.spec)
+	}
+}
+
+func TestMakesNodeGroups(t *testing.T) {
+	groups := []infra.NodeGroup{
+		{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+		{OS: "ubuntu"},
+	}
+	config := ProvisionerConfig{CloudProvider: "aws"}.WithNodeGroups(groups...).WithNodes(3)
+	assert.Equal(t, "-centos1-c42xlarge-ubuntu-3n", config.Tag())
+
+	param := makeDynamicParams(t, config)
+	assert.Equal(t, "centos", param.user)
+	assert.Equal(t, "", param.homeDir, "home directory differs across nodes")
+	assert.Equal(t, []infra.NodeGroup{
+		{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+		{Count: 2, OS: "ubuntu", SSHUser: "ubuntu"},
+	}, param.nodeGroups)
+
+	param = makeDynamicParams(t, ProvisionerConfig{CloudProvider: "azure"}.WithNodeGroups(groups...).WithNodes(2))
+	assert.Equal(t, "/home/centos", param.homeDir)
+	assert.Equal(t, []infra.NodeGroup{
+		{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+		{Count: 1, OS: "ubuntu"},
+	}, param.nodeGroups)
+}
+
+func TestTreeDepth(t *testing.T) {
+	var testCases = []struct {
+		nodes, fanout, depth int
+	}{
+		{nodes: 1, fanout: 2, depth: 0},
+		{nodes: 2, fanout: 2, depth: 1},
+		{nodes: 3, fanout: 2, depth: 1},
+		{nodes: 4, fanout: 2, depth: 2},
+		{nodes: 7, fanout: 2, depth: 2},
+		{nodes: 8, fanout: 2, depth: 3},
+		{nodes: 6, fanout: 1, depth: 5},
+		{nodes: 6, fanout: 5, depth: 1},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.depth, treeDepth(testCase.nodes, testCase.fanout),
+			"%v nodes with fanout %v", testCase.nodes, testCase.fanout)
+	}
+}
+
+func TestFanoutIsAtLeastOne(t *testing.T) {
+	defaultFanout := DefaultInstallerDistribution.Fanout
+	defer func() { DefaultInstallerDistribution.Fanout = defaultFanout }()
+
+	c := &TestContext{}
+	c.distribution.Fanout = 3
+	require.Equal(t, 3, c.fanout())
+	c.distribution.Fanout = 0
+	require.Equal(t, defaultFanout, c.fanout())
+	DefaultInstallerDistribution.Fanout = 0
+	require.Equal(t, 1, c.fanout())
+}
+
+func TestSelectsProvisionedNodes(t *testing.T) {
+	cfg := ProvisionerConfig{StateDir: t.TempDir(), NodeSelector: "os=centos"}.WithNodes(2)
+	params := cloudDynamicParams{
+		ProvisionerConfig: cfg,
+		provisioner:       testProvisionerName,
+		nodeGroups:        []infra.NodeGroup{{Count: 2}},
+	}
+
+	_, nodes, err := runProvisioner(context.TODO(), cfg, params)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	require.Equal(t, "10.0.0.2", nodes[0].PrivateAddr())
+	require.Equal(t, "10.0.0.3", nodes[1].PrivateAddr())
+
+	params.nodeCount = 3
+	_, _, err = runProvisioner(context.TODO(), cfg.WithNodes(3), params)
+	require.True(t, trace.IsNotFound(err), "expected too few nodes to match but got %v", err)
+}
+
+// testProvisionerName names the provisioner of labeled nodes, see testProvisioner
+const testProvisionerName = "labeled"
+
+func TestCopiesFromPeersAsSSHUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-copy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// ssh runs the remote command locally and records the destination
+	ssh := "#!/bin/sh\nfor arg; do dest=$prev; prev=$arg; done\necho \"$dest\" > " + filepath.Join(dir, "dest") + "\nexec sh -c \"$prev\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte(ssh), 0755))
+	srcPath := filepath.Join(dir, `src's "$HOME"`, "installer.tar")
+	dstPath := filepath.Join(dir, "dst `dir`", "installer.tar.part")
+	require.NoError(t, os.MkdirAll(filepath.Dir(srcPath), 0755))
+	require.NoError(t, ioutil.WriteFile(srcPath, []byte("installer"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Dir(dstPath), 0755))
+	require.NoError(t, ioutil.WriteFile(dstPath, []byte("inst"), 0644))
+
+	src := &gravity{
+		node:  userVM{fakeVM{privateAddr: "10.0.0.1"}, "admin"},
+		param: cloudDynamicParams{user: "centos"},
+	}
+	cmd := exec.Command("sh", "-c", (&peerKey{name: "robotest"}).copyCommand(src, srcPath, dstPath))
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "%s", out)
+
+	copied, err := ioutil.ReadFile(dstPath)
+	require.NoError(t, err)
+	require.Equal(t, "installer", string(copied))
+	dest, err := ioutil.ReadFile(filepath.Join(dir, "dest"))
+	require.NoError(t, err)
+	require.Equal(t, "admin@10.0.0.1\n", string(dest))
+}
+
+func TestClientRedialsWithoutLockingNode(t *testing.T) {
+	vm := dialingVM{fakeVM{addr: "10.0.0.10", privateAddr: "10.0.0.10"}, make(chan struct{}, 1)}
+	defer infra.Disconnect(vm)
+	lost := &ssh.Client{}
+	g := &gravity{node: vm, ssh: lost, log: logrus.NewEntry(logrus.StandardLogger())}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	clientCh := make(chan *ssh.Client, 1)
+	go func() { clientCh <- g.Client(ctx) }()
+	<-vm.dialing
+
+	offlineCh := make(chan bool, 1)
+	go func() { offlineCh <- g.Offline() }()
+	select {
+	case offline := <-offlineCh:
+		require.False(t, offline)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node locked while redialing")
+	}
+	select {
+	case client := <-clientCh:
+		require.Equal(t, lost, client)
+	case <-time.After(5 * time.Second):
+		t.Fatal("redial outlived the context")
+	}
+}
+
+func init() {
+	infra.RegisterProvisioner(testProvisionerName, infra.ProvisionerFactory{
+		New: func(string, infra.ProvisionerConfig) (infra.Provisioner, error) {
+			return &testProvisioner{}, nil
+		},
+		NewFromState: func(infra.ProvisionerConfig, infra.ProvisionerState) (infra.Provisioner, error) {
+			return nil, trace.NotImplemented("not implemented")
+		},
+	})
+}
+
+// testProvisioner creates an ubuntu node followed by centos nodes
+type testProvisioner struct {
+	infra.Provisioner
+	pool infra.NodePool
+}
+
+func (r *testProvisioner) Create(ctx context.Context, withInstaller bool) (infra.Node, error) {
+	r.pool = infra.NewNodePool([]infra.Node{
+		labeledVM{fakeVM{privateAddr: "10.0.0.1", addr: "10.0.0.1"}, "ubuntu"},
+		labeledVM{fakeVM{privateAddr: "10.0.0.2", addr: "10.0.0.2"}, "centos"},
+		labeledVM{fakeVM{privateAddr: "10.0.0.3", addr: "10.0.0.3"}, "centos"},
+	}, nil)
+	return nil, nil
+}
+
+func (r *testProvisioner) Destroy(ctx context.Context) error { return nil }
+
+func (r *testProvisioner) NodePool() infra.NodePool { return r.pool }
+
+type labeledVM struct {
+	fakeVM
+	os string
+}
+
+func (r labeledVM) Meta() infra.NodeMeta { return infra.NodeMeta{OS: r.os}.WithLabels("0") }
+
+type userVM struct {
+	fakeVM
+	user string
+}
+
+func (r userVM) Meta() infra.NodeMeta { return infra.NodeMeta{SSHUser: r.user} }
+
+// dialingVM signals each attempt to connect to it
+type dialingVM struct {
+	fakeVM
+	dialing chan struct{}
+}
+
+func (r dialingVM) Client() (*ssh.Client, error) {
+	select {
+	case r.dialing <- struct{}{}:
+	default:
+	}
+	return r.fakeVM.Client()
+}
@@ -0,0 +1,99 @@
+package gravity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/gravitational/robotest/lib/cache"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// InstallerCacheMirror serves cached installers to nodes over SSH reverse tunnels
+	InstallerCacheMirror = "mirror"
+	// InstallerCachePush uploads cached installers to nodes over SFTP
+	InstallerCachePush = "push"
+	// InstallerCacheOff downloads installers on each node
+	InstallerCacheOff = "off"
+)
+
+// InstallerCacheConfig configures the local cache of installers shared by all tests
+type InstallerCacheConfig struct {
+	// Dir is the directory of the cache, defaults to a directory in the system temporary directory
+	Dir string
+	// Mode defines how cached installers get to nodes: off (default), mirror or push
+	Mode string
+}
+
+type installerCache struct {
+	mode   string
+	cache  *cache.Cache
+	mirror *cache.Mirror
+}
+
+// installers is the installer cache, nil if disabled
+var installers *installerCache
+
+// SetInstallerCache enables the local cache of installers, which are then fetched once
+// per test run instead of once per node
+func SetInstallerCache(config InstallerCacheConfig) error {
+	switch config.Mode {
+	case InstallerCacheMirror, InstallerCachePush:
+	case "", InstallerCacheOff:
+		installers = nil
+		return nil
+	default:
+		return trace.BadParameter("unknown installer cache mode %q, expected %v, %v or %v",
+			config.Mode, InstallerCacheMirror, InstallerCachePush, InstallerCacheOff)
+	}
+	if config.Dir == "" {
+		config.Dir = filepath.Join(os.TempDir(), "robotest-installers")
+	}
+
+	log := logrus.WithField("installer_cache", config.Mode)
+	c, err := cache.New(config.Dir, log)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	installers = &installerCache{
+		mode:   config.Mode,
+		cache:  c,
+		mirror: cache.NewMirror(c, log),
+	}
+	return nil
+}
+
+// installerSource returns the URL to transfer the installer to the node from
+// along with the transfer options. Installers are copied from other nodes resolved with dialPeer.
+// With the installer cache enabled, the installer is fetched into the cache first
+// and the node gets it from the test runner.
+// Falls back to the original URL if the installer cannot be cached
+func installerSource(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, installerUrl string, env map[string]string, dialPeer sshutils.NodeDialFunc) (string, []sshutils.TransferOptionSetter) {
+	opts := []sshutils.TransferOptionSetter{sshutils.NodeDialer(dialPeer)}
+	if installers == nil || client == nil {
+		return installerUrl, opts
+	}
+
+	entry, err := installers.cache.Get(ctx, installerUrl, env)
+	if err != nil {
+		if !trace.IsNotImplemented(err) {
+			log.WithError(err).Warn("Failed to cache installer, downloading it on the node.")
+		}
+		return installerUrl, opts
+	}
+	opts = append(opts, sshutils.ExpectChecksum(entry.Checksum), sshutils.FileName(entry.Name))
+
+	if installers.mode == InstallerCacheMirror {
+		mirrorUrl, err := installers.mirror.URL(client, entry)
+		if err == nil {
+			return mirrorUrl, opts
+		}
+		log.WithError(err).Warn("Failed to serve installer from the mirror, uploading it.")
+	}
+	return entry.Path, opts
+}
@@ -0,0 +1,271 @@
+package gravity
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/defaults"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+	"github.com/gravitational/robotest/lib/wait"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DistributeDirect transfers the installer to each node from the installer URL
+	DistributeDirect = "direct"
+	// DistributeTree transfers the installer to the first node only.
+	// Nodes that have the installer copy it to their peers over the private network,
+	// so the installer leaves the test runner or the bucket once
+	DistributeTree = "tree"
+)
+
+// InstallerDistribution defines how TestContext.SetInstaller distributes installers to nodes
+type InstallerDistribution struct {
+	// Strategy is either DistributeDirect or DistributeTree
+	Strategy string
+	// Fanout is how many peers each node copies the installer to with DistributeTree
+	Fanout int
+}
+
+// SetInstallerDistribution selects how SetInstaller distributes installers to nodes
+func (c *TestContext) SetInstallerDistribution(d InstallerDistribution) {
+	c.distribution = d
+}
+
+// setInstallerTree deploys the installer to nodes with DistributeTree.
+// The timeout grows with the depth of the tree
+func (c *TestContext) setInstallerTree(nodes []Gravity, installerUrl, subdir string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	peers := make([]*gravity, 0, len(nodes))
+	for _, node := range nodes {
+		g, ok := node.(*gravity)
+		if !ok {
+			return trace.BadParameter("installer distribution %v requires remote nodes, got %T", DistributeTree, node)
+		}
+		peers = append(peers, g)
+	}
+
+	ctx, cancel := context.WithTimeout(c.parent, withDuration(c.timeouts.Install, treeDepth(len(nodes), c.fanout())+1))
+	defer cancel()
+	return trace.Wrap(c.distributeInstaller(ctx, peers, installerUrl, subdir))
+}
+
+// fanout returns the number of nodes each node copies the installer to.
+// The default applies unless configured and the tree has at least one child per node
+func (c *TestContext) fanout() int {
+	fanout := c.distribution.Fanout
+	if fanout < 1 {
+		fanout = DefaultInstallerDistribution.Fanout
+	}
+	if fanout < 1 {
+		fanout = 1
+	}
+	return fanout
+}
+
+// treeDepth returns the number of hops to the farthest of n nodes in the tree with fanout
+func treeDepth(n, fanout int) (depth int) {
+	for last := n - 1; last > 0; last = (last - 1) / fanout {
+		depth++
+	}
+	return depth
+}
+
+// distributeInstaller transfers the installer to the first node and copies it from there
+// to the rest of the nodes in a tree: node i copies the installer from node (i-1)/fanout.
+// Each copy is verified against the checksum of the installer on the first node.
+// Nodes whose source failed copy the installer from the first node instead
+func (c *TestContext) distributeInstaller(ctx context.Context, nodes []*gravity, installerUrl, subdir string) error {
+	fanout := c.fanout()
+	root := nodes[0]
+	err := root.SetInstaller(ctx, installerUrl, subdir)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(nodes) == 1 {
+		return nil
+	}
+	checksum, err := sshutils.RemoteChecksum(ctx, root.Client(ctx), root.Logger(), root.installer)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	key, err := newPeerKey(c.uid, nodes)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer func() {
+		// remove the key even if the test context has expired
+		ctx, cancel := context.WithTimeout(context.Background(), deadlineSSH)
+		defer cancel()
+		if err := key.remove(ctx, nodes); err != nil {
+			c.Logger().WithError(err).Warn("Failed to remove installer distribution key from nodes.")
+		}
+	}()
+	if err := key.install(ctx, nodes); err != nil {
+		return trace.Wrap(err)
+	}
+
+	results := make([]error, len(nodes))
+	done := make([]chan struct{}, len(nodes))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	close(done[0])
+
+	errs := make(chan error, len(nodes)-1)
+	for i := 1; i < len(nodes); i++ {
+		go func(i int) {
+			defer close(done[i])
+			parent := (i - 1) / fanout
+			select {
+			case <-done[parent]:
+			case <-ctx.Done():
+				results[i] = trace.Wrap(ctx.Err())
+				errs <- results[i]
+				return
+			}
+			src := nodes[parent]
+			if results[parent] != nil {
+				src = root
+			}
+			results[i] = nodes[i].copyInstaller(ctx, src, key, checksum, subdir)
+			errs <- results[i]
+		}(i)
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errs))
+}
+
+// copyInstaller copies the current installer of the peer src to subdir on this node
+// over the private network and verifies its checksum.
+// Interrupted copies resume where they stopped
+func (g *gravity) copyInstaller(ctx context.Context, src *gravity, key *peerKey, checksum, subdir string) error {
+	installDir := filepath.Join(g.param.homeDir, subdir)
+	tgz := filepath.Join(installDir, filepath.Base(src.installer))
+	log := g.Logger().WithFields(logrus.Fields{
+		"src_node":    src.Node().PrivateAddr(),
+		"installer":   tgz,
+		"install_dir": installDir,
+	})
+
+	if sum, err := sshutils.RemoteChecksum(ctx, g.Client(ctx), log, tgz); err == nil && sum == checksum {
+		log.Info("installer already transferred")
+		return trace.Wrap(g.extractInstaller(ctx, log, tgz, installDir))
+	}
+
+	log.Debug("copy installer from peer")
+	partPath := tgz + ".part"
+	retry := wait.Retryer{
+		Delay:       defaults.RetryDelay,
+		Attempts:    defaults.TransferAttempts,
+		FieldLogger: log,
+	}
+	err := retry.Do(ctx, func() error {
+		err := sshutils.Run(ctx, g.Client(ctx), log, key.copyCommand(src, src.installer, partPath), nil)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		sum, err := sshutils.RemoteChecksum(ctx, g.Client(ctx), log, partPath)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if sum != checksum {
+			// start over
+			err = sshutils.Run(ctx, g.Client(ctx), log, fmt.Sprintf("rm -f %s", sshutils.ShellQuote(partPath)), nil)
+			return trace.NewAggregate(trace.CompareFailed("SHA-256 checksum mismatch of %v copied from %v: expected %v, got %v",
+				tgz, src.Node().PrivateAddr(), checksum, sum), err)
+		}
+		return trace.Wrap(sshutils.Run(ctx, g.Client(ctx), log, fmt.Sprintf("mv -f %s %s", sshutils.ShellQuote(partPath), sshutils.ShellQuote(tgz)), nil))
+	})
+	if err != nil {
+		return trace.Wrap(err, "failed to copy installer from %v", src.Node().PrivateAddr())
+	}
+	return trace.Wrap(g.extractInstaller(ctx, log, tgz, installDir))
+}
+
+// peerKey is a temporary SSH key that lets nodes copy the installer from each other.
+// Nodes accept the key from the private addresses of the test nodes only
+type peerKey struct {
+	// name names the key file on the nodes and marks the authorized key
+	name       string
+	private    []byte
+	authorized string
+}
+
+func newPeerKey(uid string, nodes []*gravity) (*peerKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	public, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.Node().PrivateAddr())
+	}
+	name := fmt.Sprintf("robotest-%v", uid)
+	return &peerKey{
+		name: name,
+		private: pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}),
+		authorized: fmt.Sprintf(`from="%v",no-port-forwarding,no-agent-forwarding,no-X11-forwarding,no-pty %v %v`,
+			strings.Join(addrs, ","), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public))), name),
+	}, nil
+}
+
+// install authorizes the key for the SSH user of the nodes and stores the private key next to it
+func (r *peerKey) install(ctx context.Context, nodes []*gravity) error {
+	cmd := fmt.Sprintf("mkdir -p -m 700 ~/.ssh && (umask 077 && cat > ~/.ssh/%v) && echo '%v' >> ~/.ssh/authorized_keys",
+		r.name, r.authorized)
+	return r.run(ctx, nodes, func(node *gravity) error {
+		_, err := sshutils.Exec(ctx, node.Client(ctx), node.Logger(), cmd, sshutils.Stdin(bytes.NewReader(r.private)))
+		return trace.Wrap(err)
+	})
+}
+
+// remove removes the key from the nodes
+func (r *peerKey) remove(ctx context.Context, nodes []*gravity) error {
+	cmd := fmt.Sprintf("rm -f ~/.ssh/%v && sed -i '/ %v$/d' ~/.ssh/authorized_keys", r.name, r.name)
+	return r.run(ctx, nodes, func(node *gravity) error {
+		return trace.Wrap(sshutils.Run(ctx, node.Client(ctx), node.Logger(), cmd, nil))
+	})
+}
+
+func (r *peerKey) run(ctx context.Context, nodes []*gravity, fn func(node *gravity) error) error {
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node *gravity) {
+			errs <- fn(node)
+		}(node)
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errs))
+}
+
+// copyCommand returns the command that appends srcPath on the peer src to the partially copied dstPath,
+// connecting to the peer as its SSH user with the key.
+// Peers are only reachable from the test nodes, so their host keys are not verified
+func (r *peerKey) copyCommand(src *gravity, srcPath, dstPath string) string {
+	dst := sshutils.ShellQuote(dstPath)
+	// srcPath is quoted twice: once for this shell and once for the shell on the peer
+	return fmt.Sprintf(`mkdir -p %v && ssh -i ~/.ssh/%v -o BatchMode=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=30 %v "tail -c +$(( $(stat -c %%s %v 2>/dev/null || echo 0) + 1 )) "%v >> %v`,
+		sshutils.ShellQuote(filepath.Dir(dstPath)), r.name,
+		sshutils.ShellQuote(fmt.Sprintf("%v@%v", src.Node().Meta().SSHUser, src.Node().PrivateAddr())),
+		dst, sshutils.ShellQuote(sshutils.ShellQuote(srcPath)), dst)
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -43,6 +44,9 @@ type Gravity interface {
 	Uninstall(ctx context.Context) error
 	// PowerOff will power off the node
 	PowerOff(ctx context.Context, graceful Graceful) error
+	// PowerOn will power on the node previously powered off and reconnect once it is available.
+	// Requires the provisioner to support infra.NodeLifecycle
+	PowerOn(ctx context.Context) error
 	// Reboot will reboot this node and wait until it will become available again
 	Reboot(ctx context.Context, graceful Graceful) error
 	// CollectLogs will pull essential logs from node and store it in state dir under node-logs/prefix
@@ -58,7 +62,7 @@ type Gravity interface {
 	// Offline returns true if node was previously powered off
 	Offline() bool
 	// Client returns SSH client to VM instance
-	Client() *ssh.Client
+	Client(ctx context.Context) *ssh.Client
 	// Text representation
 	String() string
 	// Will log using extended info such as current tag, node info, etc
@@ -119,12 +123,19 @@ type GravityStatus struct {
 }
 
 type gravity struct {
-	node       infra.Node
+	node infra.Node
+	// mu guards ssh
+	mu sync.Mutex
+	// ssh is the last SSH client of the node, nil while the node is offline
 	ssh        *ssh.Client
 	installDir string
-	param      cloudDynamicParams
-	ts         time.Time
-	log        logrus.FieldLogger
+	// installer is the path of the installer tarball last transferred to the node
+	installer string
+	param     cloudDynamicParams
+	ts        time.Time
+	log       logrus.FieldLogger
+	// dialPeer connects to the other nodes of the test to copy files from, see TestContext.dialNode
+	dialPeer sshutils.NodeDialFunc
 }
 
 func (g *gravity) MarshalJSON() ([]byte, error) {
@@ -134,18 +145,22 @@ func (g *gravity) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// waits for SSH to be up on node and returns client
+// waits for SSH to be up on node and returns the cached client
 func sshClient(baseContext context.Context, node infra.Node, log logrus.FieldLogger) (*ssh.Client, error) {
 	ctx, cancel := context.WithTimeout(baseContext, deadlineSSH)
 	defer cancel()
 
 	for {
-		client, err := node.Client()
+		client, err := infra.Client(ctx, node)
 
 		if err == nil {
 			log.Debug("connected via SSH")
 			return client, nil
 		}
+		if trace.IsCompareFailed(err) {
+			// host key mismatch will not go away with retries
+			return nil, trace.Wrap(err)
+		}
 
 		log.WithFields(logrus.Fields{"error": err, "retry_in": retrySSH}).Debug("waiting for SSH")
 		select {
@@ -163,6 +178,9 @@ func (g *gravity) Logger() logrus.FieldLogger {
 
 // String returns public and private addresses of the node
 func (g *gravity) String() string {
+	if hostname := g.node.Meta().Hostname; hostname != "" {
+		return fmt.Sprintf("%s(%s/%s)", hostname, g.node.PrivateAddr(), g.node.Addr())
+	}
 	return fmt.Sprintf("%s/%s", g.node.PrivateAddr(), g.node.Addr())
 }
 
@@ -170,9 +188,31 @@ func (g *gravity) Node() infra.Node {
 	return g.node
 }
 
-// Client returns SSH client to the node
-func (g *gravity) Client() *ssh.Client {
-	return g.ssh
+// Client returns SSH client to the node.
+// The client is shared by all users of the node and reconnects transparently
+// once the connection has been lost, for as long as ctx allows.
+// Returns nil if the node is offline
+func (g *gravity) Client(ctx context.Context) *ssh.Client {
+	g.mu.Lock()
+	current := g.ssh
+	g.mu.Unlock()
+	if current == nil {
+		return nil
+	}
+	client, err := infra.Client(ctx, g.node)
+	if err != nil {
+		// commands fail on the dead client with the connection error
+		g.Logger().WithError(err).Warn("failed to reconnect via SSH")
+		return current
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ssh == nil {
+		// the node has gone offline in the meantime
+		return nil
+	}
+	g.ssh = client
+	return client
 }
 
 // Install runs gravity install with params
@@ -199,7 +239,7 @@ func (g *gravity) Install(ctx context.Context, param InstallParam) error {
 		return trace.Wrap(err, buf.String())
 	}
 
-	err = sshutils.Run(ctx, g.Client(), g.Logger(), buf.String(), map[string]string{
+	err = sshutils.Run(ctx, g.Client(ctx), g.Logger(), buf.String(), map[string]string{
 		constants.EnvDockerDevice: g.param.dockerDevice,
 	})
 	return trace.Wrap(err, param)
@@ -220,7 +260,7 @@ var installCmdTemplate = template.Must(
 func (g *gravity) Status(ctx context.Context) (*GravityStatus, error) {
 	cmd := fmt.Sprintf("cd %s && sudo ./gravity status --system-log-file=./telekube-system.log", g.installDir)
 	status := GravityStatus{}
-	exit, err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, parseStatus(&status))
+	exit, err := sshutils.RunAndParse(ctx, g.Client(ctx), g.Logger(), cmd, nil, parseStatus(&status))
 
 	if err != nil {
 		return nil, trace.Wrap(err, cmd)
@@ -257,7 +297,7 @@ func (g *gravity) Join(ctx context.Context, param JoinCmd) error {
 		return trace.Wrap(err, buf.String())
 	}
 
-	err = sshutils.Run(ctx, g.Client(), g.Logger(), buf.String(), map[string]string{
+	err = sshutils.Run(ctx, g.Client(ctx), g.Logger(), buf.String(), map[string]string{
 		constants.EnvDockerDevice: g.param.dockerDevice,
 	})
 	return trace.Wrap(err, param)
@@ -297,11 +337,12 @@ func (g *gravity) Remove(ctx context.Context, node string, graceful Graceful) er
 // Uninstall removes gravity installation. It requires Leave beforehand
 func (g *gravity) Uninstall(ctx context.Context) error {
 	cmd := fmt.Sprintf(`cd %s && sudo ./gravity system uninstall --confirm --system-log-file=./telekube-system.log`, g.installDir)
-	err := sshutils.Run(ctx, g.Client(), g.Logger(), cmd, nil)
+	err := sshutils.Run(ctx, g.Client(ctx), g.Logger(), cmd, nil)
 	return trace.Wrap(err, cmd)
 }
 
-// PowerOff forcibly halts a machine
+// PowerOff halts a machine.
+// If the provisioner supports infra.NodeLifecycle, it waits for the machine to stop
 func (g *gravity) PowerOff(ctx context.Context, graceful Graceful) error {
 	var cmd string
 	if graceful {
@@ -310,13 +351,33 @@ func (g *gravity) PowerOff(ctx context.Context, graceful Graceful) error {
 		cmd = "sudo poweroff -f"
 	}
 
-	sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, nil)
-	g.ssh = nil
-	// TODO: reliably destinguish between force close of SSH control channel and command being unable to run
+	err := g.runShutdown(ctx, cmd)
+	g.disconnect()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if node, ok := g.node.(infra.NodeLifecycle); ok {
+		return trace.Wrap(node.Stop(ctx))
+	}
 	return nil
 }
 
+// PowerOn starts a machine with the provisioner and waits for it to become available again
+func (g *gravity) PowerOn(ctx context.Context) error {
+	node, ok := g.node.(infra.NodeLifecycle)
+	if !ok {
+		return trace.NotImplemented("provisioner does not support power management of %v", g.node)
+	}
+	err := node.Start(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(g.reconnect(ctx))
+}
+
 func (g *gravity) Offline() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	return g.ssh == nil
 }
 
@@ -328,26 +389,62 @@ func (g *gravity) Reboot(ctx context.Context, graceful Graceful) error {
 	} else {
 		cmd = "sudo reboot -f"
 	}
-	sshutils.RunAndParse(ctx, g.Client(), g.Logger(), cmd, nil, nil)
-	// TODO: reliably destinguish between force close of SSH control channel and command being unable to run
+	err := g.runShutdown(ctx, cmd)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(g.reconnect(ctx))
+}
+
+// runShutdown runs the command that shuts down or reboots the node.
+// The node drops the connection while going down, so only failures to start
+// the command and its non-zero exit status are reported
+func (g *gravity) runShutdown(ctx context.Context, cmd string) error {
+	_, err := sshutils.Exec(ctx, g.Client(ctx), g.Logger(), cmd, sshutils.Timeout(shutdownTimeout))
+	if sshutils.IsStartError(err) || (sshutils.IsExitError(err) && !isSignaled(err)) {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// isSignaled returns true if err is an exit error of a command terminated by a signal,
+// i.e. killed with the rest of the processes as the node goes down
+func isSignaled(err error) bool {
+	exitErr, ok := trace.Unwrap(err).(*sshutils.ExitError)
+	return ok && exitErr.Signal != ""
+}
 
+// reconnect replaces the SSH client with a new one
+// once the node becomes available
+func (g *gravity) reconnect(ctx context.Context) error {
+	g.disconnect()
 	client, err := sshClient(ctx, g.Node(), g.Logger())
 	if err != nil {
 		return trace.Wrap(err, "SSH reconnect")
 	}
 
+	g.mu.Lock()
 	g.ssh = client
+	g.mu.Unlock()
 	return nil
 }
 
+// disconnect closes the SSH client of a node that is no longer available
+func (g *gravity) disconnect() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	infra.Disconnect(g.node)
+	g.ssh = nil
+}
+
 // PullLogs fetches essential logs from the host and stores them in state dir
 func (g *gravity) CollectLogs(ctx context.Context, prefix string) (string, error) {
-	if g.ssh == nil {
+	if g.Offline() {
 		return "", trace.AccessDenied("node %v is poweroff", g)
 	}
 
 	localPath := filepath.Join(g.param.StateDir, "node-logs", prefix, fmt.Sprintf("%s-logs.tgz", g.Node().PrivateAddr()))
-	return localPath, trace.Wrap(sshutils.PipeCommand(ctx, g.Client(), g.Logger(),
+	return localPath, trace.Wrap(sshutils.PipeCommand(ctx, g.Client(ctx), g.Logger(),
 		fmt.Sprintf("cd %s && sudo ./gravity system report", g.installDir), localPath))
 }
 
@@ -358,24 +455,32 @@ func (g *gravity) SetInstaller(ctx context.Context, installerUrl string, subdir
 
 	log.Debug("set installer")
 
-	tgz, err := sshutils.TransferFile(ctx, g.Client(), log, installerUrl, installDir, g.param.env)
+	srcUrl, opts := installerSource(ctx, g.Client(ctx), log, installerUrl, g.param.env, g.dialPeer)
+	tgz, err := sshutils.TransferFile(ctx, g.Client(ctx), log, srcUrl, installDir, g.param.env, opts...)
 	if err != nil {
 		log.WithError(err).Error("Failed to transfer installer")
 		return trace.Wrap(err)
 	}
 
-	err = sshutils.Run(ctx, g.Client(), log, fmt.Sprintf("tar -xvf %s -C %s", tgz, installDir), nil)
+	return trace.Wrap(g.extractInstaller(ctx, log, tgz, installDir))
+}
+
+// extractInstaller unpacks the installer tarball tgz into installDir
+// and makes it the current installer
+func (g *gravity) extractInstaller(ctx context.Context, log logrus.FieldLogger, tgz, installDir string) error {
+	err := sshutils.Run(ctx, g.Client(ctx), log, fmt.Sprintf("tar -xvf %s -C %s", tgz, installDir), nil)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
 	g.installDir = installDir
+	g.installer = tgz
 	return nil
 }
 
 // Upload uploads packages in current installer dir to cluster
 func (g *gravity) Upload(ctx context.Context) error {
-	err := sshutils.Run(ctx, g.Client(), g.Logger(), fmt.Sprintf(`cd %s && sudo ./upload`, g.installDir), nil)
+	err := sshutils.Run(ctx, g.Client(ctx), g.Logger(), fmt.Sprintf(`cd %s && sudo ./upload`, g.installDir), nil)
 	return trace.Wrap(err)
 }
 
@@ -391,7 +496,7 @@ var reGravityExtended = regexp.MustCompile(`launched operation \"([a-z0-9\-]+)\"
 // runOp launches specific command and waits for operation to complete, ignoring transient errors
 func (g *gravity) runOp(ctx context.Context, command string) error {
 	var code string
-	_, err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(),
+	_, err := sshutils.RunAndParse(ctx, g.Client(ctx), g.Logger(),
 		fmt.Sprintf(`cd %s && sudo ./gravity %s --insecure --quiet --system-log-file=./telekube-system.log`,
 			g.installDir, command),
 		nil, sshutils.ParseAsString(&code))
@@ -411,7 +516,7 @@ func (g *gravity) runOp(ctx context.Context, command string) error {
 	err = retry.Do(ctx, func() error {
 		var response string
 		cmd := fmt.Sprintf(`cd %s && ./gravity status --operation-id=%s -q`, g.installDir, code)
-		_, err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(),
+		_, err := sshutils.RunAndParse(ctx, g.Client(ctx), g.Logger(),
 			cmd, nil, sshutils.ParseAsString(&response))
 		if err != nil {
 			return wait.Continue(cmd)
@@ -434,7 +539,7 @@ func (g *gravity) RunInPlanet(ctx context.Context, cmd string, args ...string) (
 		g.installDir, cmd, strings.Join(args, " "))
 
 	var out string
-	_, err := sshutils.RunAndParse(ctx, g.Client(), g.Logger(), c, nil, sshutils.ParseAsString(&out))
+	_, err := sshutils.RunAndParse(ctx, g.Client(ctx), g.Logger(), c, nil, sshutils.ParseAsString(&out))
 	if err != nil {
 		return "", trace.Wrap(err)
 	}
@@ -7,6 +7,6 @@ import (
 )
 
 func (g *gravity) streamLogs(ctx context.Context) {
-	sshutil.Run(ctx, g.Client(), g.Logger().WithField("source", "journalctl"),
+	sshutil.Run(ctx, g.Client(ctx), g.Logger().WithField("source", "journalctl"),
 		"sudo /bin/journalctl -f -o cat", nil)
 }
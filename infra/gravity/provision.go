@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/gravitational/robotest/infra"
-	"github.com/gravitational/robotest/infra/terraform"
 	sshutil "github.com/gravitational/robotest/lib/ssh"
 	"github.com/gravitational/robotest/lib/utils"
 	"github.com/gravitational/robotest/lib/wait"
@@ -20,75 +19,104 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
-// cloudDynamicParams is a necessary evil to marry terraform vars, e2e legacy objects and needs of this provisioner
+// cloudDynamicParams is a necessary evil to marry provisioner configs, e2e legacy objects and needs of this provisioner
 type cloudDynamicParams struct {
 	ProvisionerConfig
-	user    string
-	homeDir string
-	tf      terraform.Config
-	env     map[string]string
+	// provisioner names the provisioner to create nodes with
+	provisioner string
+	user        string
+	homeDir     string
+	env         map[string]string
+	// nodeGroups lists the node groups resized to the number of nodes
+	nodeGroups []infra.NodeGroup
 }
 
 // makeDynamicParams takes base config, validates it and returns cloudDynamicParams
 func makeDynamicParams(t *testing.T, baseConfig ProvisionerConfig) cloudDynamicParams {
 	require.NotNil(t, baseConfig)
-	require.Contains(t, []string{"aws", "azure"}, baseConfig.CloudProvider)
 
-	param := cloudDynamicParams{ProvisionerConfig: baseConfig}
-
-	// OS name is cloud-init script specific
-	// enforce compatible values
-	var ok bool
-	usernames := map[string]map[string]string{
-		"azure": map[string]string{
-			"ubuntu": "robotest",
-			"debian": "admin",
-			"redhat": "redhat", // TODO: check
-			"centos": "centos",
-		},
-		"aws": map[string]string{
-			"ubuntu": "ubuntu",
-			"debian": "admin",
-			"redhat": "redhat",
-			"centos": "centos",
-		},
+	param := cloudDynamicParams{
+		ProvisionerConfig: baseConfig,
+		provisioner:       baseConfig.Provisioner,
+	}
+	if param.provisioner == "" {
+		param.provisioner = defaultProvisioner
+	}
+	require.Contains(t, infra.RegisteredProvisioners(), param.provisioner, "unknown provisioner")
+
+	if baseConfig.CloudProvider != "" {
+		// OS name is cloud-init script specific
+		// enforce compatible values
+		var ok bool
+		param.user, ok = cloudUser(baseConfig.CloudProvider, baseConfig.os)
+		require.True(t, ok, baseConfig.os)
+	}
+	if baseConfig.SSHUser != "" {
+		param.user = baseConfig.SSHUser
 	}
 
-	param.user, ok = usernames[baseConfig.CloudProvider][baseConfig.os]
-	require.True(t, ok, baseConfig.os)
-
-	param.homeDir = filepath.Join("/home", param.user)
+	param.nodeGroups = makeNodeGroups(t, baseConfig, param.user)
 
-	param.tf = terraform.Config{
-		CloudProvider: baseConfig.CloudProvider,
-		ScriptPath:    baseConfig.ScriptPath,
-		NumNodes:      int(baseConfig.nodeCount),
-		OS:            baseConfig.os,
+	if param.user != "" && sameUser(param.nodeGroups, param.user) {
+		param.homeDir = filepath.Join("/home", param.user)
 	}
 
 	if baseConfig.AWS != nil {
-		aws := *baseConfig.AWS
-		param.tf.AWS = &aws
-		param.tf.AWS.ClusterName = baseConfig.tag
-		param.tf.AWS.SSHUser = param.user
-
 		param.env = map[string]string{
-			"AWS_ACCESS_KEY_ID":     param.tf.AWS.AccessKey,
-			"AWS_SECRET_ACCESS_KEY": param.tf.AWS.SecretKey,
-			"AWS_DEFAULT_REGION":    param.tf.AWS.Region,
+			"AWS_ACCESS_KEY_ID":     baseConfig.AWS.AccessKey,
+			"AWS_SECRET_ACCESS_KEY": baseConfig.AWS.SecretKey,
+			"AWS_DEFAULT_REGION":    baseConfig.AWS.Region,
 		}
 	}
 
-	if baseConfig.Azure != nil {
-		azure := *baseConfig.Azure
-		param.tf.Azure = &azure
-		param.tf.Azure.ResourceGroup = baseConfig.tag
-		param.tf.Azure.SSHUser = param.user
+	return param
+}
+
+// makeNodeGroups returns the node groups of the config resized to the number of nodes.
+// Nodes of the groups with an SSH user other than the default one have it recorded on the group
+func makeNodeGroups(t *testing.T, config ProvisionerConfig, user string) []infra.NodeGroup {
+	groups := config.nodeGroups
+	if len(groups) == 0 {
+		return []infra.NodeGroup{{Count: int(config.nodeCount), OS: config.os}}
 	}
+	require.True(t, len(groups) == 1 || config.CloudProvider != "",
+		"node groups require a cloud provisioner")
 
-	return param
+	claimed := 0
+	for _, group := range groups[:len(groups)-1] {
+		claimed += group.Count
+	}
+	require.True(t, claimed < int(config.nodeCount),
+		"node groups %v require more than %v nodes", nodeGroupsTag(groups), config.nodeCount)
+
+	groups, err := infra.ResizeNodeGroups(groups, int(config.nodeCount))
+	require.NoError(t, err)
+	for i, group := range groups {
+		if config.SSHUser != "" {
+			continue
+		}
+		groupUser, ok := cloudUser(config.CloudProvider, group.OS)
+		require.True(t, ok, group.OS)
+		if config.CloudProvider == "aws" && groupUser != user {
+			// AWS images come with a preset user, while
+			// other clouds create the configured user on every node
+			groups[i].SSHUser = groupUser
+		}
+	}
+	return groups
+}
+
+// sameUser returns true if the nodes of all groups are accessed as the specified user
+func sameUser(groups []infra.NodeGroup, user string) bool {
+	for _, group := range groups {
+		if group.SSHUser != "" && group.SSHUser != user {
+			return false
+		}
+	}
+	return true
 }
 
 func configureVMs(baseCtx context.Context, log logrus.FieldLogger, params cloudDynamicParams, nodes []infra.Node) ([]Gravity, error) {
@@ -121,15 +149,45 @@ func configureVMs(baseCtx context.Context, log logrus.FieldLogger, params cloudD
 
 // Provision gets VMs up, running and ready to use
 func (c *TestContext) Provision(cfg ProvisionerConfig) ([]Gravity, DestroyFn, error) {
+	if cfg.Provisioner == FakeProvisioner {
+		return c.provisionFake(cfg)
+	}
 	validateConfig(c.t, cfg)
 	params := makeDynamicParams(c.t, cfg)
 
 	c.Logger().Debug("Provisioning VMs")
-	nodes, destroyFn, err := runTerraform(c.Context(), cfg, params)
+	p, nodes, err := runProvisioner(c.Context(), cfg, params)
 	if err != nil {
 		return nil, nil, trace.Wrap(err)
 	}
+	destroyFn := p.Destroy
 
+	gravityNodes, err := c.bootstrapNodes(params, nodes, nil)
+	if err != nil {
+		return nil, nil, trace.NewAggregate(err, destroyFn(c.Context()))
+	}
+	gravityNodes, spares := gravityNodes[:cfg.nodeCount], gravityNodes[cfg.nodeCount:]
+	c.params = params
+	c.nodes = append([]Gravity(nil), gravityNodes...)
+	c.scaler, _ = p.(infra.Scaler)
+	c.spares, c.fake = spares, nil
+
+	if snapshotter, ok := p.(infra.Snapshotter); ok {
+		c.snapshotter = snapshotter
+		err = c.Snapshot(BaselineBootstrapped)
+		if err != nil {
+			return nil, nil, trace.NewAggregate(err, destroyFn(c.Context()))
+		}
+	}
+
+	c.Logger().WithField("nodes", gravityNodes).Debug("Provisioning complete")
+
+	return gravityNodes, wrapDestroyFn(c, cfg.Tag(), destroyFn), nil
+}
+
+// bootstrapNodes configures the freshly provisioned nodes and waits until
+// they are ready to use, with clocks synchronized with existing nodes
+func (c *TestContext) bootstrapNodes(params cloudDynamicParams, nodes []infra.Node, existing []Gravity) ([]Gravity, error) {
 	ctx, cancel := context.WithTimeout(c.Context(), cloudInitTimeout)
 	defer cancel()
 
@@ -137,7 +195,11 @@ func (c *TestContext) Provision(cfg ProvisionerConfig) ([]Gravity, DestroyFn, er
 	gravityNodes, err := configureVMs(ctx, c.Logger(), params, nodes)
 	if err != nil {
 		c.Logger().WithError(err).Error("some nodes initialization failed, teardown this setup as non-usable")
-		return nil, nil, trace.NewAggregate(err, destroyFn(ctx))
+		return nil, trace.Wrap(err)
+	}
+
+	for _, node := range gravityNodes {
+		node.(*gravity).dialPeer = c.dialNode
 	}
 
 	c.Logger().Debug("Streaming logs")
@@ -150,26 +212,38 @@ func (c *TestContext) Provision(cfg ProvisionerConfig) ([]Gravity, DestroyFn, er
 
 	c.Logger().Debug("Synchronizing clocks")
 	timeNodes := []sshutil.SshNode{}
-	for _, node := range gravityNodes {
-		timeNodes = append(timeNodes, sshutil.SshNode{node.Client(), node.Logger()})
+	for _, node := range append(existing, gravityNodes...) {
+		timeNodes = append(timeNodes, sshutil.SshNode{Client: node.Client(ctx), Log: node.Logger()})
 	}
 	if err := sshutil.WaitTimeSync(ctx, timeNodes); err != nil {
-		return nil, nil, trace.NewAggregate(err, destroyFn(ctx))
+		return nil, trace.Wrap(err)
 	}
 
 	c.Logger().Debug("Ensuring disk speed is adequate across nodes")
 	ctx, cancel = context.WithTimeout(c.Context(), diskWaitTimeout)
 	defer cancel()
-	err = waitDisks(ctx, gravityNodes, []string{"/iotest", cfg.dockerDevice})
+	err = waitDisks(ctx, gravityNodes, diskPaths(params.ProvisionerConfig))
 	if err != nil {
 		err = trace.Wrap(err, "VM disks do not meet minimum write performance requirements")
 		c.Logger().WithError(err).Error(err.Error())
-		return nil, nil, err
+		return nil, err
 	}
+	return gravityNodes, nil
+}
 
-	c.Logger().WithField("nodes", gravityNodes).Debug("Provisioning complete")
-
-	return gravityNodes, wrapDestroyFn(c, cfg.Tag(), gravityNodes, destroyFn), nil
+// dialNode returns the SSH client of the node in use with the public or private address addr,
+// so nodes can copy files from each other with scp:// URLs
+func (c *TestContext) dialNode(ctx context.Context, addr string) (*ssh.Client, error) {
+	for _, node := range c.nodes {
+		if node.Node().Addr() != addr && node.Node().PrivateAddr() != addr {
+			continue
+		}
+		if client := node.Client(ctx); client != nil {
+			return client, nil
+		}
+		return nil, trace.ConnectionProblem(nil, "%v is offline", node)
+	}
+	return nil, trace.NotFound("no node with address %v", addr)
 }
 
 // sort Interface implementation
@@ -198,13 +272,13 @@ const cloudInitWait = time.Second * 10
 
 // bootstrapAzure workarounds some issues with Azure platform init
 func bootstrapAzure(ctx context.Context, g Gravity, param cloudDynamicParams) (err error) {
-	err = sshutil.WaitForFile(ctx, g.Client(), g.Logger(),
+	err = sshutil.WaitForFile(ctx, g.Client(ctx), g.Logger(),
 		waagentProvisionFile, sshutil.TestRegularFile)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
-	err = sshutil.TestFile(ctx, g.Client(), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
+	err = sshutil.TestFile(ctx, g.Client(ctx), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
 	if err == nil {
 		g.Logger().Debug("node already bootstrapped")
 		return nil
@@ -213,25 +287,25 @@ func bootstrapAzure(ctx context.Context, g Gravity, param cloudDynamicParams) (e
 		return trace.Wrap(err)
 	}
 
-	err = sshutil.TestFile(ctx, g.Client(), g.Logger(), cloudInitSupportedFile, sshutil.TestRegularFile)
+	err = sshutil.TestFile(ctx, g.Client(ctx), g.Logger(), cloudInitSupportedFile, sshutil.TestRegularFile)
 	if err == nil {
 		g.Logger().Debug("cloud-init underway")
-		return sshutil.WaitForFile(ctx, g.Client(), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
+		return sshutil.WaitForFile(ctx, g.Client(ctx), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
 	}
 	if !trace.IsNotFound(err) {
 		return trace.Wrap(err)
 	}
 
 	// apparently cloud-init scripts are not supported for given OS
-	err = sshutil.RunScript(ctx, g.Client(), g.Logger(),
-		filepath.Join(param.ScriptPath, "bootstrap", fmt.Sprintf("%s.sh", param.os)),
+	err = sshutil.RunScript(ctx, g.Client(ctx), g.Logger(),
+		filepath.Join(param.ScriptPath, "bootstrap", fmt.Sprintf("%s.sh", g.Node().Meta().OS)),
 		sshutil.SUDO)
 	return trace.Wrap(err)
 }
 
 // bootstrapAWS is a simple workflow to wait for cloud-init to complete
 func bootstrapAWS(ctx context.Context, g Gravity, param cloudDynamicParams) (err error) {
-	err = sshutil.WaitForFile(ctx, g.Client(), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
+	err = sshutil.WaitForFile(ctx, g.Client(ctx), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -239,12 +313,27 @@ func bootstrapAWS(ctx context.Context, g Gravity, param cloudDynamicParams) (err
 	return trace.Wrap(err)
 }
 
+// bootstrapGCP waits for the startup script to complete.
+// GCE runs startup scripts once the guest environment is up which can take
+// a while after SSH becomes available
+func bootstrapGCP(ctx context.Context, g Gravity, param cloudDynamicParams) (err error) {
+	err = sshutil.WaitForFile(ctx, g.Client(ctx), g.Logger(), cloudInitSupportedFile, sshutil.TestRegularFile)
+	if err != nil {
+		return trace.Wrap(err, "startup script has not started")
+	}
+
+	g.Logger().Debug("startup script underway")
+	err = sshutil.WaitForFile(ctx, g.Client(ctx), g.Logger(), cloudInitCompleteFile, sshutil.TestRegularFile)
+	return trace.Wrap(err)
+}
+
 // ConfigureNode is used to configure a provisioned node
 // 1. wait for node to boot
 // 2. (TODO) run bootstrap scripts - as Azure doesn't support them for RHEL/CentOS, will migrate here
 // 2.  - i.e. run bootstrap commands, load installer, etc.
 // TODO: migrate bootstrap scripts here as well;
 func configureVM(ctx context.Context, log logrus.FieldLogger, node infra.Node, param cloudDynamicParams) (Gravity, error) {
+	meta := node.Meta()
 	g := &gravity{
 		node:  node,
 		param: param,
@@ -252,6 +341,8 @@ func configureVM(ctx context.Context, log logrus.FieldLogger, node infra.Node, p
 		log: log.WithFields(logrus.Fields{
 			"ip":        node.PrivateAddr(),
 			"public_ip": node.Addr(),
+			"hostname":  meta.Hostname,
+			"os":        strings.TrimSpace(fmt.Sprintf("%v %v", meta.OS, meta.OSVersion)),
 		}),
 	}
 
@@ -261,21 +352,47 @@ func configureVM(ctx context.Context, log logrus.FieldLogger, node infra.Node, p
 	}
 	g.ssh = client
 
-	switch param.CloudProvider {
-	case "aws":
-		err = bootstrapAWS(ctx, g, param)
-	case "azure":
-		err = bootstrapAzure(ctx, g, param)
-	default:
-		return nil, trace.BadParameter("unsupported cloud provider %s", param.CloudProvider)
+	if g.param.homeDir == "" {
+		// SSH user is specific to the node
+		g.param.homeDir, err = homeDir(ctx, g)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
 	}
-	if err != nil {
-		return nil, trace.Wrap(err)
+
+	if param.CloudProvider != "" {
+		err = bootstrapCloud(ctx, g, param)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
 	}
 
 	return g, nil
 }
 
+// homeDir returns the home directory of the SSH user on the specified node
+func homeDir(ctx context.Context, g Gravity) (string, error) {
+	var out string
+	_, err := sshutil.RunAndParse(ctx, g.Client(ctx), g.Logger(), "echo $HOME", nil, sshutil.ParseAsString(&out))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	dir := strings.TrimSpace(out)
+	if dir == "" {
+		return "", trace.NotFound("failed to determine home directory on %v", g)
+	}
+	return dir, nil
+}
+
+// diskPaths returns the paths to test write performance on
+func diskPaths(config ProvisionerConfig) []string {
+	paths := []string{"/iotest"}
+	if config.dockerDevice != "" {
+		paths = append(paths, config.dockerDevice)
+	}
+	return paths
+}
+
 // waitDisks is a necessary workaround for Azure VMs to wait until their disk initialization processes are complete
 // otherwise it'll fail telekube pre-install checks
 func waitDisks(ctx context.Context, nodes []Gravity, paths []string) error {
@@ -295,10 +412,10 @@ func waitDisk(ctx context.Context, node Gravity, paths []string, minSpeed uint64
 	err := wait.Retry(ctx, func() error {
 		for _, p := range paths {
 			if !strings.HasPrefix(p, "/dev") {
-				defer sshutil.Run(ctx, node.Client(), node.Logger(), fmt.Sprintf("sudo /bin/rm -f %s", p), nil)
+				defer sshutil.Run(ctx, node.Client(ctx), node.Logger(), fmt.Sprintf("sudo /bin/rm -f %s", p), nil)
 			}
 			var out string
-			_, err := sshutil.RunAndParse(ctx, node.Client(), node.Logger(),
+			_, err := sshutil.RunAndParse(ctx, node.Client(ctx), node.Logger(),
 				fmt.Sprintf("sudo dd if=/dev/zero of=%s bs=100K count=1024 conv=fdatasync 2>&1", p),
 				nil, sshutil.ParseAsString(&out))
 			if err != nil {
@@ -0,0 +1,179 @@
+package gravity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+	_ "github.com/gravitational/robotest/infra/provisioners"
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultProvisioner is used unless the configuration specifies a provisioner
+const defaultProvisioner = "terraform"
+
+// makeProvisionerConfig returns the configuration to create the provisioner with
+func makeProvisionerConfig(param cloudDynamicParams) infra.ProvisionerConfig {
+	config := infra.ProvisionerConfig{
+		Config:         infra.Config{ClusterName: param.tag},
+		ScriptPath:     param.ScriptPath,
+		InstallerURL:   param.InstallerURL,
+		NumNodes:       int(param.nodeCount),
+		OS:             param.os,
+		CloudProvider:  param.CloudProvider,
+		JumpHost:       param.JumpHost,
+		SSHAuth:        param.SSHAuth,
+		VerifyHostKeys: param.VerifyHostKeys,
+	}
+	if len(param.nodeGroups) > 1 || param.nodeGroups[0].InstanceType != "" {
+		config.NodeGroups = param.nodeGroups
+	}
+
+	if param.AWS != nil {
+		aws := *param.AWS
+		config.AWS = &aws
+		config.AWS.ClusterName = param.tag
+		config.AWS.SSHUser = param.user
+	}
+
+	if param.Azure != nil {
+		azure := *param.Azure
+		config.Azure = &azure
+		config.Azure.ResourceGroup = param.tag
+		config.Azure.SSHUser = param.user
+	}
+
+	if param.GCP != nil {
+		gcp := *param.GCP
+		config.GCP = &gcp
+		config.GCP.ClusterName = param.tag
+		config.GCP.SSHUser = param.user
+	}
+
+	return config
+}
+
+// cloudUser returns the SSH user as defined by cloud-init scripts
+// for the configured cloud and OS
+func cloudUser(cloud, os string) (user string, ok bool) {
+	usernames := map[string]map[string]string{
+		"azure": map[string]string{
+			"ubuntu": "robotest",
+			"debian": "admin",
+			"redhat": "redhat", // TODO: check
+			"centos": "centos",
+		},
+		"aws": map[string]string{
+			"ubuntu": "ubuntu",
+			"debian": "admin",
+			"redhat": "redhat",
+			"centos": "centos",
+		},
+		"gcp": map[string]string{
+			"ubuntu": "robotest",
+			"debian": "robotest",
+			"redhat": "robotest",
+			"centos": "robotest",
+		},
+	}
+	user, ok = usernames[cloud][os]
+	return user, ok
+}
+
+// bootstrapCloud waits for cloud-specific node initialization to complete
+func bootstrapCloud(ctx context.Context, g Gravity, param cloudDynamicParams) error {
+	switch param.CloudProvider {
+	case "aws":
+		return bootstrapAWS(ctx, g, param)
+	case "azure":
+		return bootstrapAzure(ctx, g, param)
+	case "gcp":
+		return bootstrapGCP(ctx, g, param)
+	default:
+		return trace.BadParameter("unsupported cloud provider %s", param.CloudProvider)
+	}
+}
+
+// runProvisioner creates nodes with the configured provisioner.
+// Returns the provisioner along with the requested number of nodes,
+// followed by the spare nodes if the provisioner cannot add nodes later
+func runProvisioner(baseContext context.Context, baseConfig ProvisionerConfig, params cloudDynamicParams) (infra.Provisioner, []infra.Node, error) {
+	stateDir := filepath.Join(baseConfig.StateDir, params.provisioner)
+	if params.provisioner == defaultProvisioner {
+		// keep the existing state layout
+		stateDir = filepath.Join(baseConfig.StateDir, "tf")
+	}
+	err := os.MkdirAll(stateDir, constants.SharedDirMask)
+	if err != nil {
+		return nil, nil, trace.ConvertSystemError(err)
+	}
+
+	selector, err := infra.ParseSelector(baseConfig.NodeSelector)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	p, err := infra.NewProvisioner(params.provisioner, stateDir, makeProvisionerConfig(params))
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	if _, ok := p.(infra.Scaler); !ok && params.spareNodes != 0 {
+		// nodes cannot be added later, create the spare ones up front
+		params.nodeCount += params.spareNodes
+		params.nodeGroups, err = infra.ResizeNodeGroups(params.nodeGroups, int(params.nodeCount))
+		if err != nil {
+			return nil, nil, trace.Wrap(err)
+		}
+		p, err = infra.NewProvisioner(params.provisioner, stateDir, makeProvisionerConfig(params))
+		if err != nil {
+			return nil, nil, trace.Wrap(err)
+		}
+	}
+
+	// there's an internal retry in provisioners,
+	// however they get stuck sometimes and the only real way to deal with it is to kill and retry
+	// as they'll pick up incomplete state from cloud and proceed
+	// only second chance is provided
+	//
+	// TODO: this seems to require more thorough testing, and same approach applied to Destory
+	//
+	for _, threshold := range []time.Duration{time.Minute * 15, time.Minute * 10} {
+		ctx, cancel := context.WithTimeout(baseContext, threshold)
+		defer cancel()
+
+		_, err = p.Create(ctx, false)
+		if ctx.Err() != nil {
+			teardownCtx, cancel := context.WithTimeout(context.Background(), finalTeardownTimeout)
+			defer cancel()
+			err1 := trace.Errorf("[%v interrupted on apply due to upper context=%v, result=%v]", params.provisioner, ctx.Err(), err)
+			err2 := trace.Wrap(p.Destroy(teardownCtx))
+			return nil, nil, trace.NewAggregate(err1, err2)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if params.CloudProvider != "" {
+			resourceAllocated(baseConfig.Tag())
+		}
+
+		nodes, err := p.NodePool().AllocateMatching(int(params.nodeCount), selector)
+		if err != nil {
+			size := p.NodePool().Size()
+			if errDestroy := p.Destroy(baseContext); errDestroy != nil {
+				logrus.WithError(errDestroy).Warnf("failed to destroy %v nodes", params.provisioner)
+			}
+			// keep the error type to tell too few matching nodes from other failures
+			return nil, nil, trace.Wrap(err, "%v provisioned %v nodes, %v requested", params.provisioner, size, params.nodeCount)
+		}
+		return p, nodes, nil
+	}
+
+	return nil, nil, trace.NewAggregate(err, p.Destroy(baseContext))
+}
@@ -0,0 +1,95 @@
+package gravity
+
+import (
+	"context"
+
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/gravitational/trace"
+)
+
+// AddNodes provisions count more nodes and configures them the same way as
+// nodes created with Provision, so tests only pay for nodes to expand
+// the cluster with once they need them.
+// If the provisioner does not support adding nodes, the nodes are taken
+// from the spare nodes, see ProvisionerConfig.WithSpareNodes
+func (c *TestContext) AddNodes(count int) ([]Gravity, error) {
+	c.Logger().WithField("count", count).Info("adding nodes")
+	if c.fake != nil {
+		nodes := c.fake.grow(count)
+		c.nodes = append(c.nodes, nodes...)
+		return nodes, nil
+	}
+	if c.scaler == nil {
+		if count > len(c.spares) {
+			return nil, trace.NotImplemented("provisioner does not support adding nodes and %v spare nodes are left",
+				len(c.spares))
+		}
+		nodes := c.spares[:count:count]
+		c.spares = c.spares[count:]
+		c.nodes = append(c.nodes, nodes...)
+		return nodes, nil
+	}
+	ctx, cancel := context.WithTimeout(c.parent, scaleTimeout)
+	defer cancel()
+
+	nodes, err := c.scaler.Grow(ctx, count)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	gravityNodes, err := c.bootstrapNodes(c.params, nodes, c.nodes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	c.nodes = append(c.nodes, gravityNodes...)
+	return gravityNodes, nil
+}
+
+// ReleaseNodes destroys the specified nodes which must be the most recently added ones,
+// i.e. with AddNodes
+func (c *TestContext) ReleaseNodes(nodes []Gravity) error {
+	c.Logger().WithField("nodes", nodes).Info("releasing nodes")
+	if c.fake != nil {
+		if err := c.fake.shrink(nodes); err != nil {
+			return trace.Wrap(err)
+		}
+		c.removeNodes(nodes)
+		return nil
+	}
+	if c.scaler == nil {
+		return trace.NotImplemented("provisioner does not support removing nodes")
+	}
+	ctx, cancel := context.WithTimeout(c.parent, scaleTimeout)
+	defer cancel()
+
+	infraNodes := make([]infra.Node, 0, len(nodes))
+	for _, node := range nodes {
+		infraNodes = append(infraNodes, node.Node())
+	}
+	err := c.scaler.Shrink(ctx, infraNodes)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	for _, node := range nodes {
+		node.(*gravity).disconnect()
+	}
+	c.removeNodes(nodes)
+	return nil
+}
+
+// removeNodes removes the released nodes from the nodes in use
+func (c *TestContext) removeNodes(nodes []Gravity) {
+	released := make(map[Gravity]bool, len(nodes))
+	for _, node := range nodes {
+		released[node] = true
+	}
+	var remaining []Gravity
+	for _, node := range c.nodes {
+		if !released[node] {
+			remaining = append(remaining, node)
+		}
+	}
+	c.nodes = remaining
+}
@@ -0,0 +1,85 @@
+package gravity
+
+import (
+	"context"
+
+	sshutil "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// BaselineBootstrapped names the snapshot of freshly provisioned and configured nodes.
+	// Provision records it automatically if the provisioner supports snapshots
+	BaselineBootstrapped = "bootstrapped"
+	// BaselineInstalled names the snapshot of nodes with the application installed.
+	// Tests record it with Snapshot once installation completes
+	BaselineInstalled = "installed"
+)
+
+// SupportsSnapshots returns true if the nodes have been provisioned
+// with a provisioner that implements infra.Snapshotter
+func (c *TestContext) SupportsSnapshots() bool {
+	return c.snapshotter != nil
+}
+
+// Snapshot records the state of all provisioned nodes under the specified name
+func (c *TestContext) Snapshot(name string) error {
+	if c.snapshotter == nil {
+		return trace.NotImplemented("provisioner does not support snapshots")
+	}
+	ctx, cancel := context.WithTimeout(c.parent, snapshotTimeout)
+	defer cancel()
+
+	c.Logger().WithField("snapshot", name).Info("taking snapshot")
+	return trace.Wrap(c.snapshotter.Snapshot(ctx, name))
+}
+
+// Snapshots returns the names of the snapshots available
+func (c *TestContext) Snapshots() ([]string, error) {
+	if c.snapshotter == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(c.parent, snapshotTimeout)
+	defer cancel()
+
+	snapshots, err := c.snapshotter.ListSnapshots(ctx)
+	return snapshots, trace.Wrap(err)
+}
+
+// Restore reverts all provisioned nodes to the snapshot with the specified name,
+// i.e. BaselineBootstrapped, then reconnects to nodes and synchronizes clocks
+func (c *TestContext) Restore(nodes []Gravity, name string) error {
+	if c.snapshotter == nil {
+		return trace.NotImplemented("provisioner does not support snapshots")
+	}
+	ctx, cancel := context.WithTimeout(c.parent, snapshotTimeout)
+	defer cancel()
+
+	c.Logger().WithField("snapshot", name).Info("restoring snapshot")
+	err := c.snapshotter.Restore(ctx, name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(node Gravity) {
+			errs <- node.(*gravity).reconnect(ctx)
+		}(node)
+	}
+	err = utils.CollectErrors(ctx, errs)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	ctx, cancel = context.WithTimeout(c.parent, clockSyncTimeout)
+	defer cancel()
+
+	timeNodes := []sshutil.SshNode{}
+	for _, node := range nodes {
+		timeNodes = append(timeNodes, sshutil.SshNode{Client: node.Client(ctx), Log: node.Logger()})
+	}
+	return trace.Wrap(sshutil.WaitTimeSync(ctx, timeNodes))
+}
@@ -4,12 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/gravitational/robotest/infra"
-	"github.com/gravitational/robotest/infra/terraform"
 	"github.com/gravitational/robotest/lib/constants"
 
 	"github.com/gravitational/trace"
@@ -42,8 +39,11 @@ var testStatus = map[bool]string{true: "failed", false: "ok"}
 const finalTeardownTimeout = time.Minute * 5
 
 // wrapDestroyFn implements a global conditional logic
-func wrapDestroyFn(c *TestContext, tag string, nodes []Gravity, destroy func(context.Context) error) DestroyFn {
+func wrapDestroyFn(c *TestContext, tag string, destroy func(context.Context) error) DestroyFn {
 	return func() error {
+		// nodes might have been added or released since provisioning
+		nodes := c.nodes
+
 		defer func() {
 			if r := recover(); r != nil {
 				c.Logger().WithField("panic", r).Error("panic in terraform destroy")
@@ -103,7 +103,7 @@ var resourceAllocations = struct {
 	tags map[string]bool
 }{tags: map[string]bool{}}
 
-// resourceAllocated adds resource allocated into local index file for shell-based cleanup
+// resourceAllocated adds resource allocated into local index file for cleanup with the reaper
 // as test might crash and leak resources in the cloud
 func resourceAllocated(tag string) error {
 	resourceAllocations.Lock()
@@ -130,7 +130,7 @@ func saveResourceAllocations() error {
 		return nil
 	}
 
-	file, err := os.OpenFile(policy.ResourceListFile, os.O_RDWR|os.O_CREATE, constants.SharedReadMask)
+	file, err := os.OpenFile(policy.ResourceListFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.SharedReadMask)
 	if err != nil {
 		return trace.ConvertSystemError(err)
 	}
@@ -145,42 +145,3 @@ func saveResourceAllocations() error {
 
 	return nil
 }
-
-// terraform deals with underlying terraform provisioner
-func runTerraform(baseContext context.Context, baseConfig ProvisionerConfig, params cloudDynamicParams) ([]infra.Node, func(context.Context) error, error) {
-	// there's an internal retry in provisioners,
-	// however they get stuck sometimes and the only real way to deal with it is to kill and retry
-	// as they'll pick up incomplete state from cloud and proceed
-	// only second chance is provided
-	//
-	// TODO: this seems to require more thorough testing, and same approach applied to Destory
-	//
-
-	p, err := terraform.New(filepath.Join(baseConfig.StateDir, "tf"), params.tf)
-	if err != nil {
-		return nil, nil, trace.Wrap(err)
-	}
-
-	for _, threshold := range []time.Duration{time.Minute * 15, time.Minute * 10} {
-		ctx, cancel := context.WithTimeout(baseContext, threshold)
-		defer cancel()
-
-		_, err = p.Create(ctx, false)
-		if ctx.Err() != nil {
-			teardownCtx, cancel := context.WithTimeout(context.Background(), finalTeardownTimeout)
-			defer cancel()
-			err1 := trace.Errorf("[terraform interrupted on apply due to upper context=%v, result=%v]", ctx.Err(), err)
-			err2 := trace.Wrap(p.Destroy(teardownCtx))
-			return nil, nil, trace.NewAggregate(err1, err2)
-		}
-
-		if err != nil {
-			continue
-		}
-
-		resourceAllocated(baseConfig.Tag())
-		return p.NodePool().Nodes(), p.Destroy, nil
-	}
-
-	return nil, nil, trace.NewAggregate(err, p.Destroy(baseContext))
-}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gravitational/robotest/infra"
 	"github.com/gravitational/robotest/lib/xlog"
 
 	"cloud.google.com/go/pubsub"
@@ -36,6 +37,21 @@ type TestContext struct {
 	param    interface{}
 	logLink  string
 	status   string
+	// snapshotter is set if the provisioner of the nodes supports snapshots
+	snapshotter infra.Snapshotter
+	// scaler is set if the provisioner of the nodes supports adding and removing nodes
+	scaler infra.Scaler
+	// spares lists the nodes provisioned up front for AddNodes if the provisioner
+	// does not support adding nodes, see ProvisionerConfig.WithSpareNodes
+	spares []Gravity
+	// fake is the simulated cluster of the fake provisioner
+	fake *FakeCluster
+	// params is the configuration the nodes have been provisioned with
+	params cloudDynamicParams
+	// nodes lists the provisioned nodes in use
+	nodes []Gravity
+	// distribution defines how SetInstaller distributes installers to nodes
+	distribution InstallerDistribution
 }
 
 // Run allows a running test to spawn a subtest
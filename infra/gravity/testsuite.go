@@ -155,7 +155,7 @@ func (s *testSuite) wrap(fn TestFunc, cfg ProvisionerConfig, param interface{})
 
 	labels := logrus.Fields{}
 	labels["__tag__"] = cfg.Tag()
-	labels["__os__"] = cfg.os
+	labels["__os__"] = cfg.osTag()
 	labels["__storage__"] = cfg.storageDriver
 
 	var logLink string
@@ -176,15 +176,16 @@ func (s *testSuite) wrap(fn TestFunc, cfg ProvisionerConfig, param interface{})
 		defer cancelFn()
 
 		cx := &TestContext{
-			t:        t,
-			name:     cfg.Tag(),
-			parent:   ctx,
-			timeouts: DefaultTimeouts,
-			uid:      uid,
-			suite:    s,
-			param:    param,
-			logLink:  logLink,
-			log:      xlog.NewLogger(s.client, t, labels),
+			t:            t,
+			name:         cfg.Tag(),
+			parent:       ctx,
+			timeouts:     DefaultTimeouts,
+			distribution: DefaultInstallerDistribution,
+			uid:          uid,
+			suite:        s,
+			param:        param,
+			logLink:      logLink,
+			log:          xlog.NewLogger(s.client, t, labels),
 		}
 		defer func() {
 			if r := recover(); r != nil {
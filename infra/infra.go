@@ -107,7 +107,51 @@ type Provisioner interface {
 	State() ProvisionerState
 }
 
-// NodePool manages node allocation/release for a provisioner
+// Snapshotter is an optional interface implemented by provisioners
+// capable of recording the state of all cluster nodes and reverting to it later.
+// Snapshots are removed along with the cluster in Provisioner.Destroy
+type Snapshotter interface {
+	// Snapshot records the current state of all nodes under the specified name.
+	// An existing snapshot with the same name is replaced
+	Snapshot(ctx context.Context, name string) error
+	// Restore reverts all nodes to the snapshot with the specified name.
+	// Connections to nodes do not survive the restore
+	Restore(ctx context.Context, name string) error
+	// ListSnapshots returns the sorted names of snapshots available for all nodes
+	ListSnapshots(ctx context.Context) ([]string, error)
+}
+
+// Scaler is an optional interface implemented by provisioners capable of
+// adding nodes to and removing nodes from a provisioned cluster.
+// Nodes are numbered in the order of creation and only the most recently
+// added nodes can be removed
+type Scaler interface {
+	// Grow provisions count more nodes, adds them to the node pool and returns them
+	Grow(ctx context.Context, count int) ([]Node, error)
+	// Shrink destroys the specified nodes and removes them from the node pool.
+	// Returns BadParameter if the nodes are not the most recently added ones
+	Shrink(ctx context.Context, nodes []Node) error
+}
+
+// NodeLifecycle is an optional interface implemented by nodes whose power
+// state can be controlled through the provisioner.
+// Operations block until the node reaches the requested state.
+// Nodes might change their public address on Start or Reset
+type NodeLifecycle interface {
+	// Stop gracefully shuts down the node.
+	// Stopping a node that is already shutting down waits for it to stop
+	Stop(ctx context.Context) error
+	// Start powers on a stopped node.
+	// Starting a running node is a no-op
+	Start(ctx context.Context) error
+	// Restart gracefully restarts the node
+	Restart(ctx context.Context) error
+	// Reset forcibly restarts the node as with power cycle
+	Reset(ctx context.Context) error
+}
+
+// NodePool manages node allocation/release for a provisioner.
+// Implementations are safe for concurrent use
 type NodePool interface {
 	// Nodes returns all nodes in this pool
 	Nodes() []Node
@@ -121,10 +165,23 @@ type NodePool interface {
 	// SizeAllocated returns the number of allocated nodes in this pool
 	SizeAllocated() int
 	// Allocate allocates amount new nodes from the pool and returns
-	// a slice of allocated nodes
+	// a slice of allocated nodes.
+	// Nodes are allocated in the order they have been added to the pool
 	Allocate(amount int) ([]Node, error)
+	// AllocateMatching allocates amount new nodes with labels matching the selector,
+	// i.e. 2 nodes with role=master.
+	// Returns NotFound if there are not enough matching nodes
+	AllocateMatching(amount int, selector Selector) ([]Node, error)
 	// Free releases specified nodes back to the node pool
 	Free([]Node) error
+	// Add adds the specified nodes to the pool as unallocated nodes
+	Add(nodes ...Node)
+	// Remove removes the specified nodes from the pool
+	Remove(nodes ...Node)
+	// Update runs the update function which changes the addresses of nodes
+	// and reindexes the pool by the new addresses preserving the allocation.
+	// Concurrent pool operations wait for the update to complete
+	Update(update func())
 }
 
 // Node defines an interface to a remote node
@@ -137,8 +194,28 @@ type Node interface {
 	// that can be used to execute remote commands
 	Connect() (*ssh.Session, error)
 	// Client connects to this node and returns a new SSH Client object
-	// that can be used to execute remote commands
+	// that can be used to execute remote commands.
+	// See Client for the cached client of the node
 	Client() (*ssh.Client, error)
+	// Meta returns the metadata of the node as known to the provisioner
+	Meta() NodeMeta
+}
+
+// NodeMeta describes a node beyond its addresses.
+// Provisioners fill in what they know, so any of the attributes can be empty
+type NodeMeta struct {
+	// Hostname is the hostname of the node
+	Hostname string
+	// OS is the OS distribution, i.e. ubuntu or centos
+	OS string
+	// OSVersion is the version of the OS distribution, i.e. 16.04 or 7
+	OSVersion string
+	// InstanceType is the cloud-specific instance type, i.e. c4.xlarge
+	InstanceType string
+	// SSHUser is the user to connect to the node as
+	SSHUser string
+	// Labels is an optional set of arbitrary labels attached to the node
+	Labels map[string]string
 }
 
 var defaultLogger = log.New()
@@ -169,11 +246,16 @@ func Distribute(command string, nodes ...Node) error {
 }
 
 // Run executes the specified command on node and streams
-// session's Stdout/Stderr to the specified w
+// session's Stdout/Stderr to the specified w.
+// The command runs in a session of the cached SSH client of node
 func Run(node Node, command string, w io.Writer) (err error) {
 	var session *ssh.Session
 	err = wait.Retry(context.TODO(), func() error {
-		session, err = node.Connect()
+		session, err = Session(context.TODO(), node)
+		if trace.IsCompareFailed(err) {
+			// host key mismatch will not go away with retries
+			return wait.Abort(trace.Wrap(err))
+		}
 		if err != nil {
 			log.Debug(trace.DebugReport(err))
 		}
@@ -0,0 +1,119 @@
+package inventory
+
+import (
+	"io/ioutil"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Validate validates the configuration
+func (r *Config) Validate() error {
+	var errors []error
+	if r.InventoryPath == "" {
+		errors = append(errors, trace.BadParameter("inventory path is required"))
+	}
+	return trace.NewAggregate(errors...)
+}
+
+type Config struct {
+	infra.Config
+	// InventoryPath is the path to the inventory file (YAML or JSON)
+	// describing the hosts
+	InventoryPath string `json:"inventory_path"`
+	// InstallerURL is a path to the installer
+	InstallerURL string `json:"installer_url"`
+	// JumpHost optionally defines the SSH bastion host to connect to hosts through
+	JumpHost *infra.JumpHostConfig `json:"jump_host,omitempty"`
+	// SSHAuth optionally configures additional methods to authenticate to hosts with
+	SSHAuth *infra.SSHAuthConfig `json:"ssh_auth,omitempty"`
+	// VerifyHostKeys enables SSH host key verification with trust on first use
+	VerifyHostKeys bool `json:"verify_host_keys"`
+}
+
+// Inventory describes a set of existing hosts
+type Inventory struct {
+	// SSHUser defines the default SSH user for hosts that do not specify one
+	SSHUser string `json:"ssh_user" yaml:"ssh_user"`
+	// SSHKeyPath defines the default SSH private key for hosts that do not specify one
+	SSHKeyPath string `json:"ssh_key_path" yaml:"ssh_key_path"`
+	// Hosts lists the hosts
+	Hosts []Host `json:"hosts" yaml:"hosts"`
+}
+
+// Host describes a single host in the inventory
+type Host struct {
+	// PublicAddr is the address robotest connects to
+	PublicAddr string `json:"public_addr" yaml:"public_addr"`
+	// PrivateAddr is the address used for cluster communication
+	// and the address robotest connects to through the jump host.
+	// Defaults to PublicAddr
+	PrivateAddr string `json:"private_addr" yaml:"private_addr"`
+	// SSHUser defines the SSH user to connect as
+	SSHUser string `json:"ssh_user" yaml:"ssh_user"`
+	// SSHKeyPath defines the location of the SSH private key.
+	// Optional if hosts are authenticated to with SSH agent or password
+	SSHKeyPath string `json:"ssh_key_path" yaml:"ssh_key_path"`
+	// Labels is an optional set of labels attached to this host
+	Labels map[string]string `json:"labels" yaml:"labels"`
+}
+
+// LoadInventory reads the inventory from the file at path
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	inventory, err := ParseInventory(data)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to parse inventory %v", path)
+	}
+	return inventory, nil
+}
+
+// ParseInventory parses the inventory from data in either YAML or JSON format.
+// Host-level SSH settings that are not specified default to the inventory-level ones
+func ParseInventory(data []byte) (*Inventory, error) {
+	var inventory Inventory
+	// JSON documents are valid YAML
+	err := yaml.Unmarshal(data, &inventory)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if len(inventory.Hosts) == 0 {
+		return nil, trace.BadParameter("inventory has no hosts")
+	}
+
+	var errors []error
+	seen := make(map[string]struct{}, len(inventory.Hosts))
+	for i := range inventory.Hosts {
+		host := &inventory.Hosts[i]
+		if host.PublicAddr == "" {
+			errors = append(errors, trace.BadParameter("host %v: public address is required", i))
+			continue
+		}
+		if _, ok := seen[host.PublicAddr]; ok {
+			errors = append(errors, trace.BadParameter("host %v: duplicate address %v", i, host.PublicAddr))
+		}
+		seen[host.PublicAddr] = struct{}{}
+		if host.PrivateAddr == "" {
+			host.PrivateAddr = host.PublicAddr
+		}
+		if host.SSHUser == "" {
+			host.SSHUser = inventory.SSHUser
+		}
+		if host.SSHKeyPath == "" {
+			host.SSHKeyPath = inventory.SSHKeyPath
+		}
+		if host.SSHUser == "" {
+			errors = append(errors, trace.BadParameter("host %v: SSH user is required", host.PublicAddr))
+		}
+	}
+	if len(errors) != 0 {
+		return nil, trace.NewAggregate(errors...)
+	}
+	return &inventory, nil
+}
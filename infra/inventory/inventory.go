@@ -0,0 +1,414 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/lib/constants"
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/robotest/lib/utils"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// New creates a new provisioner for a static set of existing hosts
+// described by the inventory file in config.
+// The provisioner never creates or powers off hosts - it only manages
+// the gravity state on them
+func New(stateDir string, config Config) (*inventory, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateDir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &inventory{
+		Entry: log.WithFields(log.Fields{
+			constants.FieldProvisioner: "inventory",
+			constants.FieldCluster:     config.ClusterName,
+		}),
+		Config:   config,
+		stateDir: stateDir,
+		// will be reset in Create
+		pool:       infra.NewNodePool(nil, nil),
+		knownHosts: knownHosts,
+		jumpHost:   config.JumpHost.JumpHost(),
+	}, nil
+}
+
+// NewFromState restores the provisioner from the previously saved state
+func NewFromState(config Config, stateConfig infra.ProvisionerState) (*inventory, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateConfig.Dir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	r := &inventory{
+		Entry: log.WithFields(log.Fields{
+			constants.FieldProvisioner: "inventory",
+			constants.FieldCluster:     config.ClusterName,
+		}),
+		Config:        config,
+		stateDir:      stateConfig.Dir,
+		installerIP:   stateConfig.InstallerAddr,
+		installerPath: stateConfig.InstallerPath,
+		knownHosts:    knownHosts,
+		jumpHost:      config.JumpHost.JumpHost(),
+	}
+	nodes := make([]infra.Node, 0, len(stateConfig.Nodes))
+	for _, n := range stateConfig.Nodes {
+		nodes = append(nodes, &node{
+			publicIP:     n.Addr,
+			privateIP:    n.PrivateAddr,
+			user:         n.User,
+			identityFile: n.KeyPath,
+			labels:       n.Labels,
+			meta:         n.Meta(),
+			auth:         r.sshAuth(n.KeyPath),
+			jumpHost:     r.jumpHost,
+			knownHosts:   knownHosts,
+		})
+	}
+	r.pool = infra.NewNodePool(nodes, stateConfig.Allocated)
+	return r, nil
+}
+
+// Create loads the inventory and validates that all hosts are reachable over SSH
+func (r *inventory) Create(ctx context.Context, withInstaller bool) (installer infra.Node, err error) {
+	inventory, err := LoadInventory(r.InventoryPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	nodes := make([]infra.Node, 0, len(inventory.Hosts))
+	for _, host := range inventory.Hosts {
+		nodes = append(nodes, &node{
+			publicIP:     host.PublicAddr,
+			privateIP:    host.PrivateAddr,
+			user:         host.SSHUser,
+			identityFile: host.SSHKeyPath,
+			labels:       host.Labels,
+			auth:         r.sshAuth(host.SSHKeyPath),
+			jumpHost:     r.jumpHost,
+			knownHosts:   r.knownHosts,
+		})
+	}
+
+	err = checkReachable(ctx, nodes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	r.pool = infra.NewNodePool(nodes, nil)
+	r.Debugf("cluster: %#v", r.pool)
+
+	if !withInstaller {
+		// No need to pick installer node
+		return nil, nil
+	}
+
+	// Use first node as installer
+	r.installerIP = nodes[0].Addr()
+	node, err := r.pool.Node(r.installerIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	err = r.transferInstaller(ctx, node)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node, nil
+}
+
+// Destroy removes gravity state from all hosts.
+// Hosts are left running
+func (r *inventory) Destroy(ctx context.Context) error {
+	r.Debugf("wiping gravity state from inventory hosts: %v", r.InventoryPath)
+
+	nodes := r.pool.Nodes()
+	errCh := make(chan error, len(nodes))
+	for _, n := range nodes {
+		go func(node infra.Node) {
+			errCh <- r.wipe(ctx, node)
+		}(n)
+	}
+	err := utils.CollectErrors(ctx, errCh)
+	infra.Disconnect(nodes...)
+	return trace.Wrap(err)
+}
+
+func (r *inventory) SelectInterface(installer infra.Node, addrs []string) (int, error) {
+	for i, addr := range addrs {
+		if addr == installer.PrivateAddr() {
+			return i, nil
+		}
+	}
+	for i, addr := range addrs {
+		if addr == installer.Addr() {
+			return i, nil
+		}
+	}
+	return -1, trace.NotFound("failed to select installer interface from %v", addrs)
+}
+
+// Connect establishes an SSH connection to the specified address
+func (r *inventory) Connect(addrIP string) (*ssh.Session, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Connect()
+}
+
+// Client establishes an SSH connection to the specified address
+func (r *inventory) Client(addrIP string) (*ssh.Client, error) {
+	node, err := r.pool.Node(addrIP)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return node.Client()
+}
+
+func (r *inventory) StartInstall(session *ssh.Session) error {
+	if r.installerPath == "" {
+		return trace.NotFound("installer has not been transferred to the installer node")
+	}
+	return session.Start(fmt.Sprintf(installerCommand, r.installerPath))
+}
+
+func (r *inventory) UploadUpdate(session *ssh.Session) error {
+	node, err := r.pool.Node(r.installerIP)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	// transfer the new installer to the installer node
+	err = r.transferInstaller(context.TODO(), node)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return session.Run(fmt.Sprintf(uploadUpdateCommand, r.installerPath))
+}
+
+func (r *inventory) NodePool() infra.NodePool {
+	return r.pool
+}
+
+func (r *inventory) InstallerLogPath() string {
+	return installerLogPath
+}
+
+func (r *inventory) State() infra.ProvisionerState {
+	nodes := make([]infra.StateNode, 0, r.pool.Size())
+	for _, n := range r.pool.Nodes() {
+		node := n.(*node)
+		nodes = append(nodes, infra.StateNode{
+			Addr:        node.publicIP,
+			PrivateAddr: node.privateIP,
+			KeyPath:     node.identityFile,
+		}.WithMeta(node.Meta()))
+	}
+	allocated := make([]string, 0, r.pool.SizeAllocated())
+	for _, node := range r.pool.AllocatedNodes() {
+		allocated = append(allocated, node.Addr())
+	}
+	return infra.ProvisionerState{
+		Dir:           r.stateDir,
+		InstallerAddr: r.installerIP,
+		InstallerPath: r.installerPath,
+		Nodes:         nodes,
+		Allocated:     allocated,
+	}
+}
+
+// transferInstaller copies the installer tarball to the specified node
+func (r *inventory) transferInstaller(ctx context.Context, node infra.Node) error {
+	if r.InstallerURL == "" {
+		return nil
+	}
+	client, err := node.Client()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer client.Close()
+
+	path, err := sshutils.TransferFile(ctx, client, r.Entry, r.InstallerURL, workDir, nil)
+	if err != nil {
+		return trace.Wrap(err, "failed to transfer installer %v to %v", r.InstallerURL, node)
+	}
+	r.installerPath = path
+	return nil
+}
+
+// wipe uninstalls gravity and removes its state from the specified node
+func (r *inventory) wipe(ctx context.Context, node infra.Node) error {
+	client, err := node.Client()
+	if err != nil {
+		return trace.Wrap(err, "failed to connect to %v", node)
+	}
+	defer client.Close()
+
+	err = sshutils.Run(ctx, client, r.Entry, wipeCommand, nil)
+	if err != nil {
+		return trace.Wrap(err, "failed to wipe gravity state on %v", node)
+	}
+	return nil
+}
+
+// checkReachable validates that all nodes accept SSH connections
+// and can execute commands.
+// Discovers node metadata along the way
+func checkReachable(ctx context.Context, nodes []infra.Node) error {
+	errCh := make(chan error, len(nodes))
+	for _, n := range nodes {
+		go func(node *node) {
+			errCh <- node.discoverMeta(ctx)
+		}(n.(*node))
+	}
+	return trace.Wrap(utils.CollectErrors(ctx, errCh))
+}
+
+// discoverMeta records the hostname and OS release of the host
+func (r *node) discoverMeta(ctx context.Context) error {
+	client, err := r.Client()
+	if err != nil {
+		return trace.Wrap(err, "%v is not reachable", r)
+	}
+	defer client.Close()
+
+	var hostname, release string
+	_, err = sshutils.RunAndParse(ctx, client, log.StandardLogger(), "hostname", nil, sshutils.ParseAsString(&hostname))
+	if err != nil {
+		return trace.Wrap(err, "failed to run command on %v", r)
+	}
+	r.meta.Hostname = strings.TrimSpace(hostname)
+
+	_, err = sshutils.RunAndParse(ctx, client, log.StandardLogger(), "cat /etc/os-release", nil, sshutils.ParseAsString(&release))
+	if err != nil {
+		log.WithError(err).Warnf("failed to read OS release of %v", r)
+		return nil
+	}
+	r.meta.OS, r.meta.OSVersion = infra.ParseOSRelease([]byte(release))
+	return nil
+}
+
+func (r *node) Addr() string {
+	return r.publicIP
+}
+
+func (r *node) PrivateAddr() string {
+	if r.privateIP == "" {
+		return r.publicIP
+	}
+	return r.privateIP
+}
+
+// Meta returns the node metadata.
+// Labels of the inventory host take precedence over the labels describing the OS and instance type
+func (r *node) Meta() infra.NodeMeta {
+	meta := r.meta
+	meta.SSHUser = r.user
+	meta.Labels = r.labels
+	return meta.WithLabels("")
+}
+
+func (r *node) Connect() (*ssh.Session, error) {
+	client, err := r.Client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return session, nil
+}
+
+func (r *node) Client() (*ssh.Client, error) {
+	return sshutils.ClientVia(r.jumpHost, fmt.Sprintf("%v:22", r.sshAddr()), r.user, r.auth,
+		sshutils.HostKeys(r.knownHosts))
+}
+
+// sshAddr returns the address to connect to the host at.
+// With a jump host, hosts are reached at their private addresses
+func (r *node) sshAddr() string {
+	if r.jumpHost != nil {
+		return r.PrivateAddr()
+	}
+	return r.publicIP
+}
+
+func (r node) String() string {
+	return fmt.Sprintf("node(addr=%v, user=%v)", r.publicIP, r.user)
+}
+
+type inventory struct {
+	*log.Entry
+	Config
+
+	pool        infra.NodePool
+	stateDir    string
+	installerIP string
+	// installerPath is the location of the installer tarball on the installer node
+	installerPath string
+	// knownHosts records host keys of hosts if host keys are verified
+	knownHosts *sshutils.KnownHosts
+	// jumpHost is the optional SSH bastion host to connect to hosts through
+	jumpHost *sshutils.JumpHost
+	// auths maps SSH key paths to the authentication of hosts using the key
+	auths map[string]*sshutils.Auth
+}
+
+// sshAuth returns the SSH authentication with the key at keyPath.
+// Hosts with the same key share the authentication so the key is loaded once
+func (r *inventory) sshAuth(keyPath string) *sshutils.Auth {
+	if auth, ok := r.auths[keyPath]; ok {
+		return auth
+	}
+	if r.auths == nil {
+		r.auths = make(map[string]*sshutils.Auth)
+	}
+	auth := r.SSHAuth.Auth(keyPath)
+	r.auths[keyPath] = auth
+	return auth
+}
+
+type node struct {
+	publicIP     string
+	privateIP    string
+	user         string
+	identityFile string
+	labels       map[string]string
+	// meta is the node metadata discovered on the host
+	meta infra.NodeMeta
+	// auth authenticates SSH connections to the host
+	auth *sshutils.Auth
+	// jumpHost is the optional SSH bastion host to connect through
+	jumpHost *sshutils.JumpHost
+	// knownHosts records host keys of hosts if host keys are verified
+	knownHosts *sshutils.KnownHosts
+}
+
+// workDir is the directory on the hosts that keeps the installer
+const workDir = "/var/tmp/robotest"
+
+const installerCommand = `
+mkdir -p /var/tmp/robotest/installer; \
+tar -xvf %v -C /var/tmp/robotest/installer; \
+/var/tmp/robotest/installer/install`
+
+const uploadUpdateCommand = `
+rm -rf /var/tmp/robotest/installer; mkdir -p /var/tmp/robotest/installer; \
+tar -xvf %v -C /var/tmp/robotest/installer; \
+cd /var/tmp/robotest/installer/; sudo ./upload`
+
+// wipeCommand removes gravity from a host on a best-effort basis
+const wipeCommand = `
+if command -v gravity >/dev/null 2>&1; then sudo gravity system uninstall --confirm || true; fi; \
+sudo rm -rf /var/lib/gravity /var/tmp/robotest`
+
+const installerLogPath = "/var/tmp/robotest/installer/gravity.log"
@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gravitational/robotest/infra"
+)
+
+func TestParsesInventory(t *testing.T) {
+	expected := []Host{
+		{
+			PublicAddr:  "10.0.0.1",
+			PrivateAddr: "192.168.0.1",
+			SSHUser:     "centos",
+			SSHKeyPath:  "/keys/lab",
+			Labels:      map[string]string{"role": "master"},
+		},
+		{
+			PublicAddr:  "10.0.0.2",
+			PrivateAddr: "10.0.0.2",
+			SSHUser:     "ubuntu",
+			SSHKeyPath:  "/keys/node2",
+		},
+	}
+	var testCases = []struct {
+		comment string
+		data    []byte
+	}{
+		{
+			comment: "YAML",
+			data: []byte(`
+ssh_user: centos
+ssh_key_path: /keys/lab
+hosts:
+- public_addr: 10.0.0.1
+  private_addr: 192.168.0.1
+  labels:
+    role: master
+- public_addr: 10.0.0.2
+  ssh_user: ubuntu
+  ssh_key_path: /keys/node2
+`),
+		},
+		{
+			comment: "JSON",
+			data: []byte(`{
+  "ssh_user": "centos",
+  "ssh_key_path": "/keys/lab",
+  "hosts": [
+    {"public_addr": "10.0.0.1", "private_addr": "192.168.0.1", "labels": {"role": "master"}},
+    {"public_addr": "10.0.0.2", "ssh_user": "ubuntu", "ssh_key_path": "/keys/node2"}
+  ]
+}`),
+		},
+	}
+
+	for _, testCase := range testCases {
+		inventory, err := ParseInventory(testCase.data)
+		if err != nil {
+			t.Errorf("%v: failed to parse inventory: %v", testCase.comment, err)
+			continue
+		}
+		if !reflect.DeepEqual(inventory.Hosts, expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, expected, inventory.Hosts)
+		}
+	}
+}
+
+func TestRejectsInvalidInventory(t *testing.T) {
+	var testCases = []struct {
+		comment string
+		data    []byte
+	}{
+		{comment: "No hosts", data: []byte(`ssh_user: centos`)},
+		{comment: "Missing address", data: []byte(`{"hosts": [{"ssh_user": "centos", "ssh_key_path": "/key"}]}`)},
+		{comment: "Missing SSH user", data: []byte(`{"hosts": [{"public_addr": "10.0.0.1", "ssh_key_path": "/key"}]}`)},
+		{comment: "Duplicate address", data: []byte(`
+ssh_user: centos
+ssh_key_path: /key
+hosts:
+- public_addr: 10.0.0.1
+- public_addr: 10.0.0.1
+`)},
+	}
+
+	for _, testCase := range testCases {
+		_, err := ParseInventory(testCase.data)
+		if err == nil {
+			t.Errorf("%v: expected an error", testCase.comment)
+		}
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	state := infra.ProvisionerState{
+		Dir:           "/tmp/state",
+		InstallerAddr: "10.0.0.1",
+		InstallerPath: "/var/lib/robotest/installer.tar",
+		Nodes: []infra.StateNode{
+			{Addr: "10.0.0.1", PrivateAddr: "192.168.0.1", KeyPath: "/keys/lab", User: "centos", Labels: map[string]string{"role": "master"}},
+			{Addr: "10.0.0.2", PrivateAddr: "10.0.0.2", KeyPath: "/keys/lab", User: "centos"},
+		},
+		Allocated: []string{"10.0.0.2"},
+	}
+
+	r, err := NewFromState(Config{Config: infra.Config{ClusterName: "test"}, InventoryPath: "inventory.yaml"}, state)
+	if err != nil {
+		t.Fatalf("failed to restore from state: %v", err)
+	}
+	obtained := r.State()
+	// node pool does not preserve the order of nodes
+	sort.Slice(obtained.Nodes, func(i, j int) bool { return obtained.Nodes[i].Addr < obtained.Nodes[j].Addr })
+	if !reflect.DeepEqual(obtained, state) {
+		t.Errorf("expected %v but got %v", state, obtained)
+	}
+}
@@ -0,0 +1,43 @@
+package inventory
+
+import (
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	infra.RegisterProvisioner("inventory", infra.ProvisionerFactory{
+		New: func(stateDir string, config infra.ProvisionerConfig) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return New(stateDir, *c)
+		},
+		NewFromState: func(config infra.ProvisionerConfig, state infra.ProvisionerState) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return NewFromState(*c, state)
+		},
+	})
+}
+
+// newConfig creates the configuration from the generic one.
+// ScriptPath specifies the inventory file
+func newConfig(config infra.ProvisionerConfig) (*Config, error) {
+	c := &Config{
+		Config:         config.Config,
+		InventoryPath:  config.ScriptPath,
+		InstallerURL:   config.InstallerURL,
+		JumpHost:       config.JumpHost,
+		SSHAuth:        config.SSHAuth,
+		VerifyHostKeys: config.VerifyHostKeys,
+	}
+	err := c.Validate()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return c, nil
+}
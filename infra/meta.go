@@ -0,0 +1,62 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DetectOS determines the OS distribution and version of the node from /etc/os-release
+func DetectOS(ctx context.Context, node Node, logger log.FieldLogger) (os, version string, err error) {
+	client, err := Client(ctx, node)
+	if err != nil {
+		return "", "", trace.Wrap(err)
+	}
+
+	var out string
+	_, err = sshutils.RunAndParse(ctx, client, logger, "cat /etc/os-release", nil, sshutils.ParseAsString(&out))
+	if err != nil {
+		return "", "", trace.Wrap(err, "failed to read OS release of %v", node)
+	}
+	os, version = ParseOSRelease([]byte(out))
+	if os == "" {
+		return "", "", trace.NotFound("no OS distribution in OS release of %v", node)
+	}
+	return os, version, nil
+}
+
+// ParseOSRelease returns the distribution ID and version from the contents of os-release(5),
+// i.e. "centos" and "7" or "ubuntu" and "16.04".
+// Red Hat Enterprise Linux is reported as "redhat" to match provisioner configuration
+func ParseOSRelease(data []byte) (os, version string) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		switch parts[0] {
+		case "ID":
+			os = value
+		case "VERSION_ID":
+			version = value
+		}
+	}
+	if os == "rhel" {
+		os = "redhat"
+	}
+	return os, version
+}
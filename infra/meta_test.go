@@ -0,0 +1,75 @@
+package infra
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsesOSRelease(t *testing.T) {
+	var testCases = []struct {
+		comment string
+		release string
+		os      string
+		version string
+	}{
+		{
+			comment: "Ubuntu",
+			release: `NAME="Ubuntu"
+VERSION="16.04.5 LTS (Xenial Xerus)"
+ID=ubuntu
+ID_LIKE=debian
+VERSION_ID="16.04"
+`,
+			os:      "ubuntu",
+			version: "16.04",
+		},
+		{
+			comment: "CentOS",
+			release: `NAME="CentOS Linux"
+ID="centos"
+ID_LIKE="rhel fedora"
+VERSION_ID="7"
+`,
+			os:      "centos",
+			version: "7",
+		},
+		{
+			comment: "Red Hat Enterprise Linux",
+			release: `ID="rhel"
+VERSION_ID='7.4'
+`,
+			os:      "redhat",
+			version: "7.4",
+		},
+		{
+			comment: "Debian testing without version",
+			release: `ID=debian
+`,
+			os: "debian",
+		},
+	}
+	for _, testCase := range testCases {
+		os, version := ParseOSRelease([]byte(testCase.release))
+		if os != testCase.os || version != testCase.version {
+			t.Errorf("%v: expected %q %q but got %q %q", testCase.comment, testCase.os, testCase.version, os, version)
+		}
+	}
+}
+
+func TestRecordsNodeMeta(t *testing.T) {
+	meta := NodeMeta{
+		Hostname:     "node-1",
+		OS:           "centos",
+		OSVersion:    "7",
+		InstanceType: "c4.xlarge",
+		SSHUser:      "centos",
+		Labels:       map[string]string{"role": "master"},
+	}
+	node := StateNode{Addr: "10.0.0.1"}.WithMeta(meta)
+	if !reflect.DeepEqual(node.Meta(), meta) {
+		t.Errorf("expected %v but got %v", meta, node.Meta())
+	}
+	if node.Addr != "10.0.0.1" {
+		t.Errorf("expected address to be retained but got %q", node.Addr)
+	}
+}
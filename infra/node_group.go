@@ -0,0 +1,70 @@
+package infra
+
+import "github.com/gravitational/trace"
+
+// NodeGroup describes a group of nodes sharing the OS and instance type
+type NodeGroup struct {
+	// Count is the number of nodes in the group
+	Count int `json:"count"`
+	// OS defines the OS flavor of the nodes in the group
+	OS string `json:"os"`
+	// InstanceType optionally overrides the cloud-specific instance type
+	// of the nodes in the group
+	InstanceType string `json:"instance_type,omitempty"`
+	// SSHUser optionally overrides the SSH user to connect to the nodes
+	// of the group as, i.e. when the OS image comes with a preset user
+	SSHUser string `json:"ssh_user,omitempty"`
+}
+
+// ResizeNodeGroups returns a copy of groups resized to total nodes.
+// Nodes are added to the last group and removed from the last groups first,
+// so the groups of the existing nodes do not change as the cluster grows or shrinks
+func ResizeNodeGroups(groups []NodeGroup, total int) ([]NodeGroup, error) {
+	if len(groups) == 0 {
+		return nil, trace.BadParameter("at least one node group is required")
+	}
+	if total < 0 {
+		return nil, trace.BadParameter("expected a non-negative number of nodes but got %v", total)
+	}
+	resized := make([]NodeGroup, 0, len(groups))
+	remaining := total
+	for _, group := range groups {
+		if remaining == 0 {
+			break
+		}
+		if group.Count > remaining {
+			group.Count = remaining
+		}
+		remaining -= group.Count
+		resized = append(resized, group)
+	}
+	if len(resized) == 0 {
+		resized = append(resized, groups[0])
+		resized[0].Count = 0
+	}
+	resized[len(resized)-1].Count += remaining
+	return resized, nil
+}
+
+// NodeGroupAt returns the group of the node with the specified index
+// with nodes numbered consecutively across groups
+func NodeGroupAt(groups []NodeGroup, index int) (*NodeGroup, error) {
+	i, err := NodeGroupIndex(groups, index)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &groups[i], nil
+}
+
+// NodeGroupIndex returns the index of the group of the node with the specified index
+// with nodes numbered consecutively across groups
+func NodeGroupIndex(groups []NodeGroup, index int) (int, error) {
+	node := index
+	for i := range groups {
+		if index < groups[i].Count {
+			return i, nil
+		}
+		index -= groups[i].Count
+	}
+	return -1, trace.NotFound("no node group for node %v", node)
+}
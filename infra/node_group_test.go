@@ -0,0 +1,74 @@
+package infra
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResizesNodeGroups(t *testing.T) {
+	groups := []NodeGroup{
+		{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+		{Count: 2, OS: "ubuntu"},
+	}
+	var testCases = []struct {
+		comment  string
+		total    int
+		expected []NodeGroup
+	}{
+		{comment: "Same size", total: 3, expected: groups},
+		{
+			comment: "Grows last group",
+			total:   5,
+			expected: []NodeGroup{
+				{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+				{Count: 4, OS: "ubuntu"},
+			},
+		},
+		{
+			comment: "Shrinks last group",
+			total:   2,
+			expected: []NodeGroup{
+				{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+				{Count: 1, OS: "ubuntu"},
+			},
+		},
+		{
+			comment:  "Drops last group",
+			total:    1,
+			expected: []NodeGroup{{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"}},
+		},
+		{
+			comment:  "Keeps first group when empty",
+			total:    0,
+			expected: []NodeGroup{{Count: 0, OS: "centos", InstanceType: "c4.2xlarge"}},
+		},
+	}
+	for _, testCase := range testCases {
+		resized, err := ResizeNodeGroups(groups, testCase.total)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", testCase.comment, err)
+		}
+		if !reflect.DeepEqual(resized, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, resized)
+		}
+	}
+	if groups[1].Count != 2 {
+		t.Errorf("expected groups to be left intact but got %v", groups)
+	}
+}
+
+func TestFindsNodeGroupByIndex(t *testing.T) {
+	groups := []NodeGroup{{Count: 1, OS: "centos"}, {Count: 2, OS: "ubuntu"}}
+	for index, os := range []string{"centos", "ubuntu", "ubuntu"} {
+		group, err := NodeGroupAt(groups, index)
+		if err != nil {
+			t.Fatalf("unexpected error for node %v: %v", index, err)
+		}
+		if group.OS != os {
+			t.Errorf("expected node %v in %v group but got %v", index, os, group.OS)
+		}
+	}
+	if _, err := NodeGroupAt(groups, 3); err == nil {
+		t.Error("expected an error for a node outside of groups")
+	}
+}
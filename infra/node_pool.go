@@ -1,80 +1,190 @@
 package infra
 
-import "github.com/gravitational/trace"
+import (
+	"sync"
+
+	"github.com/gravitational/trace"
+)
 
 // NewNodePool creates a new instance of NodePool from specified nodes
-// and allocation state
+// and allocation state.
+// Nodes are allocated in the specified order
 func NewNodePool(nodes []Node, alloced []string) *nodePool {
 	nodeMap := make(map[string]Node, len(nodes))
+	order := make([]string, 0, len(nodes))
 	for _, node := range nodes {
+		if _, exists := nodeMap[node.Addr()]; !exists {
+			order = append(order, node.Addr())
+		}
 		nodeMap[node.Addr()] = node
 	}
 	p := &nodePool{
 		nodes:     nodeMap,
+		order:     order,
 		allocated: make(map[string]struct{}),
 	}
 	for _, alloc := range alloced {
-		p.allocated[alloc] = struct{}{}
+		if _, exists := nodeMap[alloc]; exists {
+			p.allocated[alloc] = struct{}{}
+		}
 	}
 	return p
 }
 
 // nodePool implements NodePool
 type nodePool struct {
-	nodes     map[string]Node
+	sync.Mutex
+	nodes map[string]Node
+	// order lists node addresses in the order nodes have been added
+	order     []string
 	allocated map[string]struct{}
 }
 
 func (r *nodePool) Allocate(amount int) (nodes []Node, err error) {
-	if amount+r.SizeAllocated() > r.Size() {
-		return nil, trace.NotFound("cannot allocate %v node(s): capacity exceeded (by %v)",
-			amount, amount+r.SizeAllocated()-r.Size())
-	}
-	for _, node := range r.nodes {
-		if _, exists := r.allocated[node.Addr()]; amount > 0 && !exists {
-			r.allocated[node.Addr()] = struct{}{}
-			amount = amount - 1
-			nodes = append(nodes, node)
+	return r.AllocateMatching(amount, nil)
+}
+
+func (r *nodePool) AllocateMatching(amount int, selector Selector) (nodes []Node, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var available []Node
+	for _, addr := range r.order {
+		node := r.nodes[addr]
+		if _, exists := r.allocated[addr]; !exists && selector.Matches(node.Meta().Labels) {
+			available = append(available, node)
 		}
 	}
+	if amount > len(available) {
+		if len(selector) == 0 {
+			return nil, trace.NotFound("cannot allocate %v node(s): capacity exceeded (by %v)",
+				amount, amount-len(available))
+		}
+		return nil, trace.NotFound("cannot allocate %v node(s) matching %v: capacity exceeded (by %v)",
+			amount, selector, amount-len(available))
+	}
+	for _, node := range available[:amount] {
+		r.allocated[node.Addr()] = struct{}{}
+		nodes = append(nodes, node)
+	}
 	return nodes, nil
 }
 
 func (r *nodePool) Free(nodes []Node) error {
+	r.Lock()
+	defer r.Unlock()
+
 	for _, node := range nodes {
 		if _, exists := r.allocated[node.Addr()]; !exists {
 			return trace.NotFound("cannot free unallocated node %q", node.Addr())
 		}
-
+	}
+	for _, node := range nodes {
 		delete(r.allocated, node.Addr())
 	}
 	return nil
 }
 
+func (r *nodePool) Add(nodes ...Node) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, node := range nodes {
+		if _, exists := r.nodes[node.Addr()]; !exists {
+			r.order = append(r.order, node.Addr())
+		}
+		r.nodes[node.Addr()] = node
+	}
+}
+
+func (r *nodePool) Remove(nodes ...Node) {
+	r.Lock()
+	defer r.Unlock()
+
+	removed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		removed[node.Addr()] = true
+		delete(r.nodes, node.Addr())
+		delete(r.allocated, node.Addr())
+	}
+	order := r.order[:0]
+	for _, addr := range r.order {
+		if !removed[addr] {
+			order = append(order, addr)
+		}
+	}
+	r.order = order
+}
+
+func (r *nodePool) Update(update func()) {
+	r.Lock()
+	defer r.Unlock()
+
+	nodes := make([]Node, 0, len(r.order))
+	allocated := make([]Node, 0, len(r.allocated))
+	for _, addr := range r.order {
+		nodes = append(nodes, r.nodes[addr])
+		if _, exists := r.allocated[addr]; exists {
+			allocated = append(allocated, r.nodes[addr])
+		}
+	}
+	update()
+	r.nodes = make(map[string]Node, len(nodes))
+	r.order = r.order[:0]
+	for _, node := range nodes {
+		if _, exists := r.nodes[node.Addr()]; !exists {
+			r.order = append(r.order, node.Addr())
+		}
+		r.nodes[node.Addr()] = node
+	}
+	r.allocated = make(map[string]struct{}, len(allocated))
+	for _, node := range allocated {
+		r.allocated[node.Addr()] = struct{}{}
+	}
+}
+
 func (r *nodePool) Nodes() (nodes []Node) {
-	nodes = make([]Node, 0, len(r.nodes))
-	for addr := range r.nodes {
-		node := r.nodes[addr]
-		nodes = append(nodes, node)
+	r.Lock()
+	defer r.Unlock()
+
+	nodes = make([]Node, 0, len(r.order))
+	for _, addr := range r.order {
+		nodes = append(nodes, r.nodes[addr])
 	}
 	return nodes
 }
 
 func (r *nodePool) AllocatedNodes() (nodes []Node) {
+	r.Lock()
+	defer r.Unlock()
+
 	nodes = make([]Node, 0, len(r.allocated))
-	for addr := range r.allocated {
-		node := r.nodes[addr]
-		nodes = append(nodes, node)
+	for _, addr := range r.order {
+		if _, exists := r.allocated[addr]; exists {
+			nodes = append(nodes, r.nodes[addr])
+		}
 	}
 	return nodes
 }
 
 func (r *nodePool) Node(addr string) (Node, error) {
+	r.Lock()
+	defer r.Unlock()
+
 	if node, exists := r.nodes[addr]; exists {
 		return node, nil
 	}
 	return nil, trace.NotFound("node %q not found", addr)
 }
 
-func (r *nodePool) Size() int          { return len(r.nodes) }
-func (r *nodePool) SizeAllocated() int { return len(r.allocated) }
+func (r *nodePool) Size() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.nodes)
+}
+
+func (r *nodePool) SizeAllocated() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.allocated)
+}
@@ -1,7 +1,9 @@
 package infra
 
 import (
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
@@ -103,12 +105,184 @@ func TestDoesnotFreeNonExisting(t *testing.T) {
 	}
 }
 
+func TestAllocatesInStableOrder(t *testing.T) {
+	// setup
+	var nodes []Node
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, node{addr: fmt.Sprintf("10.0.0.%v", i)})
+	}
+
+	// exercise
+	pool := NewNodePool(nodes, []string{"10.0.0.1"})
+	allocated, err := pool.Allocate(3)
+
+	// verify
+	if err != nil {
+		t.Errorf("failed to allocate nodes: %v", err)
+	}
+	expected := []Node{nodes[0], nodes[2], nodes[3]}
+	if !reflect.DeepEqual(allocated, expected) {
+		t.Errorf("expected %v but got %v", expected, allocated)
+	}
+	if !reflect.DeepEqual(pool.Nodes(), nodes) {
+		t.Errorf("expected %v but got %v", nodes, pool.Nodes())
+	}
+}
+
+func TestAllocatesBySelector(t *testing.T) {
+	// setup
+	nodes := []Node{
+		labeledNode{node{"a"}, map[string]string{"role": "master", "os": "ubuntu"}},
+		labeledNode{node{"b"}, map[string]string{"role": "node", "os": "centos"}},
+		labeledNode{node{"c"}, map[string]string{"role": "master", "os": "centos"}},
+		node{"d"},
+		labeledNode{node{"e"}, map[string]string{"role": "master", "os": "centos"}},
+	}
+	var testCases = []struct {
+		comment  string
+		amount   int
+		selector Selector
+		expected []Node
+	}{
+		{
+			comment:  "Allocates by single label",
+			amount:   2,
+			selector: Selector{"role": "master"},
+			expected: []Node{nodes[0], nodes[2]},
+		},
+		{
+			comment:  "Allocates by all labels",
+			amount:   1,
+			selector: Selector{"role": "master", "os": "centos"},
+			expected: []Node{nodes[2]},
+		},
+		{
+			comment:  "Empty selector matches unlabeled nodes",
+			amount:   4,
+			expected: []Node{nodes[0], nodes[1], nodes[2], nodes[3]},
+		},
+		{
+			comment:  "Fails if not enough nodes match",
+			amount:   2,
+			selector: Selector{"os": "ubuntu"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		// exercise
+		pool := NewNodePool(nodes, nil)
+		allocated, err := pool.AllocateMatching(testCase.amount, testCase.selector)
+
+		// verify
+		if testCase.expected == nil {
+			if !trace.IsNotFound(err) {
+				t.Errorf("%v: expected NotFound but got %v", testCase.comment, err)
+			}
+			if pool.SizeAllocated() != 0 {
+				t.Errorf("%v: expected no allocated nodes but got %v", testCase.comment, pool.SizeAllocated())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: failed to allocate nodes: %v", testCase.comment, err)
+		}
+		if !reflect.DeepEqual(allocated, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, allocated)
+		}
+	}
+}
+
+func TestAllocatesConcurrently(t *testing.T) {
+	// setup
+	var nodes []Node
+	for i := 0; i < 100; i++ {
+		nodes = append(nodes, node{addr: fmt.Sprintf("10.0.0.%v", i)})
+	}
+	pool := NewNodePool(nodes, nil)
+
+	// exercise
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allocated, err := pool.Allocate(2)
+			if err != nil {
+				t.Errorf("failed to allocate nodes: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, node := range allocated {
+				seen[node.Addr()]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	// verify
+	if len(seen) != len(nodes) {
+		t.Errorf("expected %v distinct nodes allocated but got %v", len(nodes), len(seen))
+	}
+	for addr, count := range seen {
+		if count != 1 {
+			t.Errorf("node %v allocated %v times", addr, count)
+		}
+	}
+}
+
+func TestUpdatesInPlace(t *testing.T) {
+	// setup
+	a, b, c := &node{addr: "a"}, &node{addr: "b"}, &node{addr: "c"}
+	pool := NewNodePool([]Node{a, b}, nil)
+	allocated, err := pool.Allocate(1)
+	if err != nil {
+		t.Fatalf("failed to allocate node: %v", err)
+	}
+
+	// exercise
+	pool.Add(c)
+	pool.Update(func() { a.addr, b.addr = "b", "a" })
+
+	// verify
+	if !reflect.DeepEqual(pool.Nodes(), []Node{a, b, c}) {
+		t.Errorf("expected nodes in the order of addition but got %v", pool.Nodes())
+	}
+	if !reflect.DeepEqual(pool.AllocatedNodes(), allocated) {
+		t.Errorf("expected allocation %v to be preserved but got %v", allocated, pool.AllocatedNodes())
+	}
+	if node, err := pool.Node("b"); err != nil || node != a {
+		t.Errorf("expected node a under its new address but got %v (%v)", node, err)
+	}
+
+	// exercise
+	pool.Remove(a)
+
+	// verify
+	if pool.Size() != 2 || pool.SizeAllocated() != 0 {
+		t.Errorf("expected 2 unallocated nodes but got %v with %v allocated", pool.Size(), pool.SizeAllocated())
+	}
+	if _, err := pool.Allocate(2); err != nil {
+		t.Errorf("failed to allocate remaining nodes: %v", err)
+	}
+}
+
+type labeledNode struct {
+	node
+	labels map[string]string
+}
+
+func (r labeledNode) Meta() NodeMeta { return NodeMeta{Labels: r.labels} }
+
 type node struct {
 	addr string
 }
 
 func (r node) Addr() string        { return r.addr }
 func (r node) PrivateAddr() string { return r.addr }
+func (r node) Meta() NodeMeta      { return NodeMeta{} }
 func (r node) Client() (*ssh.Client, error) {
 	return nil, trace.BadParameter("not implemented")
 }
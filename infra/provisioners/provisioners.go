@@ -0,0 +1,11 @@
+// Package provisioners registers all built-in provisioners with package infra.
+// Import it for side effects to resolve provisioners by name with
+// infra.NewProvisioner and infra.NewProvisionerFromState
+package provisioners
+
+import (
+	_ "github.com/gravitational/robotest/infra/docker"
+	_ "github.com/gravitational/robotest/infra/inventory"
+	_ "github.com/gravitational/robotest/infra/terraform"
+	_ "github.com/gravitational/robotest/infra/vagrant"
+)
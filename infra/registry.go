@@ -0,0 +1,129 @@
+package infra
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gravitational/trace"
+)
+
+// ProvisionerConfig defines the provisioner-agnostic configuration
+// used to create registered provisioners.
+// Each provisioner uses the subset of parameters relevant to it
+type ProvisionerConfig struct {
+	Config
+	// ScriptPath is the path to the provisioner script.
+	// The meaning is provisioner-specific: terraform script directory,
+	// Vagrantfile, inventory file, etc.
+	ScriptPath string `json:"script_path"`
+	// InstallerURL is the installer tarball location
+	InstallerURL string `json:"installer_url"`
+	// NumNodes defines the capacity of the cluster to provision
+	NumNodes int `json:"nodes"`
+	// OS defines the OS flavor of the nodes
+	OS string `json:"os"`
+	// NodeGroups optionally splits the nodes into groups with own OS
+	// and instance type. Only supported by cloud provisioners
+	NodeGroups []NodeGroup `json:"node_groups,omitempty"`
+	// CloudProvider defines the cloud to deploy to
+	CloudProvider string `json:"cloud_provider"`
+	// AWS defines AWS connection parameters
+	AWS *AWSConfig `json:"aws,omitempty"`
+	// Azure defines Azure connection parameters
+	Azure *AzureConfig `json:"azure,omitempty"`
+	// GCP defines Google Compute Engine connection parameters
+	GCP *GCPConfig `json:"gcp,omitempty"`
+	// JumpHost optionally defines the SSH bastion host to connect to nodes through.
+	// Provisioners of remote nodes then connect to nodes at their private addresses
+	JumpHost *JumpHostConfig `json:"jump_host,omitempty"`
+	// SSHAuth optionally configures additional methods to authenticate SSH connections
+	// to nodes with. Only used by provisioners of nodes with user-supplied SSH keys
+	SSHAuth *SSHAuthConfig `json:"ssh_auth,omitempty"`
+	// VerifyHostKeys enables SSH host key verification.
+	// Host keys are recorded on first connect in the known_hosts file in the state directory
+	VerifyHostKeys bool `json:"verify_host_keys,omitempty"`
+}
+
+// NewProvisionerFunc creates a new provisioner that keeps its state in stateDir
+type NewProvisionerFunc func(stateDir string, config ProvisionerConfig) (Provisioner, error)
+
+// NewProvisionerFromStateFunc recreates a provisioner from previously saved state
+type NewProvisionerFromStateFunc func(config ProvisionerConfig, state ProvisionerState) (Provisioner, error)
+
+// ProvisionerFactory creates instances of a specific provisioner
+type ProvisionerFactory struct {
+	// New creates a new provisioner
+	New NewProvisionerFunc
+	// NewFromState recreates a provisioner from state
+	NewFromState NewProvisionerFromStateFunc
+}
+
+// RegisterProvisioner makes the provisioner available under the specified name.
+// It is meant to be called from the init function of the provisioner package
+// and panics if the name is already taken
+func RegisterProvisioner(name string, factory ProvisionerFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if factory.New == nil || factory.NewFromState == nil {
+		panic(fmt.Sprintf("incomplete factory for provisioner %q", name))
+	}
+	if _, exists := registry.factories[name]; exists {
+		panic(fmt.Sprintf("provisioner %q already registered", name))
+	}
+	registry.factories[name] = factory
+}
+
+// NewProvisioner creates a new instance of the provisioner registered under name
+func NewProvisioner(name, stateDir string, config ProvisionerConfig) (Provisioner, error) {
+	factory, err := getFactory(name)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	provisioner, err := factory.New(stateDir, config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return provisioner, nil
+}
+
+// NewProvisionerFromState recreates the provisioner registered under name
+// from the specified state
+func NewProvisionerFromState(name string, config ProvisionerConfig, state ProvisionerState) (Provisioner, error) {
+	factory, err := getFactory(name)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	provisioner, err := factory.NewFromState(config, state)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return provisioner, nil
+}
+
+// RegisteredProvisioners returns the sorted names of all registered provisioners
+func RegisteredProvisioners() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getFactory(name string) (*ProvisionerFactory, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	factory, ok := registry.factories[name]
+	if !ok {
+		return nil, trace.BadParameter("unknown provisioner %q", name)
+	}
+	return &factory, nil
+}
+
+var registry = struct {
+	sync.RWMutex
+	factories map[string]ProvisionerFactory
+}{factories: map[string]ProvisionerFactory{}}
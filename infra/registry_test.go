@@ -0,0 +1,40 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestResolvesRegisteredProvisioner(t *testing.T) {
+	// setup
+	var created, restored bool
+	RegisterProvisioner("test", ProvisionerFactory{
+		New: func(stateDir string, config ProvisionerConfig) (Provisioner, error) {
+			created = true
+			return nil, nil
+		},
+		NewFromState: func(config ProvisionerConfig, state ProvisionerState) (Provisioner, error) {
+			restored = true
+			return nil, nil
+		},
+	})
+
+	// exercise
+	_, err := NewProvisioner("test", "/tmp", ProvisionerConfig{})
+	if err != nil {
+		t.Errorf("failed to create provisioner: %v", err)
+	}
+	_, err = NewProvisionerFromState("test", ProvisionerConfig{}, ProvisionerState{})
+	if err != nil {
+		t.Errorf("failed to restore provisioner: %v", err)
+	}
+
+	// verify
+	if !created || !restored {
+		t.Errorf("expected factory to be used: created=%v, restored=%v", created, restored)
+	}
+	if _, err = NewProvisioner("unknown", "/tmp", ProvisionerConfig{}); !trace.IsBadParameter(err) {
+		t.Errorf("expected bad parameter error for unknown provisioner but got %v", err)
+	}
+}
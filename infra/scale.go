@@ -0,0 +1,26 @@
+package infra
+
+import (
+	"github.com/gravitational/trace"
+)
+
+// CheckTrailing verifies that the removed values are the last values of all values
+// in any order.
+// Scaler implementations use it to check that only the most recently added nodes are removed
+func CheckTrailing(values, removed []string) error {
+	if len(removed) > len(values) {
+		return trace.BadParameter("cannot remove %v of %v nodes", len(removed), len(values))
+	}
+	trailing := make(map[string]bool, len(removed))
+	for _, value := range values[len(values)-len(removed):] {
+		trailing[value] = true
+	}
+	for _, value := range removed {
+		if !trailing[value] {
+			return trace.BadParameter("node %v is not among the %v most recently added nodes %q",
+				value, len(removed), values[len(values)-len(removed):])
+		}
+		delete(trailing, value)
+	}
+	return nil
+}
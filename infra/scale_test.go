@@ -0,0 +1,29 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestRemovesOnlyTrailingNodes(t *testing.T) {
+	var testCases = []struct {
+		comment string
+		removed []string
+		check   func(error) bool
+	}{
+		{comment: "Last node", removed: []string{"10.0.0.3"}, check: isNil},
+		{comment: "Last nodes in any order", removed: []string{"10.0.0.3", "10.0.0.2"}, check: isNil},
+		{comment: "Node in the middle", removed: []string{"10.0.0.2"}, check: trace.IsBadParameter},
+		{comment: "Same node twice", removed: []string{"10.0.0.3", "10.0.0.3"}, check: trace.IsBadParameter},
+		{comment: "Too many nodes", removed: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, check: trace.IsBadParameter},
+	}
+	for _, testCase := range testCases {
+		err := CheckTrailing([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, testCase.removed)
+		if !testCase.check(err) {
+			t.Errorf("%v: unexpected result: %v", testCase.comment, err)
+		}
+	}
+}
+
+func isNil(err error) bool { return err == nil }
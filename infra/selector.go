@@ -0,0 +1,85 @@
+package infra
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// Labels provisioners attach to nodes, so that nodes can be selected by their metadata
+const (
+	// LabelOS is the label with the OS distribution of the node, see NodeMeta.OS
+	LabelOS = "os"
+	// LabelInstanceType is the label with the instance type of the node, see NodeMeta.InstanceType
+	LabelInstanceType = "instance-type"
+	// LabelNodeGroup is the label with the index of the node group of the node, see NodeGroup
+	LabelNodeGroup = "node-group"
+)
+
+// WithLabels returns a copy of the metadata with the OS, the instance type and
+// the specified node group added to the labels.
+// Labels already attached to the node take precedence and empty values are skipped
+func (r NodeMeta) WithLabels(group string) NodeMeta {
+	labels := make(map[string]string, len(r.Labels)+3)
+	for key, value := range map[string]string{LabelOS: r.OS, LabelInstanceType: r.InstanceType, LabelNodeGroup: group} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	for key, value := range r.Labels {
+		labels[key] = value
+	}
+	if len(labels) != 0 {
+		r.Labels = labels
+	}
+	return r
+}
+
+// Selector selects nodes by labels.
+// A node matches the selector if it has all labels of the selector with the same values.
+// An empty selector matches any node
+type Selector map[string]string
+
+// ParseSelector parses a selector from the comma-separated list of
+// key=value pairs, i.e. "role=master,os=centos"
+func ParseSelector(s string) (Selector, error) {
+	selector := make(Selector)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, trace.BadParameter("invalid selector %q: expected key=value but got %q", s, pair)
+		}
+		if _, exists := selector[key]; exists {
+			return nil, trace.BadParameter("invalid selector %q: duplicate key %q", s, key)
+		}
+		selector[key] = strings.TrimSpace(parts[1])
+	}
+	return selector, nil
+}
+
+// Matches returns true if the specified labels match this selector
+func (r Selector) Matches(labels map[string]string) bool {
+	for key, value := range r {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the selector as a sorted comma-separated list of key=value pairs
+func (r Selector) String() string {
+	pairs := make([]string, 0, len(r))
+	for key, value := range r {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
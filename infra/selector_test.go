@@ -0,0 +1,63 @@
+package infra
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestParsesSelector(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		input    string
+		expected Selector
+		check    func(error) bool
+	}{
+		{comment: "Empty selector", input: "", expected: Selector{}},
+		{comment: "Single label", input: "role=master", expected: Selector{"role": "master"}},
+		{
+			comment:  "Multiple labels with spaces",
+			input:    "role=master, os = centos",
+			expected: Selector{"role": "master", "os": "centos"},
+		},
+		{comment: "Empty value", input: "role=", expected: Selector{"role": ""}},
+		{comment: "Missing value", input: "role", check: trace.IsBadParameter},
+		{comment: "Missing key", input: "=master", check: trace.IsBadParameter},
+		{comment: "Duplicate key", input: "role=master,role=node", check: trace.IsBadParameter},
+	}
+	for _, testCase := range testCases {
+		selector, err := ParseSelector(testCase.input)
+		if testCase.check != nil {
+			if !testCase.check(err) {
+				t.Errorf("%v: unexpected error: %v", testCase.comment, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: failed to parse selector: %v", testCase.comment, err)
+		}
+		if !reflect.DeepEqual(selector, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, selector)
+		}
+	}
+}
+
+func TestFormatsSelector(t *testing.T) {
+	selector := Selector{"role": "master", "os": "centos"}
+	if selector.String() != "os=centos,role=master" {
+		t.Errorf("unexpected selector %q", selector.String())
+	}
+}
+
+func TestLabelsNodeMeta(t *testing.T) {
+	meta := NodeMeta{OS: "centos", Labels: map[string]string{"role": "master", "os": "redhat"}}
+	labeled := meta.WithLabels("1")
+	expected := map[string]string{"role": "master", "os": "redhat", "node-group": "1"}
+	if !reflect.DeepEqual(labeled.Labels, expected) {
+		t.Errorf("expected %v but got %v", expected, labeled.Labels)
+	}
+	if len(meta.Labels) != 2 {
+		t.Errorf("expected labels of the original metadata to be retained but got %v", meta.Labels)
+	}
+}
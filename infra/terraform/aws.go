@@ -0,0 +1,113 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/system"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type AWSParam struct {
+	AccessKey, SecretKey, Region string
+}
+
+// AWSInstanceState returns the state of the EC2 instance, i.e. "running" or "stopped"
+func AWSInstanceState(ctx context.Context, param AWSParam, instanceID string) (string, error) {
+	out, err := awsCLI(ctx, param, "ec2", "describe-instances", "--instance-ids", instanceID,
+		"--query", "Reservations[0].Instances[0].State.Name", "--output", "text")
+	if err != nil {
+		return "", trace.Wrap(err, "failed to query state of instance %v: %s", instanceID, out)
+	}
+	return parseAWSText(out), nil
+}
+
+// AWSInstancePublicIP returns the public IP address of the EC2 instance.
+// Returns NotFound if the instance has no public address, i.e. it is stopped
+func AWSInstancePublicIP(ctx context.Context, param AWSParam, instanceID string) (string, error) {
+	out, err := awsCLI(ctx, param, "ec2", "describe-instances", "--instance-ids", instanceID,
+		"--query", "Reservations[0].Instances[0].PublicIpAddress", "--output", "text")
+	if err != nil {
+		return "", trace.Wrap(err, "failed to query public IP of instance %v: %s", instanceID, out)
+	}
+	addr := parseAWSText(out)
+	if addr == "" {
+		return "", trace.NotFound("instance %v has no public IP", instanceID)
+	}
+	return addr, nil
+}
+
+// AWSStopInstance stops the EC2 instance and waits until it is stopped.
+// With force, the instance is stopped without a graceful OS shutdown
+func AWSStopInstance(ctx context.Context, param AWSParam, instanceID string, force bool) error {
+	args := []string{"ec2", "stop-instances", "--instance-ids", instanceID}
+	if force {
+		args = append(args, "--force")
+	}
+	out, err := awsCLI(ctx, param, args...)
+	if err != nil {
+		return trace.Wrap(err, "failed to stop instance %v: %s", instanceID, out)
+	}
+	out, err = awsCLI(ctx, param, "ec2", "wait", "instance-stopped", "--instance-ids", instanceID)
+	if err != nil {
+		return trace.Wrap(err, "instance %v has not stopped: %s", instanceID, out)
+	}
+	return nil
+}
+
+// AWSStartInstance starts the EC2 instance and waits until it is running.
+// An instance in the process of stopping is started once it has stopped
+func AWSStartInstance(ctx context.Context, param AWSParam, instanceID string) error {
+	state, err := AWSInstanceState(ctx, param, instanceID)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	switch state {
+	case "running":
+		return nil
+	case "stopping":
+		out, err := awsCLI(ctx, param, "ec2", "wait", "instance-stopped", "--instance-ids", instanceID)
+		if err != nil {
+			return trace.Wrap(err, "instance %v has not stopped: %s", instanceID, out)
+		}
+	}
+	out, err := awsCLI(ctx, param, "ec2", "start-instances", "--instance-ids", instanceID)
+	if err != nil {
+		return trace.Wrap(err, "failed to start instance %v: %s", instanceID, out)
+	}
+	out, err = awsCLI(ctx, param, "ec2", "wait", "instance-running", "--instance-ids", instanceID)
+	if err != nil {
+		return trace.Wrap(err, "instance %v has not started: %s", instanceID, out)
+	}
+	return nil
+}
+
+// parseAWSText interprets a single value queried with `--output text`.
+// The value is the last word of the output as warnings go to the same output.
+// The CLI reports missing values as "None"
+func parseAWSText(data []byte) string {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || fields[len(fields)-1] == "None" {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+func awsCLI(ctx context.Context, param AWSParam, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "aws", args...)
+	var out bytes.Buffer
+	err := system.ExecL(cmd, &out, log.WithField("cmd", "aws"), system.SetEnv(
+		fmt.Sprintf("AWS_ACCESS_KEY_ID=%v", param.AccessKey),
+		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%v", param.SecretKey),
+		fmt.Sprintf("AWS_DEFAULT_REGION=%v", param.Region),
+	))
+	if err != nil {
+		return out.Bytes(), trace.Wrap(err, "command %q failed (args %q)", cmd.Path, cmd.Args)
+	}
+	return out.Bytes(), nil
+}
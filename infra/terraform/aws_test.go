@@ -0,0 +1,26 @@
+package terraform
+
+import "testing"
+
+func TestParsesAWSText(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		output   string
+		expected string
+	}{
+		{comment: "Single value", output: "52.1.2.3\n", expected: "52.1.2.3"},
+		{comment: "Missing value", output: "None\n", expected: ""},
+		{comment: "Empty output", output: "", expected: ""},
+		{
+			comment:  "Value follows warnings",
+			output:   "urllib3/connectionpool.py: InsecureRequestWarning\nstopped\n",
+			expected: "stopped",
+		},
+	}
+	for _, testCase := range testCases {
+		obtained := parseAWSText([]byte(testCase.output))
+		if obtained != testCase.expected {
+			t.Errorf("%v: expected %q but got %q", testCase.comment, testCase.expected, obtained)
+		}
+	}
+}
@@ -7,8 +7,13 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type AzureAuthParam struct {
@@ -22,7 +27,17 @@ type AzureToken struct {
 
 const (
 	azureTokenUrl      = "https://login.microsoftonline.com/%s/oauth2/token"
-	azureManagementUrl = "https://management.azure.com/subscriptions/%s/resourcegroups/%s?api-version=2016-09-01"
+	azureManagementUrl = "%s/subscriptions/%s/resourcegroups/%s?api-version=2016-09-01"
+	azureResourcesUrl  = "%s/subscriptions/%s/resources?$expand=createdTime&api-version=2019-10-01"
+	azureVMActionUrl   = "%s%s/%s?%s"
+)
+
+var (
+	// azureManagementEndpoint is the base URL of the Azure Resource Manager API
+	azureManagementEndpoint = "https://management.azure.com"
+	// azurePollInterval defines how often to poll the status of an asynchronous
+	// operation unless the API specifies otherwise with Retry-After
+	azurePollInterval = 10 * time.Second
 )
 
 // GetAuthToken retrieves OAuth token for an application
@@ -52,7 +67,9 @@ func AzureGetAuthToken(ctx context.Context, param AzureAuthParam) (*AzureToken,
 	return &token, nil
 }
 
-// RemoveResourceGroup submits resource group deletion request to Azure
+// AzureRemoveResourceGroup submits resource group deletion request to Azure
+// and waits for the deletion to complete.
+// Returns NotFound if there's no such resource group
 func AzureRemoveResourceGroup(ctx context.Context, token *AzureToken, subscription, group string) error {
 	if subscription == "" || group == "" {
 		return trace.BadParameter("subscription=%s, group=%v", subscription, group)
@@ -60,7 +77,7 @@ func AzureRemoveResourceGroup(ctx context.Context, token *AzureToken, subscripti
 
 	client := &http.Client{}
 
-	reqUrl := fmt.Sprintf(azureManagementUrl, subscription, group)
+	reqUrl := fmt.Sprintf(azureManagementUrl, azureManagementEndpoint, subscription, group)
 	req, err := http.NewRequest("DELETE", reqUrl, nil)
 	if err != nil {
 		return trace.Wrap(err, `[DELETE %s]=%v`, reqUrl, err)
@@ -73,8 +90,249 @@ func AzureRemoveResourceGroup(ctx context.Context, token *AzureToken, subscripti
 		return trace.Wrap(err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusAccepted {
-		return trace.Errorf("%v/%s [DELETE %s]", resp.StatusCode, resp.Status, reqUrl)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return trace.NotFound("resource group %v not found", group)
+	case http.StatusAccepted:
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return trace.Errorf("%v/%s [DELETE %s]: %s", resp.StatusCode, resp.Status, reqUrl, body)
+	}
+
+	err = azureWaitForOperation(ctx, client, token, resp)
+	if err != nil {
+		return trace.Wrap(err, "resource group %v", group)
 	}
+	log.Infof("resource group %v removed", group)
 	return nil
 }
+
+const (
+	// AzureVMPowerOff powers off the virtual machine without releasing its resources
+	AzureVMPowerOff = "powerOff"
+	// AzureVMStart starts the virtual machine
+	AzureVMStart = "start"
+	// AzureVMRestart restarts the virtual machine
+	AzureVMRestart = "restart"
+)
+
+// AzureVMAction performs the power action (i.e. AzureVMPowerOff) on the virtual
+// machine with the specified resource ID and waits for the action to complete.
+// With force, the machine is powered off without a graceful OS shutdown.
+// Returns NotFound if there's no such virtual machine
+func AzureVMAction(ctx context.Context, token *AzureToken, vmID, action string, force bool) error {
+	if vmID == "" || action == "" {
+		return trace.BadParameter("vm=%s, action=%s", vmID, action)
+	}
+
+	query := url.Values{"api-version": {"2019-03-01"}}
+	if force && action == AzureVMPowerOff {
+		query.Set("skipShutdown", "true")
+	}
+	client := &http.Client{}
+
+	reqUrl := fmt.Sprintf(azureVMActionUrl, azureManagementEndpoint, vmID, action, query.Encode())
+	req, err := http.NewRequest("POST", reqUrl, nil)
+	if err != nil {
+		return trace.Wrap(err, `[POST %s]=%v`, reqUrl, err)
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Add("Authorization", fmt.Sprintf("%s %s", token.Type, token.Token))
+	resp, err := client.Do(req)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return trace.NotFound("virtual machine %v not found", vmID)
+	case http.StatusAccepted:
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return trace.Errorf("%v/%s [POST %s]: %s", resp.StatusCode, resp.Status, reqUrl, body)
+	}
+
+	err = azureWaitForOperation(ctx, client, token, resp)
+	return trace.Wrap(err, "%v virtual machine %v", action, vmID)
+}
+
+// azureWaitForOperation follows the asynchronous operation started with the
+// specified response until it completes, fails or the context expires.
+// See https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-manager-async-operations
+func azureWaitForOperation(ctx context.Context, client *http.Client, token *AzureToken, resp *http.Response) error {
+	// Azure-AsyncOperation reports the operation status explicitly
+	// and takes precedence over Location
+	statusUrl := resp.Header.Get("Azure-AsyncOperation")
+	hasStatus := statusUrl != ""
+	if !hasStatus {
+		statusUrl = resp.Header.Get("Location")
+	}
+	if statusUrl == "" {
+		return trace.BadParameter("no operation status URL in %v response", resp.Status)
+	}
+
+	delay := azureRetryAfter(resp)
+	for {
+		select {
+		case <-ctx.Done():
+			return trace.LimitExceeded("operation has not completed in time: %v", ctx.Err())
+		case <-time.After(delay):
+		}
+
+		req, err := http.NewRequest("GET", statusUrl, nil)
+		if err != nil {
+			return trace.Wrap(err, `[GET %s]=%v`, statusUrl, err)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Add("Authorization", fmt.Sprintf("%s %s", token.Type, token.Token))
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return trace.LimitExceeded("operation has not completed in time: %v", ctx.Err())
+			}
+			return trace.Wrap(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return trace.Wrap(err, "[read response from GET %s]=%v", statusUrl, err)
+		}
+		delay = azureRetryAfter(resp)
+
+		if hasStatus {
+			done, err := azureOperationStatus(resp.StatusCode, body)
+			if done || err != nil {
+				return trace.Wrap(err)
+			}
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusAccepted:
+			// in progress
+		case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+			return nil
+		default:
+			return trace.Errorf("operation failed: %v/%s: %s", resp.StatusCode, resp.Status, body)
+		}
+	}
+}
+
+// azureOperationStatus interprets the response of the Azure-AsyncOperation status URL
+func azureOperationStatus(statusCode int, body []byte) (done bool, err error) {
+	if statusCode != http.StatusOK {
+		return false, trace.Errorf("operation status query failed: %v: %s", statusCode, body)
+	}
+	var status azureOperation
+	if err := json.Unmarshal(body, &status); err != nil {
+		return false, trace.Wrap(err, "%v : data=%q", err, body)
+	}
+	switch status.Status {
+	case "InProgress":
+		return false, nil
+	case "Succeeded":
+		return true, nil
+	case "Failed", "Canceled":
+		if status.Error != nil {
+			return true, trace.Errorf("operation %v: %v: %v", status.Status, status.Error.Code, status.Error.Message)
+		}
+		return true, trace.Errorf("operation %v", status.Status)
+	default:
+		return false, trace.BadParameter("unknown operation status %q", status.Status)
+	}
+}
+
+// azureRetryAfter returns the delay before the next status query
+func azureRetryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return azurePollInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+type azureOperation struct {
+	// Status is one of InProgress, Succeeded, Failed or Canceled
+	Status string `json:"status"`
+	Error  *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// AzureResourceGroupsCreated returns the creation time of the oldest resource
+// of every resource group in the subscription.
+// Resource group names are lower case. Empty resource groups are not reported
+func AzureResourceGroupsCreated(ctx context.Context, token *AzureToken, subscription string) (map[string]time.Time, error) {
+	if subscription == "" {
+		return nil, trace.BadParameter("subscription=%s", subscription)
+	}
+
+	client := &http.Client{}
+	groups := make(map[string]time.Time)
+	reqUrl := fmt.Sprintf(azureResourcesUrl, azureManagementEndpoint, subscription)
+	for reqUrl != "" {
+		req, err := http.NewRequest("GET", reqUrl, nil)
+		if err != nil {
+			return nil, trace.Wrap(err, `[GET %s]=%v`, reqUrl, err)
+		}
+
+		req = req.WithContext(ctx)
+		req.Header.Add("Authorization", fmt.Sprintf("%s %s", token.Type, token.Token))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, trace.Wrap(err, "[read response from GET %s]=%v", reqUrl, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, trace.Errorf("%v/%s [GET %s]: %s", resp.StatusCode, resp.Status, reqUrl, body)
+		}
+
+		var page azureResourceList
+		if err = json.Unmarshal(body, &page); err != nil {
+			return nil, trace.Wrap(err, "%v : data=%q", err, body)
+		}
+		for _, resource := range page.Value {
+			group := azureResourceGroup(resource.ID)
+			if group == "" {
+				continue
+			}
+			created, ok := groups[group]
+			if !ok || resource.CreatedTime.Before(created) {
+				groups[group] = resource.CreatedTime
+			}
+		}
+		reqUrl = page.NextLink
+	}
+	return groups, nil
+}
+
+// azureResourceGroup returns the lower case name of the resource group
+// from the specified resource ID:
+// /subscriptions/<subscription>/resourceGroups/<group>/providers/...
+func azureResourceGroup(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return strings.ToLower(parts[i+1])
+		}
+	}
+	return ""
+}
+
+type azureResourceList struct {
+	Value []struct {
+		ID          string    `json:"id"`
+		CreatedTime time.Time `json:"createdTime"`
+	} `json:"value"`
+	NextLink string `json:"nextLink"`
+}
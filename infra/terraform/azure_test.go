@@ -3,8 +3,14 @@ package terraform
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gravitational/trace"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,3 +38,179 @@ func TestAzureGroupRemoval(t *testing.T) {
 	err = AzureRemoveResourceGroup(ctx, token, *azSubscription, *azRemoveGroup)
 	require.NoError(t, err, "remove group")
 }
+
+func TestAzureWaitsForGroupRemoval(t *testing.T) {
+	var testCases = []struct {
+		comment string
+		// statuses lists the responses of the operation status endpoint in order
+		statuses []azureStatusResponse
+		// location uses the Location header instead of Azure-AsyncOperation
+		location bool
+		timeout  time.Duration
+		check    func(error) bool
+	}{
+		{
+			comment: "Async operation succeeds",
+			statuses: []azureStatusResponse{
+				{code: http.StatusOK, body: `{"status": "InProgress"}`},
+				{code: http.StatusOK, body: `{"status": "Succeeded"}`},
+			},
+			check: isNil,
+		},
+		{
+			comment: "Async operation fails",
+			statuses: []azureStatusResponse{
+				{code: http.StatusOK, body: `{"status": "InProgress"}`},
+				{code: http.StatusOK, body: `{"status": "Failed", "error": {"code": "Conflict", "message": "locked"}}`},
+			},
+			check: isNotNil,
+		},
+		{
+			comment:  "Location completes",
+			location: true,
+			statuses: []azureStatusResponse{
+				{code: http.StatusAccepted},
+				{code: http.StatusOK},
+			},
+			check: isNil,
+		},
+		{
+			comment:  "Location fails",
+			location: true,
+			statuses: []azureStatusResponse{
+				{code: http.StatusAccepted},
+				{code: http.StatusConflict, body: `{"error": {"code": "Conflict"}}`},
+			},
+			check: isNotNil,
+		},
+		{
+			comment: "Operation does not complete in time",
+			statuses: []azureStatusResponse{
+				{code: http.StatusOK, body: `{"status": "InProgress"}`},
+			},
+			timeout: 50 * time.Millisecond,
+			check:   trace.IsLimitExceeded,
+		},
+	}
+
+	defer func(endpoint string, interval time.Duration) {
+		azureManagementEndpoint = endpoint
+		azurePollInterval = interval
+	}(azureManagementEndpoint, azurePollInterval)
+	azurePollInterval = time.Millisecond
+
+	for _, testCase := range testCases {
+		server := newAzureStandIn(testCase.statuses, testCase.location)
+		azureManagementEndpoint = server.URL
+
+		ctx := context.Background()
+		if testCase.timeout != 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, testCase.timeout)
+			defer cancel()
+		}
+		err := AzureRemoveResourceGroup(ctx, &AzureToken{Type: "Bearer", Token: "token"}, "subscription", "group")
+		server.Close()
+		if !testCase.check(err) {
+			t.Errorf("%v: unexpected result: %v", testCase.comment, err)
+		}
+	}
+}
+
+func TestAzureRemovesMissingGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	defer func(endpoint string) { azureManagementEndpoint = endpoint }(azureManagementEndpoint)
+	azureManagementEndpoint = server.URL
+
+	err := AzureRemoveResourceGroup(context.TODO(), &AzureToken{}, "subscription", "group")
+	require.True(t, trace.IsNotFound(err), "expected NotFound but got %v", err)
+}
+
+func TestAzureWaitsForVMAction(t *testing.T) {
+	const vmID = "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/node-0"
+	var testCases = []struct {
+		comment      string
+		action       string
+		force        bool
+		skipShutdown string
+	}{
+		{comment: "Graceful power off", action: AzureVMPowerOff},
+		{comment: "Forced power off", action: AzureVMPowerOff, force: true, skipShutdown: "true"},
+		{comment: "Start ignores force", action: AzureVMStart, force: true},
+	}
+
+	defer func(endpoint string, interval time.Duration) {
+		azureManagementEndpoint = endpoint
+		azurePollInterval = interval
+	}(azureManagementEndpoint, azurePollInterval)
+	azurePollInterval = time.Millisecond
+
+	for _, testCase := range testCases {
+		var skipShutdown string
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		mux.HandleFunc(vmID+"/"+testCase.action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Query().Get("api-version") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			skipShutdown = r.URL.Query().Get("skipShutdown")
+			w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("%v/operation", server.URL))
+			w.WriteHeader(http.StatusAccepted)
+		})
+		mux.HandleFunc("/operation", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status": "Succeeded"}`)
+		})
+		azureManagementEndpoint = server.URL
+
+		err := AzureVMAction(context.TODO(), &AzureToken{}, vmID, testCase.action, testCase.force)
+		server.Close()
+		require.NoError(t, err, testCase.comment)
+		require.Equal(t, testCase.skipShutdown, skipShutdown, testCase.comment)
+	}
+}
+
+type azureStatusResponse struct {
+	code int
+	body string
+}
+
+// newAzureStandIn returns a server that accepts resource group deletion
+// and then replies to status queries with the specified responses.
+// The last response is repeated
+func newAzureStandIn(statuses []azureStatusResponse, location bool) *httptest.Server {
+	var mu sync.Mutex
+	var queries int
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/subscriptions/subscription/resourcegroups/group", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header := "Azure-AsyncOperation"
+		if location {
+			header = "Location"
+		}
+		w.Header().Set(header, fmt.Sprintf("%v/operation", server.URL))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/operation", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		status := statuses[len(statuses)-1]
+		if queries < len(statuses) {
+			status = statuses[queries]
+		}
+		queries++
+		w.WriteHeader(status.code)
+		fmt.Fprint(w, status.body)
+	})
+	return server
+}
+
+func isNil(err error) bool    { return err == nil }
+func isNotNil(err error) bool { return err != nil }
@@ -21,8 +21,9 @@ func (c *Config) Validate() error {
 
 	hasValidAWSSSH := c.CloudProvider == "aws" && c.AWS != nil && c.AWS.SSHUser != "" && c.AWS.SSHKeyPath != ""
 	hasValidAzureSSH := c.CloudProvider == "azure" && c.Azure != nil && c.Azure.SSHUser != "" && c.Azure.SSHKeyPath != ""
+	hasValidGCPSSH := c.CloudProvider == "gcp" && c.GCP != nil && c.GCP.SSHUser != "" && c.GCP.SSHKeyPath != ""
 
-	if (hasValidAWSSSH || hasValidAzureSSH) == false {
+	if (hasValidAWSSSH || hasValidAzureSSH || hasValidGCPSSH) == false {
 		errors = append(errors,
 			trace.Errorf("SSH configuration missing for %s", c.CloudProvider))
 	}
@@ -36,20 +37,51 @@ func (c Config) SSHConfig() (user, keypath string) {
 		return c.AWS.SSHUser, c.AWS.SSHKeyPath
 	case "azure":
 		return c.Azure.SSHUser, c.Azure.SSHKeyPath
+	case "gcp":
+		return c.GCP.SSHUser, c.GCP.SSHKeyPath
 	default:
 		return "", ""
 	}
 }
 
+// nodeGroups returns the node groups resized to the configured number of nodes
+func (c Config) nodeGroups() ([]infra.NodeGroup, error) {
+	groups := c.NodeGroups
+	if len(groups) == 0 {
+		groups = []infra.NodeGroup{{Count: c.NumNodes, OS: c.OS}}
+	}
+	return infra.ResizeNodeGroups(groups, c.NumNodes)
+}
+
+// withDefaults returns a copy of the configuration with defaults applied
+func (c Config) withDefaults() Config {
+	if c.GCP != nil && c.GCP.ClusterName == "" {
+		// resources are named and labeled after the cluster
+		gcp := *c.GCP
+		gcp.ClusterName = c.ClusterName
+		c.GCP = &gcp
+	}
+	return c
+}
+
 type Config struct {
 	infra.Config
 
 	// DeployTo defines cloud to deploy to
-	CloudProvider string `validate:"required,eq=aws|eq=azure"`
+	CloudProvider string `validate:"required,eq=aws|eq=azure|eq=gcp"`
 	// AWS defines AWS connection parameters
 	AWS *infra.AWSConfig
 	// Azure defines Azure connection parameters
 	Azure *infra.AzureConfig
+	// GCP defines Google Compute Engine connection parameters
+	GCP *infra.GCPConfig
+	// JumpHost optionally defines the SSH bastion host to connect to nodes through.
+	// Nodes are then connected to at their private addresses
+	JumpHost *infra.JumpHostConfig
+	// SSHAuth optionally configures additional methods to authenticate to nodes with
+	SSHAuth *infra.SSHAuthConfig `json:"ssh_auth,omitempty"`
+	// VerifyHostKeys enables SSH host key verification with trust on first use
+	VerifyHostKeys bool `json:"verify_host_keys"`
 	// OS defines OS flavor, ubuntu | redhat | centos | debian
 	OS string `json:"os" yaml:"os" validate:"required,eq=ubuntu|eq=redhat|eq=centos|eq=debian"`
 
@@ -57,6 +89,9 @@ type Config struct {
 	ScriptPath string `json:"script_path" validate:"required"`
 	// NumNodes defines the capacity of the cluster to provision
 	NumNodes int `json:"nodes" validate:"gte=1"`
+	// NodeGroups optionally splits the nodes into groups with own OS and instance type.
+	// Defaults to a single group of nodes with the configured OS
+	NodeGroups []infra.NodeGroup `json:"node_groups,omitempty"`
 	// InstallerURL is AWS S3 URL with the installer
 	InstallerURL string `json:"installer_url" validate:"required,url`
 }
@@ -5,11 +5,13 @@ import (
 	"net/url"
 	"strings"
 
+	sshutils "github.com/gravitational/robotest/lib/ssh"
+
 	"github.com/gravitational/trace"
 )
 
 // TransferFile takes file URL which may be S3 or HTTP or local file and transfers it to the machine
-// fileUrl - file to download, could be S3:// or http(s)://
+// fileUrl - file to download, could be s3://, gs:// or http(s)://
 // command - to run out of installer package
 func (t *terraform) makeRemoteCommand(fileUrl, command string) (string, error) {
 	u, err := url.Parse(fileUrl)
@@ -19,17 +21,19 @@ func (t *terraform) makeRemoteCommand(fileUrl, command string) (string, error) {
 
 	var homeDir = fmt.Sprintf("/home/%s", t.sshUser)
 	var outFile string
-	if strings.HasSuffix(fileUrl, ".tar.gz") {
+	if strings.HasSuffix(u.Path, ".tar.gz") {
 		outFile = fmt.Sprintf("%s/installer.tar.gz", homeDir)
-	} else if strings.HasSuffix(fileUrl, ".tar") {
+	} else if strings.HasSuffix(u.Path, ".tar") {
 		outFile = fmt.Sprintf("%s/installer.tar", homeDir)
 	} else {
 		return "", trace.Errorf("only .tar and .tar.gz installers supported, got %s", fileUrl)
 	}
 
-	var fetchCmd string
-	switch u.Scheme {
-	case "s3":
+	fetchCmd, err := sshutils.FetchCommand(fileUrl, outFile)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if u.Scheme == "s3" {
 		if t.Config.AWS == nil {
 			return "", trace.Errorf("AWS config missing, cannot use S3 URLs %s", fileUrl)
 		}
@@ -37,17 +41,8 @@ func (t *terraform) makeRemoteCommand(fileUrl, command string) (string, error) {
 		fetchCmd = fmt.Sprintf(`AWS_ACCESS_KEY_ID=%s \
 			AWS_SECRET_ACCESS_KEY=%s \
 			AWS_DEFAULT_REGION=%s \
-			aws s3 cp %s - > %s`,
-			t.Config.AWS.AccessKey, t.Config.AWS.SecretKey, t.Config.AWS.Region,
-			fileUrl, outFile)
-	case "http":
-	case "https":
-		fetchCmd = fmt.Sprintf("wget %s -O %s/", fileUrl, outFile)
-	case "":
-	case "gs":
-	default:
-		// TODO : implement SCP and GCLOUD methods
-		return "", fmt.Errorf("unsupported URL schema %s", fileUrl)
+			%s`,
+			t.Config.AWS.AccessKey, t.Config.AWS.SecretKey, t.Config.AWS.Region, fetchCmd)
 	}
 
 	// FIXME: migrate to ssh sequential command execution interface
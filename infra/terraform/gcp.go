@@ -0,0 +1,183 @@
+package terraform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/robotest/lib/system"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type GCPParam struct {
+	// Project is the ID of the project with the resources
+	Project string
+	// Credentials is the path to the service account key file
+	Credentials string
+}
+
+// GCPRemoveResources removes instances and disks labeled with the specified cluster name
+// as well as the cluster network and its firewall rules.
+// Resources that do not exist are skipped
+func GCPRemoveResources(ctx context.Context, param GCPParam, cluster string) error {
+	if param.Project == "" || cluster == "" {
+		return trace.BadParameter("project=%s, cluster=%s", param.Project, cluster)
+	}
+
+	filter := fmt.Sprintf("labels.%v=%v", gcpLabelCluster, cluster)
+	var errors []error
+	// instances go first as disks cannot be removed while attached
+	for _, resource := range []string{"instances", "disks"} {
+		out, err := gcloud(ctx, param, "compute", resource, "list",
+			"--filter", filter, "--format", "value(name,zone.basename())")
+		if err != nil {
+			return trace.Wrap(err, "failed to list %v: %s", resource, out)
+		}
+		for zone, names := range parseZonedNames(out) {
+			out, err = gcloud(ctx, param, append([]string{"compute", resource, "delete",
+				"--quiet", "--zone", zone}, names...)...)
+			if err != nil {
+				errors = append(errors, trace.Wrap(err, "failed to delete %v %v: %s", resource, names, out))
+			}
+		}
+	}
+	if len(errors) != 0 {
+		return trace.NewAggregate(errors...)
+	}
+
+	out, err := gcloud(ctx, param, "compute", "firewall-rules", "list",
+		"--filter", fmt.Sprintf("network~/%v$", cluster), "--format", "value(name)")
+	if err != nil {
+		return trace.Wrap(err, "failed to list firewall rules: %s", out)
+	}
+	if rules := strings.Fields(string(out)); len(rules) != 0 {
+		out, err = gcloud(ctx, param, append([]string{"compute", "firewall-rules", "delete", "--quiet"}, rules...)...)
+		if err != nil {
+			return trace.Wrap(err, "failed to delete firewall rules %v: %s", rules, out)
+		}
+	}
+
+	out, err = gcloud(ctx, param, "compute", "networks", "list",
+		"--filter", fmt.Sprintf("name=%v", cluster), "--format", "value(name)")
+	if err != nil {
+		return trace.Wrap(err, "failed to list networks: %s", out)
+	}
+	if len(bytes.TrimSpace(out)) != 0 {
+		out, err = gcloud(ctx, param, "compute", "networks", "delete", "--quiet", cluster)
+		if err != nil {
+			return trace.Wrap(err, "failed to delete network %v: %s", cluster, out)
+		}
+	}
+	return nil
+}
+
+// GCPInstanceAction performs the action (i.e. "stop", "start" or "reset") on
+// the instance with the specified name and zone and waits for it to complete
+func GCPInstanceAction(ctx context.Context, param GCPParam, name, zone, action string) error {
+	if name == "" || zone == "" {
+		return trace.BadParameter("instance=%s, zone=%s", name, zone)
+	}
+	out, err := gcloud(ctx, param, "compute", "instances", action, name, "--zone", zone, "--quiet")
+	if err != nil {
+		return trace.Wrap(err, "failed to %v instance %v: %s", action, name, out)
+	}
+	return nil
+}
+
+// GCPInstancePublicIP returns the external IP address of the instance.
+// Returns NotFound if the instance has no external address, i.e. it is stopped
+func GCPInstancePublicIP(ctx context.Context, param GCPParam, name, zone string) (string, error) {
+	out, err := gcloud(ctx, param, "compute", "instances", "describe", name, "--zone", zone,
+		"--format", "value(networkInterfaces[0].accessConfigs[0].natIP)")
+	if err != nil {
+		return "", trace.Wrap(err, "failed to query external IP of instance %v: %s", name, out)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", trace.NotFound("instance %v has no external IP", name)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// GCPClustersCreated returns the creation time of the oldest instance or disk
+// of every cluster in the project
+func GCPClustersCreated(ctx context.Context, param GCPParam) (map[string]time.Time, error) {
+	if param.Project == "" {
+		return nil, trace.BadParameter("project=%s", param.Project)
+	}
+
+	clusters := make(map[string]time.Time)
+	for _, resource := range []string{"instances", "disks"} {
+		out, err := gcloud(ctx, param, "compute", resource, "list",
+			"--filter", fmt.Sprintf("labels.%v:*", gcpLabelCluster),
+			"--format", fmt.Sprintf("value(labels.%v,creationTimestamp)", gcpLabelCluster))
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to list %v: %s", resource, out)
+		}
+		err = parseClustersCreated(out, clusters)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return clusters, nil
+}
+
+// parseClustersCreated updates clusters with the earliest creation times
+// from "<cluster> <timestamp>" lines
+func parseClustersCreated(data []byte, clusters map[string]time.Time) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return trace.Wrap(err, "invalid creation timestamp in %q", line)
+		}
+		if existing, ok := clusters[fields[0]]; !ok || created.Before(existing) {
+			clusters[fields[0]] = created
+		}
+	}
+	return nil
+}
+
+// parseZonedNames groups resource names listed as "<name> <zone>" lines by zone
+func parseZonedNames(data []byte) map[string][]string {
+	result := make(map[string][]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		result[fields[1]] = append(result[fields[1]], fields[0])
+	}
+	for _, names := range result {
+		sort.Strings(names)
+	}
+	return result
+}
+
+func gcloud(ctx context.Context, param GCPParam, args ...string) ([]byte, error) {
+	args = append(args, "--project", param.Project)
+	cmd := exec.CommandContext(ctx, "gcloud", args...)
+	var out bytes.Buffer
+	var opts []system.CommandOptionSetter
+	if param.Credentials != "" {
+		opts = append(opts, system.SetEnv(fmt.Sprintf("CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE=%v", param.Credentials)))
+	}
+	err := system.ExecL(cmd, &out, log.WithField("cmd", "gcloud"), opts...)
+	if err != nil {
+		return out.Bytes(), trace.Wrap(err, "command %q failed (args %q)", cmd.Path, cmd.Args)
+	}
+	return out.Bytes(), nil
+}
+
+// gcpLabelCluster is the label that groups resources of a single cluster.
+// See assets/terraform/gcp
+const gcpLabelCluster = "robotest-cluster"
@@ -0,0 +1,23 @@
+package terraform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupsResourcesByZone(t *testing.T) {
+	data := []byte(`robotest-node-1	us-central1-a
+robotest-node-0	us-central1-a
+robotest-node-2	us-central1-b
+
+`)
+	expected := map[string][]string{
+		"us-central1-a": {"robotest-node-0", "robotest-node-1"},
+		"us-central1-b": {"robotest-node-2"},
+	}
+
+	obtained := parseZonedNames(data)
+	if !reflect.DeepEqual(obtained, expected) {
+		t.Errorf("expected %v but got %v", expected, obtained)
+	}
+}
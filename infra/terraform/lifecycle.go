@@ -0,0 +1,193 @@
+package terraform
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stop gracefully shuts down the instance
+func (r *node) Stop(ctx context.Context) error {
+	return trace.Wrap(r.owner.power(ctx, r, powerStop))
+}
+
+// Start powers on the stopped instance.
+// Instances without a static public IP are likely to get a new address
+func (r *node) Start(ctx context.Context) error {
+	return trace.Wrap(r.owner.power(ctx, r, powerStart))
+}
+
+// Restart gracefully restarts the instance
+func (r *node) Restart(ctx context.Context) error {
+	return trace.Wrap(r.owner.power(ctx, r, powerRestart))
+}
+
+// Reset forcibly stops the instance and starts it again
+func (r *node) Reset(ctx context.Context) error {
+	return trace.Wrap(r.owner.power(ctx, r, powerReset))
+}
+
+// power changes the power state of the node with the cloud-specific API
+func (r *terraform) power(ctx context.Context, n *node, action powerAction) error {
+	err := r.resolveInstance(ctx, n)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	r.WithFields(log.Fields{"node": n, "instance": n.instanceID, "action": action}).Info("changing power state")
+
+	switch r.Config.CloudProvider {
+	case awsCloud:
+		err = r.powerAWS(ctx, n, action)
+	case azureCloud:
+		err = r.powerAzure(ctx, n, action)
+	case gcpCloud:
+		err = r.powerGCP(ctx, n, action)
+	default:
+		return trace.NotImplemented("power management is not supported for cloud %q", r.Config.CloudProvider)
+	}
+	return trace.Wrap(err)
+}
+
+// powerAWS changes the power state of an EC2 instance.
+// EC2 releases the public IP of a stopped instance
+func (r *terraform) powerAWS(ctx context.Context, n *node, action powerAction) error {
+	if r.Config.AWS == nil {
+		return trace.BadParameter("aws config is nil")
+	}
+	param := awsParam(*r.Config.AWS)
+	switch action {
+	case powerStop:
+		return trace.Wrap(AWSStopInstance(ctx, param, n.instanceID, false))
+	case powerRestart, powerReset:
+		// EC2 does not report reboot progress, so the instance is stopped and started again
+		err := AWSStopInstance(ctx, param, n.instanceID, action == powerReset)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	err := AWSStartInstance(ctx, param, n.instanceID)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	addr, err := AWSInstancePublicIP(ctx, param, n.instanceID)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	r.setPublicIP(n, addr)
+	return nil
+}
+
+// powerAzure changes the power state of an Azure virtual machine.
+// The machine is powered off but not deallocated so it retains its public IP
+func (r *terraform) powerAzure(ctx context.Context, n *node, action powerAction) error {
+	cfg := r.Config.Azure
+	if cfg == nil {
+		return trace.BadParameter("azure config is nil")
+	}
+	token, err := AzureGetAuthToken(ctx, azureAuthParam(*cfg))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	switch action {
+	case powerStop:
+		return trace.Wrap(AzureVMAction(ctx, token, n.instanceID, AzureVMPowerOff, false))
+	case powerStart:
+		return trace.Wrap(AzureVMAction(ctx, token, n.instanceID, AzureVMStart, false))
+	case powerRestart:
+		return trace.Wrap(AzureVMAction(ctx, token, n.instanceID, AzureVMRestart, false))
+	}
+	err = AzureVMAction(ctx, token, n.instanceID, AzureVMPowerOff, true)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(AzureVMAction(ctx, token, n.instanceID, AzureVMStart, false))
+}
+
+// powerGCP changes the power state of a GCE instance.
+// GCE releases the ephemeral external IP of a stopped instance
+func (r *terraform) powerGCP(ctx context.Context, n *node, action powerAction) error {
+	cfg := r.Config.GCP
+	if cfg == nil {
+		return trace.BadParameter("gcp config is nil")
+	}
+	param := gcpParam(*cfg)
+	switch action {
+	case powerStop:
+		return trace.Wrap(GCPInstanceAction(ctx, param, n.hostname, n.availabilityZone, "stop"))
+	case powerReset:
+		// reset keeps the instance running and retains its address
+		return trace.Wrap(GCPInstanceAction(ctx, param, n.hostname, n.availabilityZone, "reset"))
+	case powerRestart:
+		// GCE has no graceful restart
+		err := GCPInstanceAction(ctx, param, n.hostname, n.availabilityZone, "stop")
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	err := GCPInstanceAction(ctx, param, n.hostname, n.availabilityZone, "start")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	addr, err := GCPInstancePublicIP(ctx, param, n.hostname, n.availabilityZone)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	r.setPublicIP(n, addr)
+	return nil
+}
+
+// resolveInstance fills in the instance details of the node from terraform outputs
+// if they are missing, i.e. when the provisioner has been restored from state
+func (r *terraform) resolveInstance(ctx context.Context, n *node) error {
+	if n.instanceID != "" {
+		return nil
+	}
+	outputs, err := r.outputs(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, output := range outputs {
+		if output.privateIP == n.privateIP {
+			n.hostname = output.hostname
+			n.instanceID = output.instanceID
+			n.availabilityZone = output.availabilityZone
+			break
+		}
+	}
+	if n.instanceID == "" {
+		return trace.NotFound("no instance for node %v in terraform outputs", n)
+	}
+	return nil
+}
+
+// setPublicIP updates the public address of the node in place
+// preserving its allocation
+func (r *terraform) setPublicIP(n *node, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n.publicIP == addr {
+		return
+	}
+	r.WithFields(log.Fields{"node": n, "addr": addr}).Info("public IP changed")
+
+	if r.installerIP == n.publicIP {
+		r.installerIP = addr
+	}
+	r.pool.Update(func() { n.publicIP = addr })
+	// the address might have belonged to another instance before
+	if err := r.knownHosts.Forget(addr); err != nil {
+		r.WithError(err).Warn("failed to forget host key")
+	}
+}
+
+// powerAction names a change of node power state
+type powerAction string
+
+const (
+	powerStop    powerAction = "stop"
+	powerStart   powerAction = "start"
+	powerRestart powerAction = "restart"
+	powerReset   powerAction = "reset"
+)
@@ -4,30 +4,112 @@ import (
 	"fmt"
 
 	"golang.org/x/crypto/ssh"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
 )
 
 type node struct {
 	owner     *terraform
 	publicIP  string
 	privateIP string
+	// hostname is the node hostname as reported by terraform outputs
+	hostname string
+	// instanceID is the cloud-specific instance identifier
+	instanceID string
+	// availabilityZone is the cloud-specific zone (or location) of the instance
+	availabilityZone string
+	// os is the OS flavor of the node group the node belongs to
+	os string
+	// instanceType is the instance type of the node group the node belongs to
+	instanceType string
+	// sshUser is the SSH user of the node group the node belongs to
+	sshUser string
+	// group is the index of the node group the node belongs to
+	group string
+	// labels is the set of labels attached to the node
+	labels map[string]string
+}
+
+// setOutput updates the node with the details reported by terraform outputs
+func (r *node) setOutput(output nodeOutput) {
+	r.publicIP = output.publicIP
+	r.hostname = output.hostname
+	r.instanceID = output.instanceID
+	r.availabilityZone = output.availabilityZone
 }
 
+// Addr returns the address the node is reached at, which is the private IP
+// with a jump host as nodes might have no public IPs then
 func (r *node) Addr() string {
-	return r.publicIP
+	return r.sshAddr()
 }
 
 func (r *node) PrivateAddr() string {
 	return r.privateIP
 }
 
+// Hostname returns the hostname of the node if reported by the terraform script
+func (r *node) Hostname() string {
+	return r.hostname
+}
+
+// InstanceID returns the cloud instance identifier of the node if reported by the terraform script
+func (r *node) InstanceID() string {
+	return r.instanceID
+}
+
+// AvailabilityZone returns the availability zone of the node if reported by the terraform script
+func (r *node) AvailabilityZone() string {
+	return r.availabilityZone
+}
+
+// Meta returns the node metadata.
+// OS version is unknown as the OS image is defined by the terraform script.
+// Unless the node group of the node says otherwise, the OS, instance type and SSH user
+// are those of the cloud configuration.
+// Labels include the OS, instance type and node group of the node
+func (r *node) Meta() infra.NodeMeta {
+	meta := infra.NodeMeta{
+		Hostname:     r.hostname,
+		OS:           r.os,
+		InstanceType: r.instanceType,
+		SSHUser:      r.sshUser,
+		Labels:       r.labels,
+	}
+	if meta.OS == "" {
+		meta.OS = r.owner.OS
+	}
+	if meta.InstanceType == "" {
+		meta.InstanceType = r.owner.instanceType()
+	}
+	if meta.SSHUser == "" {
+		meta.SSHUser = r.owner.sshUser
+	}
+	return meta.WithLabels(r.group)
+}
+
 func (r *node) Connect() (*ssh.Session, error) {
-	return r.owner.Connect(r.publicIP)
+	client, err := r.Client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return client.NewSession()
 }
 
 func (r *node) Client() (*ssh.Client, error) {
-	return r.owner.Client(r.publicIP)
+	return r.owner.clientAs(r.sshAddr(), r.Meta().SSHUser)
+}
+
+// sshAddr returns the address to connect to the node at.
+// With a jump host, nodes are reached at their private addresses
+func (r *node) sshAddr() string {
+	if r.owner.JumpHost != nil {
+		return r.privateIP
+	}
+	return r.publicIP
 }
 
 func (r node) String() string {
-	return fmt.Sprintf("node(addr=%v)", r.publicIP)
+	return fmt.Sprintf("node(addr=%v)", r.Addr())
 }
@@ -0,0 +1,145 @@
+package terraform
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// output describes a single terraform output variable
+// as reported by `terraform output -json`
+type output struct {
+	// Sensitive specifies whether the value is marked as sensitive
+	Sensitive bool `json:"sensitive"`
+	// Type specifies the type of the value, i.e. "list" or "string".
+	// Starting with terraform 0.12 the type is a JSON array like ["list","string"]
+	Type json.RawMessage `json:"type"`
+	// Value is the raw value of the output
+	Value json.RawMessage `json:"value"`
+}
+
+// list interprets the output value as a list of strings.
+// For compatibility with scripts that join values into a string,
+// string values are split on whitespace
+func (r output) list() ([]string, error) {
+	var values []string
+	if err := json.Unmarshal(r.Value, &values); err == nil {
+		return values, nil
+	}
+	var value string
+	if err := json.Unmarshal(r.Value, &value); err != nil {
+		return nil, trace.BadParameter("expected a list or a string but got %s", r.Value)
+	}
+	return strings.Fields(value), nil
+}
+
+// nodeOutput describes a single node as reported by terraform outputs
+type nodeOutput struct {
+	publicIP         string
+	privateIP        string
+	hostname         string
+	instanceID       string
+	availabilityZone string
+}
+
+// parseNodes interprets the output of `terraform output -json` and
+// returns the nodes described by it ordered by node index.
+// Returns a retryable error if the outputs are incomplete, i.e. public IPs
+// have not been allocated yet.
+// Nodes reached through a jump host only need private IPs, so requirePublicIPs
+// is false for them and the public IPs are optional
+func parseNodes(data []byte, requirePublicIPs bool) ([]nodeOutput, error) {
+	var outputs map[string]output
+	err := json.Unmarshal(data, &outputs)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to decode terraform outputs")
+	}
+
+	privateIPs, err := outputList(outputs, outputPrivateIPs)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if privateIPs == nil {
+		return nil, trace.NotFound("no %q in terraform outputs", outputPrivateIPs)
+	}
+
+	publicIPs, err := outputList(outputs, outputPublicIPs)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if !requirePublicIPs && publicIPs == nil {
+		publicIPs = make([]string, len(privateIPs))
+	}
+	if publicIPs == nil || len(privateIPs) != len(publicIPs) || (requirePublicIPs && hasEmpty(publicIPs)) || hasEmpty(privateIPs) {
+		// one of the reasons is that public IP allocation is incomplete yet
+		// which happens for Azure; we will just repeat boot process once again
+		return nil, trace.Retry(
+			trace.NotFound("incomplete addresses in terraform outputs: private %q, public %q", privateIPs, publicIPs),
+			"terraform may not be able to acquire values of every parameter on create")
+	}
+
+	nodes := make([]nodeOutput, len(privateIPs))
+	for i := range privateIPs {
+		nodes[i].publicIP = publicIPs[i]
+		nodes[i].privateIP = privateIPs[i]
+	}
+
+	// optional per-node outputs
+	for name, set := range map[string]func(*nodeOutput, string){
+		outputHostnames:         func(n *nodeOutput, v string) { n.hostname = v },
+		outputInstanceIDs:       func(n *nodeOutput, v string) { n.instanceID = v },
+		outputAvailabilityZones: func(n *nodeOutput, v string) { n.availabilityZone = v },
+	} {
+		values, err := outputList(outputs, name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if values == nil {
+			continue
+		}
+		if len(values) != len(nodes) {
+			return nil, trace.BadParameter("expected %v values in %q output but got %v",
+				len(nodes), name, len(values))
+		}
+		for i, value := range values {
+			set(&nodes[i], value)
+		}
+	}
+
+	return nodes, nil
+}
+
+// outputList returns the list value of the named output.
+// Returns nil if there's no such output
+func outputList(outputs map[string]output, name string) ([]string, error) {
+	out, ok := outputs[name]
+	if !ok {
+		return nil, nil
+	}
+	values, err := out.list()
+	if err != nil {
+		return nil, trace.Wrap(err, "invalid %q output", name)
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return values, nil
+}
+
+func hasEmpty(values []string) bool {
+	for _, value := range values {
+		if value == "" {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	outputPrivateIPs        = "private_ips"
+	outputPublicIPs         = "public_ips"
+	outputHostnames         = "hostnames"
+	outputInstanceIDs       = "instance_ids"
+	outputAvailabilityZones = "availability_zones"
+)
@@ -0,0 +1,135 @@
+package terraform
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gravitational/trace"
+)
+
+func TestParsesNodes(t *testing.T) {
+	var testCases = []struct {
+		comment  string
+		outputs  []byte
+		expected []nodeOutput
+	}{
+		{
+			comment: "List outputs with per-node details",
+			outputs: []byte(`{
+  "private_ips": {"sensitive": false, "type": "list", "value": ["10.1.0.1", "10.1.0.2"]},
+  "public_ips": {"sensitive": false, "type": "list", "value": ["52.1.1.1", "52.1.1.2"]},
+  "hostnames": {"sensitive": false, "type": "list", "value": ["ip-10-1-0-1", "ip-10-1-0-2"]},
+  "instance_ids": {"sensitive": false, "type": "list", "value": ["i-1", "i-2"]},
+  "availability_zones": {"sensitive": false, "type": "list", "value": ["us-east-1a", "us-east-1b"]}
+}`),
+			expected: []nodeOutput{
+				{publicIP: "52.1.1.1", privateIP: "10.1.0.1", hostname: "ip-10-1-0-1", instanceID: "i-1", availabilityZone: "us-east-1a"},
+				{publicIP: "52.1.1.2", privateIP: "10.1.0.2", hostname: "ip-10-1-0-2", instanceID: "i-2", availabilityZone: "us-east-1b"},
+			},
+		},
+		{
+			comment: "Space-separated string outputs",
+			outputs: []byte(`{
+  "private_ips": {"sensitive": false, "type": "string", "value": "10.1.0.1 10.1.0.2"},
+  "public_ips": {"sensitive": false, "type": "string", "value": "52.1.1.1 52.1.1.2"}
+}`),
+			expected: []nodeOutput{
+				{publicIP: "52.1.1.1", privateIP: "10.1.0.1"},
+				{publicIP: "52.1.1.2", privateIP: "10.1.0.2"},
+			},
+		},
+		{
+			comment: "Terraform 0.12 outputs with composite types",
+			outputs: []byte(`{
+  "private_ips": {"sensitive": false, "type": ["list", "string"], "value": ["10.1.0.1", "10.1.0.2"]},
+  "public_ips": {"sensitive": false, "type": ["tuple", ["string", "string"]], "value": ["52.1.1.1", "52.1.1.2"]},
+  "hostnames": {"sensitive": false, "type": "string", "value": "ip-10-1-0-1 ip-10-1-0-2"}
+}`),
+			expected: []nodeOutput{
+				{publicIP: "52.1.1.1", privateIP: "10.1.0.1", hostname: "ip-10-1-0-1"},
+				{publicIP: "52.1.1.2", privateIP: "10.1.0.2", hostname: "ip-10-1-0-2"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		obtained, err := parseNodes(testCase.outputs, true)
+		if err != nil {
+			t.Errorf("%v: failed to parse outputs: %v", testCase.comment, err)
+		}
+		if !reflect.DeepEqual(obtained, testCase.expected) {
+			t.Errorf("%v: expected %v but got %v", testCase.comment, testCase.expected, obtained)
+		}
+	}
+}
+
+func TestRetriesOnlyIncompleteOutputs(t *testing.T) {
+	var testCases = []struct {
+		comment string
+		outputs []byte
+		retry   bool
+	}{
+		{
+			comment: "Public IPs not allocated yet",
+			outputs: []byte(`{
+  "private_ips": {"type": "list", "value": ["10.1.0.1", "10.1.0.2"]},
+  "public_ips": {"type": "list", "value": ["52.1.1.1", ""]}
+}`),
+			retry: true,
+		},
+		{
+			comment: "Public IPs missing",
+			outputs: []byte(`{"private_ips": {"type": "list", "value": ["10.1.0.1"]}}`),
+			retry:   true,
+		},
+		{
+			comment: "Private IPs missing",
+			outputs: []byte(`{"public_ips": {"type": "list", "value": ["52.1.1.1"]}}`),
+		},
+		{
+			comment: "Malformed output",
+			outputs: []byte(`{
+  "private_ips": {"type": "list", "value": ["10.1.0.1"]},
+  "public_ips": {"type": "map", "value": {"node": "52.1.1.1"}}
+}`),
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, err := parseNodes(testCase.outputs, true)
+		if err == nil {
+			t.Errorf("%v: expected an error", testCase.comment)
+			continue
+		}
+		if trace.IsRetryError(err) != testCase.retry {
+			t.Errorf("%v: expected retry=%v but got %v", testCase.comment, testCase.retry, err)
+		}
+	}
+}
+
+func TestParsesNodesWithoutPublicIPs(t *testing.T) {
+	expected := []nodeOutput{{privateIP: "10.1.0.1"}, {privateIP: "10.1.0.2"}}
+	for _, outputs := range [][]byte{
+		[]byte(`{
+  "private_ips": {"type": "list", "value": ["10.1.0.1", "10.1.0.2"]},
+  "public_ips": {"type": "list", "value": ["", ""]}
+}`),
+		[]byte(`{"private_ips": {"type": "list", "value": ["10.1.0.1", "10.1.0.2"]}}`),
+	} {
+		obtained, err := parseNodes(outputs, false)
+		if err != nil {
+			t.Errorf("failed to parse outputs %s: %v", outputs, err)
+		}
+		if !reflect.DeepEqual(obtained, expected) {
+			t.Errorf("expected %v but got %v", expected, obtained)
+		}
+	}
+
+	_, err := parseNodes([]byte(`{
+  "private_ips": {"type": "list", "value": ["10.1.0.1", ""]},
+  "public_ips": {"type": "list", "value": ["", ""]}
+}`), false)
+	if !trace.IsRetryError(err) {
+		t.Errorf("expected retry on missing private IPs but got %v", err)
+	}
+}
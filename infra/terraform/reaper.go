@@ -0,0 +1,443 @@
+package terraform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReaperConfig defines the sources of leaked cloud resources
+// and the credentials to remove them with
+type ReaperConfig struct {
+	// CloudProvider defines the cloud to sweep and to remove
+	// resources recorded in ResourceListFile from
+	CloudProvider string
+	// AWS defines AWS connection parameters
+	AWS *infra.AWSConfig
+	// Azure defines Azure connection parameters
+	Azure *infra.AzureConfig
+	// GCP defines Google Compute Engine connection parameters
+	GCP *infra.GCPConfig
+	// ResourceListFile is the file with tags of allocated resources,
+	// one per line, as recorded by the gravity test suite
+	ResourceListFile string
+	// StateDir is the base directory to look for terraform state
+	// left behind by interrupted runs
+	StateDir string
+	// TagPrefix enables the sweep of cloud resources tagged with
+	// names starting with the prefix
+	TagPrefix string
+	// OlderThan skips resources younger than the specified age.
+	// Resources of unknown age are never skipped
+	OlderThan time.Duration
+}
+
+// Leftover describes the resources of a single cluster left behind
+// by a test run
+type Leftover struct {
+	// Tag is the cluster name (resource group on Azure)
+	Tag string
+	// CloudProvider is the cloud with the resources
+	CloudProvider string
+	// Source describes where the leftover has been found
+	Source string
+	// StateDir is the directory with terraform state if any
+	StateDir string
+	// Created is the time the resources have been created, if known
+	Created time.Time
+
+	// config is the terraform configuration recovered from the state directory
+	config *Config
+}
+
+const (
+	// SourceStateDir marks leftovers found in the state directory
+	SourceStateDir = "state"
+	// SourceResourceList marks leftovers recorded in the resource list file
+	SourceResourceList = "list"
+	// SourceSweep marks leftovers found by the cloud sweep
+	SourceSweep = "sweep"
+)
+
+// NewReaper creates a new reaper with the specified configuration
+func NewReaper(config ReaperConfig) (*Reaper, error) {
+	switch config.CloudProvider {
+	case "", awsCloud, azureCloud, gcpCloud:
+	default:
+		return nil, trace.BadParameter("unsupported cloud provider %q", config.CloudProvider)
+	}
+	if config.TagPrefix != "" && config.CloudProvider == "" {
+		return nil, trace.BadParameter("cloud provider is required for the sweep")
+	}
+	r := &Reaper{
+		Entry:        log.WithField(constants.FieldProvisioner, "terraform"),
+		ReaperConfig: config,
+		now:          time.Now,
+	}
+	r.clustersCreated = r.cloudClustersCreated
+	return r, nil
+}
+
+// Reaper removes cloud resources leaked by crashed or interrupted test runs
+type Reaper struct {
+	*log.Entry
+	ReaperConfig
+
+	now func() time.Time
+	// clustersCreated returns the creation times of the clusters in the cloud by tag
+	clustersCreated func(context.Context) (map[string]time.Time, error)
+}
+
+// Find returns the leftovers from all configured sources sorted by tag.
+// Resources with terraform state take precedence over the other sources.
+// Leftovers of unknown age take the creation time found by the other sources
+func (r *Reaper) Find(ctx context.Context) ([]Leftover, error) {
+	leftovers := make(map[string]Leftover)
+	add := func(leftover Leftover) {
+		// Azure reports resource group names in lower case
+		key := strings.ToLower(leftover.Tag)
+		existing, ok := leftovers[key]
+		if !ok {
+			leftovers[key] = leftover
+			return
+		}
+		if existing.Created.IsZero() {
+			existing.Created = leftover.Created
+			leftovers[key] = existing
+		}
+	}
+
+	if r.StateDir != "" {
+		fromState, err := findStateDirs(r.StateDir)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, leftover := range fromState {
+			add(leftover)
+		}
+	}
+
+	if r.ResourceListFile != "" {
+		tags, err := readResourceList(r.ResourceListFile)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, tag := range tags {
+			add(Leftover{Tag: tag, CloudProvider: r.CloudProvider, Source: SourceResourceList})
+		}
+	}
+
+	if r.TagPrefix != "" {
+		swept, err := r.sweep(ctx)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, leftover := range swept {
+			add(leftover)
+		}
+	}
+
+	result := make([]Leftover, 0, len(leftovers))
+	for _, leftover := range leftovers {
+		if r.tooYoung(leftover) {
+			r.WithField("tag", leftover.Tag).Debugf("skip resources created at %v", leftover.Created)
+			continue
+		}
+		result = append(result, leftover)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tag < result[j].Tag })
+	return result, nil
+}
+
+// Reap removes the resources of the specified leftovers.
+// Removed tags are dropped from the resource list file
+func (r *Reaper) Reap(ctx context.Context, leftovers []Leftover) error {
+	var errors []error
+	var removed []string
+	for _, leftover := range leftovers {
+		logger := r.WithFields(log.Fields{"tag": leftover.Tag, "source": leftover.Source})
+		logger.Info("removing resources")
+		err := r.reap(ctx, leftover)
+		if err != nil {
+			logger.WithError(err).Error("failed to remove resources")
+			errors = append(errors, trace.Wrap(err, "failed to remove %v", leftover.Tag))
+			continue
+		}
+		removed = append(removed, leftover.Tag)
+	}
+
+	if r.ResourceListFile != "" && len(removed) != 0 {
+		err := removeFromResourceList(r.ResourceListFile, removed)
+		if err != nil {
+			errors = append(errors, trace.Wrap(err))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+func (r *Reaper) reap(ctx context.Context, leftover Leftover) error {
+	if leftover.config != nil {
+		t, err := NewFromState(*leftover.config, infra.ProvisionerState{Dir: leftover.StateDir})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = t.Destroy(ctx)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.ConvertSystemError(os.RemoveAll(leftover.StateDir))
+	}
+
+	switch leftover.CloudProvider {
+	case azureCloud:
+		if r.Azure == nil {
+			return trace.BadParameter("azure config is required")
+		}
+		token, err := AzureGetAuthToken(ctx, azureAuthParam(*r.Azure))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = AzureRemoveResourceGroup(ctx, token, r.Azure.SubscriptionId, leftover.Tag)
+		if trace.IsNotFound(err) {
+			// has been removed already
+			return nil
+		}
+		return trace.Wrap(err)
+	case gcpCloud:
+		if r.GCP == nil {
+			return trace.BadParameter("gcp config is required")
+		}
+		return trace.Wrap(GCPRemoveResources(ctx, gcpParam(*r.GCP), leftover.Tag))
+	case awsCloud:
+		// AWS resources are only removed through terraform
+		return trace.NotFound("no terraform state for %v", leftover.Tag)
+	default:
+		return trace.BadParameter("unsupported cloud provider %q", leftover.CloudProvider)
+	}
+}
+
+// sweep lists the clusters in the cloud with tags matching the prefix
+func (r *Reaper) sweep(ctx context.Context) ([]Leftover, error) {
+	clusters, err := r.clustersCreated(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var leftovers []Leftover
+	for tag, created := range clusters {
+		// resource group names are case-insensitive on Azure
+		if !strings.HasPrefix(strings.ToLower(tag), strings.ToLower(r.TagPrefix)) {
+			continue
+		}
+		leftovers = append(leftovers, Leftover{
+			Tag:           tag,
+			CloudProvider: r.CloudProvider,
+			Source:        SourceSweep,
+			Created:       created,
+		})
+	}
+	return leftovers, nil
+}
+
+// cloudClustersCreated returns the creation times of the clusters in the configured cloud by tag
+func (r *Reaper) cloudClustersCreated(ctx context.Context) (clusters map[string]time.Time, err error) {
+	switch r.CloudProvider {
+	case azureCloud:
+		if r.Azure == nil {
+			return nil, trace.BadParameter("azure config is required")
+		}
+		var token *AzureToken
+		token, err = AzureGetAuthToken(ctx, azureAuthParam(*r.Azure))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		clusters, err = AzureResourceGroupsCreated(ctx, token, r.Azure.SubscriptionId)
+	case gcpCloud:
+		if r.GCP == nil {
+			return nil, trace.BadParameter("gcp config is required")
+		}
+		clusters, err = GCPClustersCreated(ctx, gcpParam(*r.GCP))
+	default:
+		return nil, trace.BadParameter("sweep is not supported for %q", r.CloudProvider)
+	}
+	return clusters, trace.Wrap(err)
+}
+
+func (r *Reaper) tooYoung(leftover Leftover) bool {
+	if r.OlderThan == 0 || leftover.Created.IsZero() {
+		return false
+	}
+	return r.now().Sub(leftover.Created) < r.OlderThan
+}
+
+// findStateDirs returns leftovers for all terraform state directories
+// under dir. A state directory is recognized by the terraform vars file
+func findStateDirs(dir string) ([]Leftover, error) {
+	var leftovers []Leftover
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		if fi.IsDir() || fi.Name() != tfVarsFile {
+			return nil
+		}
+		stateDir := filepath.Dir(path)
+		config, err := configFromVars(path)
+		if err != nil {
+			return trace.Wrap(err, "failed to read terraform vars in %v", stateDir)
+		}
+		leftovers = append(leftovers, Leftover{
+			Tag:           config.ClusterName,
+			CloudProvider: config.CloudProvider,
+			Source:        SourceStateDir,
+			StateDir:      stateDir,
+			Created:       fi.ModTime(),
+			config:        config,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return leftovers, nil
+}
+
+// configFromVars recovers the terraform configuration from the vars file
+// written by saveVarsJSON
+func configFromVars(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	var vars map[string]json.RawMessage
+	err = json.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var params struct {
+		Nodes int    `json:"nodes"`
+		OS    string `json:"os"`
+	}
+	err = json.Unmarshal(data, &params)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	config := &Config{NumNodes: params.Nodes, OS: params.OS}
+	if config.OS == "" {
+		// vars written by older versions do not record the OS which is only
+		// required for the image lookup
+		config.OS = "ubuntu"
+	}
+
+	// the cloud is recognized by its mandatory parameters
+	switch {
+	case vars["subscription_id"] != nil:
+		config.CloudProvider = azureCloud
+		config.Azure = &infra.AzureConfig{}
+		err = json.Unmarshal(data, config.Azure)
+		config.ClusterName = config.Azure.ResourceGroup
+	case vars["project"] != nil:
+		config.CloudProvider = gcpCloud
+		config.GCP = &infra.GCPConfig{}
+		err = json.Unmarshal(data, config.GCP)
+		config.ClusterName = config.GCP.ClusterName
+	case vars["access_key"] != nil:
+		config.CloudProvider = awsCloud
+		config.AWS = &infra.AWSConfig{}
+		err = json.Unmarshal(data, config.AWS)
+		config.ClusterName = config.AWS.ClusterName
+	default:
+		return nil, trace.BadParameter("unrecognized cloud in %v", path)
+	}
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if config.ClusterName == "" {
+		return nil, trace.BadParameter("no cluster name in %v", path)
+	}
+	return config, nil
+}
+
+// readResourceList returns the unique tags recorded in the resource list file
+func readResourceList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	var tags []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tag := strings.TrimSpace(scanner.Text())
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, trace.Wrap(scanner.Err())
+}
+
+// removeFromResourceList rewrites the resource list file without the specified tags
+func removeFromResourceList(path string, removed []string) error {
+	tags, err := readResourceList(path)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	skip := make(map[string]bool, len(removed))
+	for _, tag := range removed {
+		skip[tag] = true
+	}
+	var buf bytes.Buffer
+	for _, tag := range tags {
+		if !skip[tag] {
+			buf.WriteString(tag)
+			buf.WriteString("\n")
+		}
+	}
+	err = ioutil.WriteFile(path, buf.Bytes(), constants.SharedReadMask)
+	return trace.ConvertSystemError(err)
+}
+
+func azureAuthParam(config infra.AzureConfig) AzureAuthParam {
+	return AzureAuthParam{
+		ClientId:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		TenantId:     config.TenantId,
+	}
+}
+
+func awsParam(config infra.AWSConfig) AWSParam {
+	return AWSParam{
+		AccessKey: config.AccessKey,
+		SecretKey: config.SecretKey,
+		Region:    config.Region,
+	}
+}
+
+func gcpParam(config infra.GCPConfig) GCPParam {
+	return GCPParam{
+		Project:     config.Project,
+		Credentials: config.Credentials,
+	}
+}
@@ -0,0 +1,136 @@
+package terraform
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/infra"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindsLeftovers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reaper")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	writeVars(t, filepath.Join(dir, "state", "install-1", "ubuntu", "tf"),
+		&infra.AzureConfig{SubscriptionId: "subscription", ResourceGroup: "robotest-install-1"}, now.Add(-2*time.Hour))
+	writeVars(t, filepath.Join(dir, "state", "install-2", "centos", "tf"),
+		&infra.GCPConfig{Project: "project", ClusterName: "robotest-install-2"}, now.Add(-2*time.Hour))
+	writeVars(t, filepath.Join(dir, "state", "install-3", "ubuntu", "tf"),
+		&infra.AWSConfig{AccessKey: "key", ClusterName: "robotest-install-3"}, now)
+
+	resourceList := filepath.Join(dir, "alloc.txt")
+	err = ioutil.WriteFile(resourceList, []byte("robotest-install-1\nrobotest-install-3\nrobotest-crashed\n\n"), 0644)
+	require.NoError(t, err)
+
+	reaper, err := NewReaper(ReaperConfig{
+		CloudProvider:    "azure",
+		ResourceListFile: resourceList,
+		StateDir:         filepath.Join(dir, "state"),
+		OlderThan:        time.Hour,
+	})
+	require.NoError(t, err)
+
+	leftovers, err := reaper.Find(context.TODO())
+	require.NoError(t, err)
+
+	type leftover struct{ tag, cloud, source string }
+	var obtained []leftover
+	for _, l := range leftovers {
+		obtained = append(obtained, leftover{l.Tag, l.CloudProvider, l.Source})
+	}
+	expected := []leftover{
+		{"robotest-crashed", "azure", SourceResourceList},
+		{"robotest-install-1", "azure", SourceStateDir},
+		// install-3 is too young and is not picked from the resource list either
+		{"robotest-install-2", "gcp", SourceStateDir},
+	}
+	if !reflect.DeepEqual(obtained, expected) {
+		t.Errorf("expected %v but got %v", expected, obtained)
+	}
+	require.Equal(t, 3, leftovers[1].config.NumNodes)
+	require.Equal(t, "ubuntu", leftovers[1].config.OS)
+}
+
+func TestSkipsYoungListedClusters(t *testing.T) {
+	resourceList, err := ioutil.TempFile("", "reaper")
+	require.NoError(t, err)
+	defer os.Remove(resourceList.Name())
+	_, err = resourceList.WriteString("robotest-running\nrobotest-stale\nrobotest-crashed\n")
+	require.NoError(t, err)
+	require.NoError(t, resourceList.Close())
+
+	reaper, err := NewReaper(ReaperConfig{
+		CloudProvider:    "gcp",
+		ResourceListFile: resourceList.Name(),
+		TagPrefix:        "robotest-",
+		OlderThan:        24 * time.Hour,
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	reaper.clustersCreated = func(context.Context) (map[string]time.Time, error) {
+		return map[string]time.Time{
+			"robotest-running": now.Add(-time.Hour),
+			"robotest-stale":   now.Add(-48 * time.Hour),
+		}, nil
+	}
+
+	leftovers, err := reaper.Find(context.TODO())
+	require.NoError(t, err)
+	require.Len(t, leftovers, 2)
+	require.Equal(t, "robotest-crashed", leftovers[0].Tag)
+	require.True(t, leftovers[0].Created.IsZero())
+	require.Equal(t, "robotest-stale", leftovers[1].Tag)
+	require.Equal(t, SourceResourceList, leftovers[1].Source)
+	require.Equal(t, now.Add(-48*time.Hour), leftovers[1].Created)
+}
+
+func TestRemovesTagsFromResourceList(t *testing.T) {
+	f, err := ioutil.TempFile("", "reaper")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("a\nb\nc\nb\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, removeFromResourceList(f.Name(), []string{"b"}))
+
+	tags, err := readResourceList(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, tags)
+}
+
+func TestParsesClusterCreationTimes(t *testing.T) {
+	clusters := map[string]time.Time{}
+	err := parseClustersCreated([]byte(`robotest-1	2018-06-01T10:00:00.000-07:00
+robotest-1	2018-06-01T09:00:00.000-07:00
+robotest-2	2018-06-02T10:00:00.000-07:00
+`), clusters)
+	require.NoError(t, err)
+	require.Len(t, clusters, 2)
+	require.Equal(t, "2018-06-01T16:00:00Z", clusters["robotest-1"].UTC().Format(time.RFC3339))
+
+	require.Equal(t, "robotest-1", azureResourceGroup(
+		"/subscriptions/1/resourceGroups/ROBOTEST-1/providers/Microsoft.Compute/virtualMachines/node-0"))
+	require.Equal(t, "", azureResourceGroup("/subscriptions/1"))
+}
+
+func writeVars(t *testing.T, dir string, config interface{}, modTime time.Time) {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	vars, err := makeVars(config, []infra.NodeGroup{{Count: 3, OS: "ubuntu"}})
+	require.NoError(t, err)
+	data, err := json.Marshal(vars)
+	require.NoError(t, err)
+	path := filepath.Join(dir, tfVarsFile)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
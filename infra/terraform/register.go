@@ -0,0 +1,51 @@
+package terraform
+
+import (
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	infra.RegisterProvisioner("terraform", infra.ProvisionerFactory{
+		New: func(stateDir string, config infra.ProvisionerConfig) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return New(stateDir, *c)
+		},
+		NewFromState: func(config infra.ProvisionerConfig, state infra.ProvisionerState) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return NewFromState(*c, state)
+		},
+	})
+}
+
+func newConfig(config infra.ProvisionerConfig) (*Config, error) {
+	if config.CloudProvider == "" {
+		return nil, trace.BadParameter("cloud provider is required for terraform")
+	}
+	c := &Config{
+		Config:         config.Config,
+		ScriptPath:     config.ScriptPath,
+		InstallerURL:   config.InstallerURL,
+		NumNodes:       config.NumNodes,
+		OS:             config.OS,
+		NodeGroups:     config.NodeGroups,
+		CloudProvider:  config.CloudProvider,
+		AWS:            config.AWS,
+		Azure:          config.Azure,
+		GCP:            config.GCP,
+		JumpHost:       config.JumpHost,
+		SSHAuth:        config.SSHAuth,
+		VerifyHostKeys: config.VerifyHostKeys,
+	}
+	err := c.Validate()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return c, nil
+}
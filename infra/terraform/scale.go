@@ -0,0 +1,57 @@
+package terraform
+
+import (
+	"context"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+)
+
+// Grow re-applies the configuration with count more nodes
+// and returns the nodes added
+func (r *terraform) Grow(ctx context.Context, count int) ([]infra.Node, error) {
+	if count <= 0 {
+		return nil, trace.BadParameter("expected a positive number of nodes to add but got %v", count)
+	}
+	r.NumNodes = r.pool.Size() + count
+	r.Infof("growing cluster to %v nodes", r.NumNodes)
+
+	added, err := r.apply(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(added) != count {
+		return added, trace.BadParameter("expected %v new nodes in terraform outputs but got %v", count, len(added))
+	}
+	return added, nil
+}
+
+// Shrink re-applies the configuration without the specified nodes.
+// As terraform scripts create nodes by count, only the most recently
+// added nodes can be removed
+func (r *terraform) Shrink(ctx context.Context, nodes []infra.Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	outputs, err := r.outputs(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var addrs []string
+	for _, output := range outputs {
+		addrs = append(addrs, output.privateIP)
+	}
+	var removed []string
+	for _, n := range nodes {
+		removed = append(removed, n.PrivateAddr())
+	}
+	err = infra.CheckTrailing(addrs, removed)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	r.NumNodes = len(outputs) - len(nodes)
+	r.Infof("shrinking cluster to %v nodes", r.NumNodes)
+	_, err = r.apply(ctx)
+	return trace.Wrap(err)
+}
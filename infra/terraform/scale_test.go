@@ -0,0 +1,101 @@
+package terraform
+
+import (
+	"testing"
+
+	"github.com/gravitational/robotest/infra"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdatesNodesPreservingAllocation(t *testing.T) {
+	r := &terraform{Entry: log.NewEntry(log.New()), pool: infra.NewNodePool(nil, nil)}
+	added := r.updateNodes([]nodeOutput{
+		{privateIP: "10.0.0.1", publicIP: "52.0.0.1"},
+		{privateIP: "10.0.0.2", publicIP: "52.0.0.2"},
+	})
+	require.Len(t, added, 2)
+	allocated, err := r.pool.Allocate(1)
+	require.NoError(t, err)
+
+	added = r.updateNodes([]nodeOutput{
+		{privateIP: "10.0.0.1", publicIP: "52.0.0.1"},
+		{privateIP: "10.0.0.2", publicIP: "52.0.0.2"},
+		{privateIP: "10.0.0.3", publicIP: "52.0.0.3"},
+	})
+	require.Len(t, added, 1)
+	require.Equal(t, "10.0.0.3", added[0].PrivateAddr())
+	require.Equal(t, 3, r.pool.Size())
+	require.Equal(t, allocated, r.pool.AllocatedNodes())
+
+	added = r.updateNodes([]nodeOutput{
+		{privateIP: "10.0.0.1", publicIP: "52.0.0.1"},
+		{privateIP: "10.0.0.2", publicIP: "52.0.0.2"},
+	})
+	require.Empty(t, added)
+	require.Equal(t, 2, r.pool.Size())
+	require.Equal(t, allocated, r.pool.AllocatedNodes())
+
+	r.setPublicIP(allocated[0].(*node), "52.0.0.10")
+	require.Equal(t, allocated, r.pool.AllocatedNodes())
+	n, err := r.pool.Node("52.0.0.10")
+	require.NoError(t, err)
+	require.Equal(t, allocated[0], n)
+}
+
+func TestKeysNodesByPrivateIPWithJumpHost(t *testing.T) {
+	r := &terraform{
+		Entry:  log.NewEntry(log.New()),
+		Config: Config{JumpHost: &infra.JumpHostConfig{Addr: "bastion", SSHUser: "ubuntu"}},
+		pool:   infra.NewNodePool(nil, nil),
+	}
+	added := r.updateNodes([]nodeOutput{{privateIP: "10.0.0.1"}, {privateIP: "10.0.0.2"}})
+	require.Len(t, added, 2)
+	require.Equal(t, "10.0.0.2", added[1].Addr())
+	n, err := r.pool.Node("10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, added[1], n)
+}
+
+func TestAssignsNodeGroups(t *testing.T) {
+	r := &terraform{
+		Config: Config{
+			NumNodes: 3,
+			OS:       "centos",
+			AWS:      &infra.AWSConfig{InstanceType: "c4.large"},
+			NodeGroups: []infra.NodeGroup{
+				{Count: 1, OS: "centos", InstanceType: "c4.2xlarge"},
+				{Count: 2, OS: "ubuntu", SSHUser: "ubuntu"},
+			},
+			CloudProvider: awsCloud,
+		},
+		sshUser: "centos",
+		pool:    infra.NewNodePool(nil, nil),
+	}
+	added := r.updateNodes([]nodeOutput{
+		{privateIP: "10.0.0.1", publicIP: "52.0.0.1"},
+		{privateIP: "10.0.0.2", publicIP: "52.0.0.2"},
+		{privateIP: "10.0.0.3", publicIP: "52.0.0.3"},
+	})
+	require.Len(t, added, 3)
+	require.Equal(t, infra.NodeMeta{OS: "centos", InstanceType: "c4.2xlarge", SSHUser: "centos",
+		Labels: map[string]string{"os": "centos", "instance-type": "c4.2xlarge", "node-group": "0"}}, added[0].Meta())
+	require.Equal(t, infra.NodeMeta{OS: "ubuntu", InstanceType: "c4.large", SSHUser: "ubuntu",
+		Labels: map[string]string{"os": "ubuntu", "instance-type": "c4.large", "node-group": "1"}}, added[1].Meta())
+	require.Equal(t, infra.NodeMeta{OS: "ubuntu", InstanceType: "c4.large", SSHUser: "ubuntu",
+		Labels: map[string]string{"os": "ubuntu", "instance-type": "c4.large", "node-group": "1"}}, added[2].Meta())
+
+	pool := infra.NewNodePool(added, nil)
+	selected, err := pool.AllocateMatching(2, infra.Selector{"os": "ubuntu"})
+	require.NoError(t, err)
+	require.Equal(t, added[1:], selected)
+
+	groups, err := r.nodeGroups()
+	require.NoError(t, err)
+	vars, err := makeVars(r.Config.AWS, groups)
+	require.NoError(t, err)
+	require.Equal(t, 3, vars["nodes"])
+	require.Equal(t, "centos", vars["os"])
+	require.Equal(t, []string{"centos", "ubuntu", "ubuntu"}, vars["node_os"])
+	require.Equal(t, []string{"c4.2xlarge", "", ""}, vars["node_instance_types"])
+}
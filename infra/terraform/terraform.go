@@ -8,8 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -29,11 +30,18 @@ const (
 
 	azureCloud = "azure"
 	awsCloud   = "aws"
+	gcpCloud   = "gcp"
 )
 
 func New(stateDir string, config Config) (*terraform, error) {
+	config = config.withDefaults()
 	user, keypath := config.SSHConfig()
 
+	knownHosts, err := infra.LoadKnownHosts(stateDir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
 	return &terraform{
 		Entry: log.WithFields(log.Fields{
 			constants.FieldProvisioner: "terraform",
@@ -46,10 +54,14 @@ func New(stateDir string, config Config) (*terraform, error) {
 
 		sshUser:    user,
 		sshKeyPath: keypath,
+		sshAuth:    config.SSHAuth.Auth(keypath),
+		jumpHost:   config.JumpHost.JumpHost(),
+		knownHosts: knownHosts,
 	}, nil
 }
 
 func NewFromState(config Config, stateConfig infra.ProvisionerState) (*terraform, error) {
+	config = config.withDefaults()
 	t := &terraform{
 		Entry: log.WithFields(log.Fields{
 			constants.FieldProvisioner: "terraform",
@@ -61,10 +73,26 @@ func NewFromState(config Config, stateConfig infra.ProvisionerState) (*terraform
 	}
 
 	t.sshUser, t.sshKeyPath = config.SSHConfig()
+	t.sshAuth = config.SSHAuth.Auth(t.sshKeyPath)
+	t.jumpHost = config.JumpHost.JumpHost()
+	knownHosts, err := infra.LoadKnownHosts(stateConfig.Dir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	t.knownHosts = knownHosts
 
 	nodes := make([]infra.Node, 0, len(stateConfig.Nodes))
 	for _, n := range stateConfig.Nodes {
-		nodes = append(nodes, &node{publicIP: n.Addr, owner: t})
+		nodes = append(nodes, &node{
+			publicIP:     n.Addr,
+			privateIP:    n.PrivateAddr,
+			hostname:     n.Hostname,
+			os:           n.OS,
+			instanceType: n.InstanceType,
+			sshUser:      n.User,
+			labels:       n.Labels,
+			owner:        t,
+		})
 	}
 	t.pool = infra.NewNodePool(nodes, stateConfig.Allocated)
 
@@ -80,20 +108,30 @@ func (r *terraform) Create(ctx context.Context, withInstaller bool) (installer i
 		return nil, trace.Errorf("No Terraform configs at %s", r.ScriptPath)
 	}
 
+	_, err = r.apply(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if withInstaller {
+		nodes := r.pool.Nodes()
+		if len(nodes) == 0 { // should not happen, and doesn't make sense to retry
+			return nil, trace.Errorf("Zero nodes were allocated")
+		}
+		r.installerIP = nodes[0].Addr()
+		return nodes[0], nil
+	}
+	return nil, nil
+}
+
+// apply brings the cluster to the configured number of nodes
+// and returns the nodes added to the pool
+func (r *terraform) apply(ctx context.Context) ([]infra.Node, error) {
 	// sometimes terraform cannot receive all required params
 	// most often public IPs take time to allocate (on Azure)
 	for {
-		err := r.terraform(ctx)
+		added, err := r.terraform(ctx)
 		if err == nil {
-			if withInstaller {
-				nodes := r.pool.Nodes()
-				if len(nodes) == 0 { // should not happen, and doesn't make sense to retry
-					return nil, trace.Errorf("Zero nodes were allocated")
-				}
-				r.installerIP = nodes[0].Addr()
-				return nodes[0], nil
-			}
-			return nil, nil
+			return added, nil
 		}
 
 		if !trace.IsRetryError(err) {
@@ -108,49 +146,93 @@ func (r *terraform) Create(ctx context.Context, withInstaller bool) (installer i
 		case <-time.After(terraformRepeatAfter):
 		}
 	}
-
 }
 
-func (r *terraform) terraform(ctx context.Context) (err error) {
-	output, err := r.boot(ctx)
+func (r *terraform) terraform(ctx context.Context) (added []infra.Node, err error) {
+	err = r.boot(ctx)
 	if err != nil {
-		return trace.Wrap(err)
+		return nil, trace.Wrap(err)
 	}
 
-	// find all nodes' private IPs
-	match := rePrivateIPs.FindStringSubmatch(output)
-	if len(match) != 2 {
-		return trace.NotFound(
-			"failed to extract private IPs from terraform output: %v", match)
+	outputs, err := r.outputs(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
 	}
-	privateIPs := strings.Split(strings.TrimSpace(match[1]), " ")
-
-	// find all nodes' public IPs
-	match = rePublicIPs.FindStringSubmatch(output)
-	if len(match) != 2 {
-		// one of the reasons is that public IP allocation is incomplete yet
-		// which happens for Azure; we will just repeat boot process once again
-		return trace.Retry(
-			trace.NotFound("failed to extract public IPs from terraform output: %v", match),
+	if len(outputs) < r.NumNodes {
+		return nil, trace.Retry(
+			trace.NotFound("expected %v nodes in terraform outputs but got %v", r.NumNodes, len(outputs)),
 			"terraform may not be able to acquire values of every parameter on create")
 	}
-	publicIPs := strings.Split(strings.TrimSpace(match[1]), " ")
 
-	if len(privateIPs) != len(publicIPs) {
-		return trace.Retry(
-			trace.BadParameter("number of private IPs is different than public IPs: %v != %v",
-				len(privateIPs), len(publicIPs)),
-			"still allocating public IP addresses")
+	added = r.updateNodes(outputs)
+	r.Debugf("cluster: %#v", r.pool.Nodes())
+	return added, nil
+}
+
+// updateNodes updates the node pool in place with the nodes from terraform outputs.
+// Existing nodes are updated and keep their allocation,
+// nodes missing from outputs are removed.
+// Nodes are assigned to node groups in the order of outputs.
+// Host keys of added and dropped nodes are forgotten as addresses may be reused.
+// Returns the nodes added to the pool
+func (r *terraform) updateNodes(outputs []nodeOutput) (added []infra.Node) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups, err := r.nodeGroups()
+	if err != nil {
+		r.WithError(err).Warn("failed to determine node groups")
 	}
 
-	nodes := make([]infra.Node, 0, len(publicIPs))
-	for i, addr := range publicIPs {
-		nodes = append(nodes, &node{privateIP: privateIPs[i], publicIP: addr, owner: r})
+	current := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		current[output.privateIP] = true
+	}
+	existing := make(map[string]*node)
+	var removed []infra.Node
+	var forget []string
+	for _, n := range r.pool.Nodes() {
+		n := n.(*node)
+		if current[n.privateIP] {
+			existing[n.privateIP] = n
+		} else {
+			removed = append(removed, n)
+			forget = append(forget, n.publicIP, n.privateIP)
+		}
 	}
-	r.pool = infra.NewNodePool(nodes, nil)
+	infra.Disconnect(removed...)
+	r.pool.Remove(removed...)
 
-	r.Debugf("cluster: %#v", nodes)
-	return nil
+	r.pool.Update(func() {
+		for _, output := range outputs {
+			if n, ok := existing[output.privateIP]; ok {
+				n.setOutput(output)
+			}
+		}
+	})
+
+	for i, output := range outputs {
+		if _, ok := existing[output.privateIP]; ok {
+			continue
+		}
+		n := &node{owner: r, privateIP: output.privateIP}
+		n.setOutput(output)
+		if index, err := infra.NodeGroupIndex(groups, i); err == nil {
+			group := groups[index]
+			n.os = group.OS
+			n.instanceType = group.InstanceType
+			n.sshUser = group.SSHUser
+			n.group = strconv.Itoa(index)
+		}
+		added = append(added, n)
+		forget = append(forget, n.publicIP, n.privateIP)
+	}
+	r.pool.Add(added...)
+
+	if err := r.knownHosts.Forget(forget...); err != nil {
+		r.WithError(err).Warn("failed to forget host keys")
+	}
+	return added
 }
 
 func (r *terraform) destroyAzure(ctx context.Context) error {
@@ -159,20 +241,33 @@ func (r *terraform) destroyAzure(ctx context.Context) error {
 		return trace.Errorf("azure config is nil")
 	}
 
-	token, err := AzureGetAuthToken(ctx, AzureAuthParam{
-		ClientId:     cfg.ClientId,
-		ClientSecret: cfg.ClientSecret,
-		TenantId:     cfg.TenantId})
+	token, err := AzureGetAuthToken(ctx, azureAuthParam(*cfg))
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
 	err = AzureRemoveResourceGroup(ctx, token, cfg.SubscriptionId, cfg.ResourceGroup)
+	if trace.IsNotFound(err) {
+		r.Warnf("resource group %v does not exist", cfg.ResourceGroup)
+		return nil
+	}
+	return trace.Wrap(err)
+}
+
+// destroyGCP removes all resources labeled with the cluster name
+func (r *terraform) destroyGCP(ctx context.Context) error {
+	cfg := r.Config.GCP
+	if cfg == nil {
+		return trace.Errorf("gcp config is nil")
+	}
+
+	err := GCPRemoveResources(ctx, gcpParam(*cfg), cfg.ClusterName)
 	return trace.Wrap(err)
 }
 
 func (r *terraform) Destroy(ctx context.Context) error {
 	r.Debugf("destroying terraform cluster: %v", r.stateDir)
+	infra.Disconnect(r.pool.Nodes()...)
 
 	if r.Config.CloudProvider == azureCloud {
 		err := r.destroyAzure(ctx)
@@ -183,6 +278,15 @@ func (r *terraform) Destroy(ctx context.Context) error {
 		return trace.Wrap(err, "cleaning up %s: %v", r.stateDir, err)
 	}
 
+	if r.Config.CloudProvider == gcpCloud {
+		err := r.destroyGCP(ctx)
+		if err != nil {
+			return trace.Wrap(err, "gcpDestroy %v", err)
+		}
+		err = os.RemoveAll(r.stateDir)
+		return trace.Wrap(err, "cleaning up %s: %v", r.stateDir, err)
+	}
+
 	varsPath := filepath.Join(r.stateDir, tfVarsFile)
 	_, err := r.command(ctx, []string{
 		"destroy", "-force",
@@ -208,14 +312,19 @@ func (r *terraform) Connect(addrIP string) (*ssh.Session, error) {
 	return client.NewSession()
 }
 
-// Client establishes an SSH connection to the specified address
+// Client establishes an SSH connection to the specified address.
+// Nodes of the cluster are connected to through the jump host if configured
 func (r *terraform) Client(addrIP string) (*ssh.Client, error) {
-	keyFile, err := os.Open(r.sshKeyPath)
-	if err != nil {
-		return nil, trace.Wrap(err)
+	if node, err := r.pool.Node(addrIP); err == nil {
+		return node.Client()
 	}
+	return r.clientAs(addrIP, r.sshUser)
+}
 
-	return sshutils.Client(fmt.Sprintf("%v:22", addrIP), r.sshUser, keyFile)
+// clientAs establishes an SSH connection to the specified address as the given user
+func (r *terraform) clientAs(addrIP, user string) (*ssh.Client, error) {
+	return sshutils.ClientVia(r.jumpHost, fmt.Sprintf("%v:22", addrIP), user, r.sshAuth,
+		sshutils.HostKeys(r.knownHosts))
 }
 
 func (r *terraform) StartInstall(session *ssh.Session) error {
@@ -243,7 +352,12 @@ func (r *terraform) InstallerLogPath() string {
 func (r *terraform) State() infra.ProvisionerState {
 	nodes := make([]infra.StateNode, 0, r.pool.Size())
 	for _, n := range r.pool.Nodes() {
-		nodes = append(nodes, infra.StateNode{Addr: n.(*node).publicIP, KeyPath: r.sshKeyPath})
+		node := n.(*node)
+		nodes = append(nodes, infra.StateNode{
+			Addr:        node.publicIP,
+			PrivateAddr: node.privateIP,
+			KeyPath:     r.sshKeyPath,
+		}.WithMeta(node.Meta()))
 	}
 	allocated := make([]string, 0, r.pool.SizeAllocated())
 	for _, node := range r.pool.AllocatedNodes() {
@@ -257,11 +371,11 @@ func (r *terraform) State() infra.ProvisionerState {
 	}
 }
 
-func (r *terraform) boot(ctx context.Context) (output string, err error) {
+func (r *terraform) boot(ctx context.Context) error {
 	varsPath := filepath.Join(r.stateDir, tfVarsFile)
-	err = r.saveVarsJSON(varsPath)
+	err := r.saveVarsJSON(varsPath)
 	if err != nil {
-		return "", trace.Wrap(err, "failed to store Terraform vars")
+		return trace.Wrap(err, "failed to store Terraform vars")
 	}
 
 	out, err := r.command(ctx, []string{
@@ -271,10 +385,23 @@ func (r *terraform) boot(ctx context.Context) (output string, err error) {
 		fmt.Sprintf("-var-file=%s", varsPath),
 	})
 	if err != nil {
-		return "", trace.Wrap(err, "failed to boot terraform cluster: %s", out)
+		return trace.Wrap(err, "failed to boot terraform cluster: %s", out)
 	}
 
-	return string(out), nil
+	return nil
+}
+
+// instanceType returns the instance type the cloud configuration creates nodes with
+func (r *terraform) instanceType() string {
+	switch {
+	case r.Config.CloudProvider == awsCloud && r.Config.AWS != nil:
+		return r.Config.AWS.InstanceType
+	case r.Config.CloudProvider == azureCloud && r.Config.Azure != nil:
+		return r.Config.Azure.VmType
+	case r.Config.CloudProvider == gcpCloud && r.Config.GCP != nil:
+		return r.Config.GCP.VMType
+	}
+	return ""
 }
 
 func (r *terraform) command(ctx context.Context, args []string, opts ...system.CommandOptionSetter) ([]byte, error) {
@@ -288,7 +415,29 @@ func (r *terraform) command(ctx context.Context, args []string, opts ...system.C
 	return out.Bytes(), nil
 }
 
-// serializes terraform vars into given file as JSON
+// outputs returns the nodes described by `terraform output -json`.
+// Only stdout is decoded as terraform writes warnings to stderr
+func (r *terraform) outputs(ctx context.Context) ([]nodeOutput, error) {
+	cmd := exec.CommandContext(ctx, "terraform", "output", "-json")
+	cmd.Dir = r.stateDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	r.Entry.WithFields(log.Fields{
+		constants.FieldCommandError:       (err != nil),
+		constants.FieldCommandErrorReport: trace.UserMessage(err),
+	}).Info(strings.Join(cmd.Args, " "))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to read terraform outputs: %s", stderr.Bytes())
+	}
+	nodes, err := parseNodes(out, r.JumpHost == nil)
+	return nodes, trace.Wrap(err)
+}
+
+// serializes terraform vars into given file as JSON.
+// Besides the cloud configuration, the vars include the node count and OS
+// along with the OS and instance type of each node
+// so the state directory is self-contained for destroy, see Reaper
 func (r *terraform) saveVarsJSON(varFile string) error {
 	var config interface{}
 	switch r.Config.CloudProvider {
@@ -296,10 +445,22 @@ func (r *terraform) saveVarsJSON(varFile string) error {
 		config = r.Config.AWS
 	case azureCloud:
 		config = r.Config.Azure
+	case gcpCloud:
+		config = r.Config.GCP
 	default:
 		return trace.Errorf("No configuration for cloud %s", r.Config.CloudProvider)
 	}
 
+	groups, err := r.nodeGroups()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	vars, err := makeVars(config, groups)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	f, err := os.OpenFile(varFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 440)
 	if err != nil {
 		return trace.Wrap(err, "Cannot save Terraform Vars file %s", varFile)
@@ -309,7 +470,38 @@ func (r *terraform) saveVarsJSON(varFile string) error {
 	enc := json.NewEncoder(f)
 	enc.SetIndent(" ", " ")
 	log.Debug(config)
-	return trace.Wrap(enc.Encode(config))
+	return trace.Wrap(enc.Encode(vars))
+}
+
+// makeVars returns terraform variables for the given cloud configuration and node groups.
+// Empty instance types in node_instance_types select the instance type of the cloud configuration
+func makeVars(config interface{}, groups []infra.NodeGroup) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var vars map[string]interface{}
+	err = json.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	nodes := 0
+	osFlavors := []string{}
+	instanceTypes := []string{}
+	for _, group := range groups {
+		nodes += group.Count
+		for i := 0; i < group.Count; i++ {
+			osFlavors = append(osFlavors, group.OS)
+			instanceTypes = append(instanceTypes, group.InstanceType)
+		}
+	}
+	vars["nodes"] = nodes
+	if len(groups) != 0 {
+		vars["os"] = groups[0].OS
+	}
+	vars["node_os"] = osFlavors
+	vars["node_instance_types"] = instanceTypes
+	return vars, nil
 }
 
 type terraform struct {
@@ -318,13 +510,21 @@ type terraform struct {
 
 	sshUser, sshKeyPath string
 	sshClient           *ssh.Client
-
+	// sshAuth authenticates SSH connections to nodes.
+	// The SSH key is loaded once and reused for all connections
+	sshAuth *sshutils.Auth
+	// jumpHost is the optional SSH bastion host to connect to nodes through
+	jumpHost *sshutils.JumpHost
+	// knownHosts records host keys of nodes if host keys are verified
+	knownHosts *sshutils.KnownHosts
+
+	// mu guards pool and installerIP against concurrent address changes
+	mu          sync.Mutex
 	pool        infra.NodePool
 	stateDir    string
 	installerIP string
 }
 
-var (
-	rePrivateIPs = regexp.MustCompile("(?m:^ *private_ips *= *([0-9\\. ]+))")
-	rePublicIPs  = regexp.MustCompile("(?m:^ *public_ips *= *([0-9\\. ]+))")
-)
+func args(opts ...string) (result []string) {
+	return opts
+}
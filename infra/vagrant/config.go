@@ -25,4 +25,6 @@ type Config struct {
 	InstallerURL string `json:"installer_url"`
 	// NumNodes defines the capacity of the cluster to provision
 	NumNodes int `json:"nodes"`
+	// VerifyHostKeys enables SSH host key verification with trust on first use
+	VerifyHostKeys bool `json:"verify_host_keys"`
 }
@@ -0,0 +1,160 @@
+package vagrant
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stop gracefully shuts down the VM.
+// A VM that is already shutting down is waited for
+func (r *node) Stop(ctx context.Context) error {
+	domain, err := r.owner.domain(ctx, r)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	state, err := r.owner.domainState(ctx, domain)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if state == domainRunning {
+		r.owner.WithFields(log.Fields{"node": r, "domain": domain}).Info("stopping")
+		out, err := r.owner.virsh(ctx, "shutdown", domain)
+		if err != nil {
+			// the guest might have powered off since its state was queried
+			if state, _ := r.owner.domainState(ctx, domain); state != domainShutOff {
+				return trace.Wrap(err, "failed to shut down %v: %s", domain, out)
+			}
+		}
+	}
+	return trace.Wrap(r.owner.waitDomainState(ctx, domain, domainShutOff))
+}
+
+// Start powers on the stopped VM.
+// A VM in the process of shutting down is started once it has stopped
+func (r *node) Start(ctx context.Context) error {
+	domain, err := r.owner.domain(ctx, r)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	state, err := r.owner.domainState(ctx, domain)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	switch state {
+	case domainRunning:
+		return nil
+	case domainInShutdown:
+		err = r.owner.waitDomainState(ctx, domain, domainShutOff)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	r.owner.WithFields(log.Fields{"node": r, "domain": domain}).Info("starting")
+	out, err := r.owner.virsh(ctx, "start", domain)
+	if err != nil {
+		return trace.Wrap(err, "failed to start %v: %s", domain, out)
+	}
+	return trace.Wrap(r.owner.waitDomainState(ctx, domain, domainRunning))
+}
+
+// Restart gracefully restarts the VM.
+// libvirt does not report reboot progress, so the VM is shut down and started again
+func (r *node) Restart(ctx context.Context) error {
+	err := r.Stop(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(r.Start(ctx))
+}
+
+// Reset forcibly powers off the VM and starts it again
+func (r *node) Reset(ctx context.Context) error {
+	domain, err := r.owner.domain(ctx, r)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	r.owner.WithFields(log.Fields{"node": r, "domain": domain}).Info("resetting")
+	out, err := r.owner.virsh(ctx, "destroy", domain)
+	if err != nil {
+		return trace.Wrap(err, "failed to power off %v: %s", domain, out)
+	}
+	err = r.owner.waitDomainState(ctx, domain, domainShutOff)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(r.Start(ctx))
+}
+
+// domain returns the name of the libvirt domain of the node
+func (r *vagrant) domain(ctx context.Context, n *node) (string, error) {
+	host, err := r.host(n)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	domains, err := hostDomains(r.stateDir, []string{host})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return domains[0], nil
+}
+
+// host returns the name of the vagrant host of the node.
+// Nodes restored from state have no host name and are looked up by address
+func (r *vagrant) host(n *node) (string, error) {
+	if n.host != "" {
+		return n.host, nil
+	}
+	out, err := r.command(args("ssh-config"))
+	if err != nil {
+		return "", trace.Wrap(err, "failed to query SSH config: %s", out)
+	}
+	for _, host := range parseHosts(out) {
+		addr, err := r.getIPLibvirt(host)
+		if err == nil && addr == n.addrIP {
+			n.host = host
+			return host, nil
+		}
+	}
+	return "", trace.NotFound("failed to find vagrant host for node %v", n)
+}
+
+// domainState returns the state of the libvirt domain, i.e. domainRunning
+func (r *vagrant) domainState(ctx context.Context, domain string) (string, error) {
+	out, err := r.virsh(ctx, "domstate", domain)
+	if err != nil {
+		return "", trace.Wrap(err, "failed to query state of %v: %s", domain, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// waitDomainState blocks until the libvirt domain reaches the specified state
+func (r *vagrant) waitDomainState(ctx context.Context, domain, state string) error {
+	for {
+		current, err := r.domainState(ctx, domain)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if current == state {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return trace.LimitExceeded("%v is %q and not %q: %v", domain, current, state, ctx.Err())
+		case <-time.After(domainPollInterval):
+		}
+	}
+}
+
+const (
+	domainRunning    = "running"
+	domainShutOff    = "shut off"
+	domainInShutdown = "in shutdown"
+
+	// domainPollInterval defines how often to poll the state of a libvirt domain
+	domainPollInterval = 2 * time.Second
+)
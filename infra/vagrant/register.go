@@ -0,0 +1,40 @@
+package vagrant
+
+import (
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+)
+
+func init() {
+	infra.RegisterProvisioner("vagrant", infra.ProvisionerFactory{
+		New: func(stateDir string, config infra.ProvisionerConfig) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return New(stateDir, *c)
+		},
+		NewFromState: func(config infra.ProvisionerConfig, state infra.ProvisionerState) (infra.Provisioner, error) {
+			c, err := newConfig(config)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return NewFromState(*c, state)
+		},
+	})
+}
+
+func newConfig(config infra.ProvisionerConfig) (*Config, error) {
+	c := &Config{
+		Config:         config.Config,
+		ScriptPath:     config.ScriptPath,
+		InstallerURL:   config.InstallerURL,
+		NumNodes:       config.NumNodes,
+		VerifyHostKeys: config.VerifyHostKeys,
+	}
+	err := c.Validate()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return c, nil
+}
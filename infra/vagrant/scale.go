@@ -0,0 +1,92 @@
+package vagrant
+
+import (
+	"context"
+
+	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
+)
+
+// Grow brings up count more VMs and returns the nodes added
+func (r *vagrant) Grow(ctx context.Context, count int) ([]infra.Node, error) {
+	if count <= 0 {
+		return nil, trace.BadParameter("expected a positive number of nodes to add but got %v", count)
+	}
+	r.NumNodes = r.pool.Size() + count
+	r.Infof("growing cluster to %v nodes", r.NumNodes)
+
+	err := r.boot(false)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	discovered, err := r.discoverNodes()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	existing := make(map[string]bool)
+	for _, n := range r.pool.Nodes() {
+		existing[n.Addr()] = true
+	}
+	var added []infra.Node
+	for _, n := range discovered {
+		if !existing[n.Addr()] {
+			added = append(added, n)
+		}
+	}
+	r.forgetHostKeys(added)
+	r.detectOS(ctx, added)
+	r.pool.Add(added...)
+	if len(added) != count {
+		return added, trace.BadParameter("expected %v new nodes but discovered %v", count, len(added))
+	}
+	return added, nil
+}
+
+// Shrink destroys the VMs of the specified nodes.
+// As the Vagrantfile defines VMs by count, only the most recently
+// added nodes can be removed
+func (r *vagrant) Shrink(ctx context.Context, nodes []infra.Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	out, err := r.command(args("ssh-config"))
+	if err != nil {
+		return trace.Wrap(err, "failed to query SSH config: %s", out)
+	}
+	hosts := parseHosts(out)
+
+	var removedHosts []string
+	for _, n := range nodes {
+		host, err := r.host(n.(*node))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		removedHosts = append(removedHosts, host)
+	}
+	err = infra.CheckTrailing(hosts, removedHosts)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	// libvirt refuses to undefine domains with snapshots
+	domains, err := hostDomains(r.stateDir, removedHosts)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	err = r.deleteDomainSnapshots(ctx, domains)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	r.Infof("destroying %v", removedHosts)
+	out, err = r.command(append(args("destroy", "-f"), removedHosts...))
+	if err != nil {
+		return trace.Wrap(err, "failed to destroy %v: %s", removedHosts, out)
+	}
+	r.NumNodes = len(hosts) - len(nodes)
+	r.forgetHostKeys(nodes)
+	infra.Disconnect(nodes...)
+	r.pool.Remove(nodes...)
+	return nil
+}
@@ -0,0 +1,185 @@
+package vagrant
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/robotest/lib/system"
+	"github.com/gravitational/trace"
+)
+
+// Snapshot records the state of all cluster VMs as libvirt snapshots
+func (r *vagrant) Snapshot(ctx context.Context, name string) error {
+	domains, err := r.domains()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, domain := range domains {
+		snapshots, err := r.domainSnapshots(ctx, domain)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if contains(snapshots, name) {
+			out, err := r.virsh(ctx, "snapshot-delete", "--domain", domain, "--snapshotname", name)
+			if err != nil {
+				return trace.Wrap(err, "failed to replace snapshot %v of %v: %s", name, domain, out)
+			}
+		}
+		out, err := r.virsh(ctx, "snapshot-create-as", "--domain", domain, "--name", name)
+		if err != nil {
+			return trace.Wrap(err, "failed to snapshot %v: %s", domain, out)
+		}
+	}
+	return nil
+}
+
+// Restore reverts all cluster VMs to the specified libvirt snapshot
+func (r *vagrant) Restore(ctx context.Context, name string) error {
+	domains, err := r.domains()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, domain := range domains {
+		out, err := r.virsh(ctx, "snapshot-revert", "--domain", domain, "--snapshotname", name, "--running")
+		if err != nil {
+			return trace.Wrap(err, "failed to restore %v to snapshot %v: %s", domain, name, out)
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns the names of libvirt snapshots available for all cluster VMs
+func (r *vagrant) ListSnapshots(ctx context.Context) ([]string, error) {
+	domains, err := r.domains()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	counts := make(map[string]int)
+	for _, domain := range domains {
+		snapshots, err := r.domainSnapshots(ctx, domain)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, snapshot := range snapshots {
+			counts[snapshot]++
+		}
+	}
+	var result []string
+	for snapshot, count := range counts {
+		if count == len(domains) {
+			result = append(result, snapshot)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// deleteSnapshots removes all libvirt snapshots of the cluster VMs
+// as libvirt refuses to undefine domains with snapshots
+func (r *vagrant) deleteSnapshots(ctx context.Context) error {
+	domains, err := r.domains()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(r.deleteDomainSnapshots(ctx, domains))
+}
+
+// deleteDomainSnapshots removes all libvirt snapshots of the specified domains
+func (r *vagrant) deleteDomainSnapshots(ctx context.Context, domains []string) error {
+	var errors []error
+	for _, domain := range domains {
+		snapshots, err := r.domainSnapshots(ctx, domain)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		for _, snapshot := range snapshots {
+			out, err := r.virsh(ctx, "snapshot-delete", "--domain", domain, "--snapshotname", snapshot)
+			if err != nil {
+				errors = append(errors, trace.Wrap(err, "failed to delete snapshot %v of %v: %s", snapshot, domain, out))
+			}
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+func (r *vagrant) domainSnapshots(ctx context.Context, domain string) ([]string, error) {
+	out, err := r.virsh(ctx, "snapshot-list", "--domain", domain, "--name")
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list snapshots of %v: %s", domain, out)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// domains returns the libvirt domains of the cluster VMs
+func (r *vagrant) domains() ([]string, error) {
+	out, err := r.command(args("ssh-config"))
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to query SSH config: %s", out)
+	}
+	domains, err := hostDomains(r.stateDir, parseHosts(out))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return domains, nil
+}
+
+func (r *vagrant) virsh(ctx context.Context, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "virsh", args...)
+	err := system.ExecL(cmd, io.MultiWriter(&out, r), r.Entry)
+	if err != nil {
+		return out.Bytes(), trace.Wrap(err, "command %q failed (args %q)", cmd.Path, cmd.Args)
+	}
+	return out.Bytes(), nil
+}
+
+// parseHosts returns the names of the hosts from the output of `vagrant ssh-config`
+func parseHosts(config []byte) (hosts []string) {
+	s := bufio.NewScanner(bytes.NewReader(config))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "Host ") {
+			hosts = append(hosts, strings.TrimSpace(strings.TrimPrefix(line, "Host")))
+		}
+	}
+	return hosts
+}
+
+// hostDomains returns the libvirt domain for each of the vagrant hosts.
+// vagrant-libvirt records the UUID of the domain it created for a host
+// in the machine directory of the vagrant project, so the domain cannot be
+// confused with a VM of another cluster with the same host name
+func hostDomains(stateDir string, hosts []string) ([]string, error) {
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		path := filepath.Join(stateDir, ".vagrant", "machines", host, "libvirt", "id")
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, trace.ConvertSystemError(err)
+		}
+		id := strings.TrimSpace(string(data))
+		if id == "" {
+			return nil, trace.NotFound("failed to find libvirt domain for node %q", host)
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -25,6 +25,10 @@ import (
 )
 
 func New(stateDir string, config Config) (*vagrant, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateDir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 	return &vagrant{
 		Entry: log.WithFields(log.Fields{
 			constants.FieldProvisioner: "vagrant",
@@ -32,12 +36,17 @@ func New(stateDir string, config Config) (*vagrant, error) {
 		}),
 		stateDir: stateDir,
 		// will be reset in Create
-		pool:   infra.NewNodePool(nil, nil),
-		Config: config,
+		pool:       infra.NewNodePool(nil, nil),
+		Config:     config,
+		knownHosts: knownHosts,
 	}, nil
 }
 
 func NewFromState(config Config, stateConfig infra.ProvisionerState) (*vagrant, error) {
+	knownHosts, err := infra.LoadKnownHosts(stateConfig.Dir, config.VerifyHostKeys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 	v := &vagrant{
 		Entry: log.WithFields(log.Fields{
 			constants.FieldProvisioner: "vagrant",
@@ -46,10 +55,11 @@ func NewFromState(config Config, stateConfig infra.ProvisionerState) (*vagrant,
 		stateDir:    stateConfig.Dir,
 		installerIP: stateConfig.InstallerAddr,
 		Config:      config,
+		knownHosts:  knownHosts,
 	}
 	nodes := make([]infra.Node, 0, len(stateConfig.Nodes))
 	for _, n := range stateConfig.Nodes {
-		nodes = append(nodes, &node{addrIP: n.Addr, identityFile: n.KeyPath})
+		nodes = append(nodes, &node{addrIP: n.Addr, identityFile: n.KeyPath, host: n.Hostname, meta: n.Meta(), owner: v})
 	}
 	v.pool = infra.NewNodePool(nodes, stateConfig.Allocated)
 	return v, nil
@@ -78,6 +88,8 @@ func (r *vagrant) Create(ctx context.Context, withInstaller bool) (installer inf
 		return nil, trace.BadParameter("number of requested nodes %v larger than the cluster capacity %v", r.Config.NumNodes, len(nodes))
 	}
 
+	r.forgetHostKeys(nodes)
+	r.detectOS(ctx, nodes)
 	r.pool = infra.NewNodePool(nodes, nil)
 	r.Debugf("cluster: %#v", r.pool)
 
@@ -95,6 +107,11 @@ func (r *vagrant) Create(ctx context.Context, withInstaller bool) (installer inf
 
 func (r *vagrant) Destroy(ctx context.Context) error {
 	r.Debugf("destroying vagrant cluster: %v", r.stateDir)
+	infra.Disconnect(r.pool.Nodes()...)
+	err := r.deleteSnapshots(ctx)
+	if err != nil {
+		r.WithError(err).Warn("failed to delete snapshots")
+	}
 	out, err := r.command(args("destroy", "-f"))
 	if err != nil {
 		return trace.Wrap(err, "failed to destroy vagrant cluster: %s", out)
@@ -151,7 +168,7 @@ func (r *vagrant) InstallerLogPath() string {
 func (r *vagrant) State() infra.ProvisionerState {
 	nodes := make([]infra.StateNode, 0, r.pool.Size())
 	for _, n := range r.pool.Nodes() {
-		nodes = append(nodes, infra.StateNode{Addr: n.(*node).addrIP, KeyPath: n.(*node).identityFile})
+		nodes = append(nodes, infra.StateNode{Addr: n.(*node).addrIP, KeyPath: n.(*node).identityFile}.WithMeta(n.Meta()))
 	}
 	allocated := make([]string, 0, r.pool.SizeAllocated())
 	for _, node := range r.pool.AllocatedNodes() {
@@ -218,10 +235,39 @@ func (r *vagrant) discoverNodes() ([]infra.Node, error) {
 	if err != nil {
 		return nil, trace.Wrap(err, "failed to parse SSH config")
 	}
+	for _, n := range nodes {
+		n.(*node).owner = r
+	}
 
 	return nodes, nil
 }
 
+// forgetHostKeys forgets the host keys of the specified nodes
+// as VMs created anew reuse addresses
+func (r *vagrant) forgetHostKeys(nodes []infra.Node) {
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr())
+	}
+	if err := r.knownHosts.Forget(addrs...); err != nil {
+		r.WithError(err).Warn("failed to forget host keys")
+	}
+}
+
+// detectOS records the OS distribution and version of the specified nodes.
+// Nodes with unknown OS are only logged as metadata is informational
+func (r *vagrant) detectOS(ctx context.Context, nodes []infra.Node) {
+	for _, n := range nodes {
+		os, version, err := infra.DetectOS(ctx, n, r.Entry)
+		if err != nil {
+			r.WithError(err).Warnf("failed to detect OS of %v", n)
+			continue
+		}
+		n.(*node).meta.OS = os
+		n.(*node).meta.OSVersion = version
+	}
+}
+
 func (r *vagrant) getIPLibvirt(nodename string) (string, error) {
 	var out bytes.Buffer
 	cmd := exec.Command("virsh", "list", "--name")
@@ -332,6 +378,15 @@ func (r *node) PrivateAddr() string {
 	return r.addrIP
 }
 
+// Meta returns the node metadata.
+// Vagrant machines are named after their hosts and form a single node group
+func (r *node) Meta() infra.NodeMeta {
+	meta := r.meta
+	meta.Hostname = r.host
+	meta.SSHUser = "vagrant"
+	return meta.WithLabels("0")
+}
+
 func (r *node) Connect() (*ssh.Session, error) {
 	client, err := r.Client()
 	if err != nil {
@@ -347,12 +402,9 @@ func (r *node) Connect() (*ssh.Session, error) {
 }
 
 func (r *node) Client() (*ssh.Client, error) {
-	keyFile, err := os.Open(r.identityFile)
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-	defer keyFile.Close()
-	return sshutils.Client(fmt.Sprintf("%v:22", r.addrIP), "vagrant", keyFile)
+	auth := sshutils.NewAuth(sshutils.AuthConfig{KeyPath: r.identityFile})
+	return sshutils.Client(fmt.Sprintf("%v:22", r.addrIP), "vagrant", auth,
+		sshutils.HostKeys(r.owner.knownHosts))
 }
 
 func (r node) String() string {
@@ -383,7 +435,7 @@ func parseSSHConfig(config []byte, getIP func(string) (string, error)) (nodes []
 			if err != nil {
 				return nil, trace.Wrap(err, "failed to determine IP address of the host %q", host)
 			}
-			nodes = append(nodes, &node{addrIP: addrIP, identityFile: identityFile})
+			nodes = append(nodes, &node{addrIP: addrIP, identityFile: identityFile, host: host})
 		}
 	}
 	return nodes, nil
@@ -399,11 +451,19 @@ type vagrant struct {
 	// nodes maps node address to a node.
 	// nodes represents the total cluster capacity as defined by the Vagrantfile
 	nodes map[string]node
+	// knownHosts records host keys of nodes if host keys are verified
+	knownHosts *sshutils.KnownHosts
 }
 
 type node struct {
 	identityFile string
 	addrIP       string
+	// host is the name of the vagrant host of the node
+	host string
+	// meta is the node metadata besides host name and SSH user
+	meta infra.NodeMeta
+	// owner is the provisioner that created the node
+	owner *vagrant
 }
 
 type domain struct {
@@ -1,10 +1,14 @@
 package vagrant
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/gravitational/robotest/infra"
+	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -26,7 +30,7 @@ func TestParsesSSHConfig(t *testing.T) {
   IdentityFile "/path/to/box/virtualbox/private_key"
   IdentitiesOnly yes
   LogLevel FATAL`),
-			expected: []infra.Node{&node{identityFile: "/path/to/box/virtualbox/private_key", addrIP: "127.0.0.1"}},
+			expected: []infra.Node{&node{identityFile: "/path/to/box/virtualbox/private_key", addrIP: "127.0.0.1", host: "master"}},
 		},
 		{
 			comment: "Handles unquoted identity file path as well",
@@ -40,7 +44,7 @@ func TestParsesSSHConfig(t *testing.T) {
   IdentityFile /path/to/box/virtualbox/private_key
   IdentitiesOnly yes
   LogLevel FATAL`),
-			expected: []infra.Node{&node{identityFile: "/path/to/box/virtualbox/private_key", addrIP: "127.0.0.1"}},
+			expected: []infra.Node{&node{identityFile: "/path/to/box/virtualbox/private_key", addrIP: "127.0.0.1", host: "master"}},
 		},
 	}
 	getIP := func(host string) (string, error) { return "127.0.0.1", nil }
@@ -56,3 +60,44 @@ func TestParsesSSHConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestResolvesHostDomains(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "vagrant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	for host, id := range map[string]string{
+		"node-1":  "0b4ad3b5-1e4e-4a7c-9a2f-6a1c0d7d2c11",
+		"node-10": "5d1e9c6a-8d3b-4f0e-b7a4-3f2e1c9b8a70\n",
+	} {
+		dir := filepath.Join(stateDir, ".vagrant", "machines", host, "libvirt")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "id"), []byte(id), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hosts := parseHosts([]byte(`Host node-1
+  HostName 192.168.121.10
+  IdentityFile /path/to/private_key
+Host node-10
+  HostName 192.168.121.11
+  IdentityFile /path/to/private_key
+`))
+	expected := []string{"0b4ad3b5-1e4e-4a7c-9a2f-6a1c0d7d2c11", "5d1e9c6a-8d3b-4f0e-b7a4-3f2e1c9b8a70"}
+
+	obtained, err := hostDomains(stateDir, hosts)
+	if err != nil {
+		t.Fatalf("failed to resolve domains: %v", err)
+	}
+	if !reflect.DeepEqual(obtained, expected) {
+		t.Errorf("expected %v but got %v", expected, obtained)
+	}
+
+	_, err = hostDomains(stateDir, []string{"node-2"})
+	if !trace.IsNotFound(err) {
+		t.Errorf("expected not found for a host without domain but got %v", err)
+	}
+}
@@ -0,0 +1,512 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/robotest/lib/system"
+
+	"github.com/gravitational/trace"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+)
+
+// Cache is a local content-addressed cache of files fetched by URL, i.e. installers.
+// Files are stored by their SHA-256 checksum so URLs with the same content share a file.
+// URLs with a checksum fetched before, including by earlier runs, are served without network access.
+// Other URLs are served from the cache as long as the ETag or the modification time
+// of the remote file has not changed.
+// Local files are not copied into the cache
+type Cache struct {
+	dir string
+	log logrus.FieldLogger
+
+	mu sync.Mutex
+	// fetches deduplicates fetches of the same URL and
+	// remembers the files fetched by this process
+	fetches map[string]*fetch
+	// entries maps checksums to the cached files
+	entries map[string]*Entry
+}
+
+// Entry describes a cached file
+type Entry struct {
+	// URL is the URL the file has been fetched from, without the fragment
+	URL string `json:"url"`
+	// Name is the name of the file
+	Name string `json:"name"`
+	// Checksum is the hex-encoded SHA-256 checksum of the file
+	Checksum string `json:"sha256"`
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+	// Path is the location of the file
+	Path string `json:"-"`
+	version
+}
+
+// version identifies the revision of a remote file
+type version struct {
+	// ETag is the entity tag of the remote file, if reported
+	ETag string `json:"etag,omitempty"`
+	// LastModified is the modification time of the remote file, if reported
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// known returns true if the revision of the remote file can be told apart from others
+func (r version) known() bool {
+	return r.ETag != "" || r.LastModified != ""
+}
+
+// New returns a new cache that stores files in dir
+func New(dir string, log logrus.FieldLogger) (*Cache, error) {
+	for _, subdir := range []string{blobsDir, urlsDir, tmpDir} {
+		err := os.MkdirAll(filepath.Join(dir, subdir), constants.SharedDirMask)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+	}
+	return &Cache{
+		dir:     dir,
+		log:     log.WithField("cache", dir),
+		fetches: make(map[string]*fetch),
+		entries: make(map[string]*Entry),
+	}, nil
+}
+
+// Get returns the cached file for fileUrl, fetching it on first use.
+// fileUrl is a local path, file://, s3://, gs:// or http(s):// URL with the optional
+// fragment #sha256=<checksum> that sets the expected checksum of the file.
+// s3:// and gs:// URLs are fetched with the aws CLI and gsutil, using the environment variables env.
+// Returns NotImplemented for URLs that cannot be cached, i.e. scp://
+func (r *Cache) Get(ctx context.Context, fileUrl string, env map[string]string) (*Entry, error) {
+	r.mu.Lock()
+	f, ok := r.fetches[fileUrl]
+	if !ok {
+		f = &fetch{done: make(chan struct{})}
+		r.fetches[fileUrl] = f
+	}
+	r.mu.Unlock()
+	if ok {
+		select {
+		case <-f.done:
+			return f.entry, trace.Wrap(f.err)
+		case <-ctx.Done():
+			return nil, trace.Wrap(ctx.Err())
+		}
+	}
+
+	f.entry, f.err = r.get(ctx, fileUrl, env)
+	r.mu.Lock()
+	if f.err != nil {
+		// the next user fetches again
+		delete(r.fetches, fileUrl)
+	} else {
+		r.entries[f.entry.Checksum] = f.entry
+	}
+	r.mu.Unlock()
+	close(f.done)
+	return f.entry, trace.Wrap(f.err)
+}
+
+// Lookup returns the file with the specified checksum fetched by this process
+func (r *Cache) Lookup(checksum string) (*Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[checksum]
+	if !ok {
+		return nil, trace.NotFound("no cached file with checksum %v", checksum)
+	}
+	return entry, nil
+}
+
+// fetch is a fetch of a URL, possibly in progress
+type fetch struct {
+	// done is closed once the fetch has completed
+	done  chan struct{}
+	entry *Entry
+	err   error
+}
+
+func (r *Cache) get(ctx context.Context, fileUrl string, env map[string]string) (*Entry, error) {
+	u, err := url.Parse(fileUrl)
+	if err != nil {
+		return nil, trace.BadParameter("invalid file URL %q: %v", fileUrl, err)
+	}
+	var checksum string
+	if u.Fragment != "" {
+		values, err := url.ParseQuery(u.Fragment)
+		if err != nil || values.Get(checksumFragment) == "" {
+			return nil, trace.BadParameter("unsupported fragment of %q: expected #%v=<checksum>",
+				fileUrl, checksumFragment)
+		}
+		checksum = strings.ToLower(values.Get(checksumFragment))
+		u.Fragment = ""
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		return r.local(u, checksum)
+	case "s3", "gs", "http", "https":
+	default:
+		return nil, trace.NotImplemented("%v URLs are not cached", u.Scheme)
+	}
+
+	log := r.log.WithField("url", u.String())
+	indexPath := r.indexPath(u.String())
+	if checksum != "" {
+		if entry, err := r.readIndex(indexPath); err == nil && checksum == entry.Checksum {
+			log.WithField("sha256", entry.Checksum).Debug("found in cache")
+			return entry, nil
+		}
+		if fi, err := os.Stat(r.blobPath(checksum)); err == nil {
+			// fetched before from another URL
+			return &Entry{
+				URL:      u.String(),
+				Name:     path.Base(u.Path),
+				Checksum: checksum,
+				Size:     fi.Size(),
+				Path:     r.blobPath(checksum),
+			}, nil
+		}
+	}
+
+	v, err := r.remoteVersion(ctx, u, env)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to query %v", u)
+	}
+	if entry, err := r.readIndex(indexPath); err == nil && checksum == "" && v.known() && v == entry.version {
+		log.WithField("sha256", entry.Checksum).Debug("found unchanged in cache")
+		return entry, nil
+	}
+
+	start := time.Now()
+	log.Info("fetching into cache")
+	entry, err := r.fetch(ctx, u, env, v, checksum, log)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	err = r.writeIndex(indexPath, entry)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	log.WithField("sha256", entry.Checksum).Infof("fetched %v into cache in %v",
+		humanize.Bytes(uint64(entry.Size)), time.Since(start))
+	return entry, nil
+}
+
+// fetch fetches the revision v of the remote file into the cache.
+// A partial download left by an earlier fetch is resumed if it is of the same revision,
+// and discarded if it cannot be resumed or the result does not match the checksum
+func (r *Cache) fetch(ctx context.Context, u *url.URL, env map[string]string, v version, checksum string, log logrus.FieldLogger) (*Entry, error) {
+	partPath := filepath.Join(r.dir, tmpDir, hash(u.String())+".part")
+	versionPath := partPath + ".json"
+	var partial version
+	if data, err := ioutil.ReadFile(versionPath); err != nil || json.Unmarshal(data, &partial) != nil ||
+		!v.known() || partial != v {
+		// the partial download might be of another revision
+		os.Remove(partPath)
+	}
+	_, err := os.Stat(partPath)
+	resumed := err == nil
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	err = ioutil.WriteFile(versionPath, data, constants.SharedReadMask)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+
+	entry, err := r.fetchPart(ctx, u, env, partPath, v, checksum)
+	if trace.IsCompareFailed(err) && resumed {
+		log.WithError(err).Warn("Discarding partial download.")
+		os.Remove(partPath)
+		entry, err = r.fetchPart(ctx, u, env, partPath, v, checksum)
+	}
+	if err != nil {
+		if trace.IsCompareFailed(err) {
+			os.Remove(partPath)
+		}
+		return nil, trace.Wrap(err)
+	}
+
+	entry.URL, entry.Name, entry.version = u.String(), path.Base(u.Path), v
+	entry.Path = r.blobPath(entry.Checksum)
+	err = os.Rename(partPath, entry.Path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	os.Remove(versionPath)
+	return entry, nil
+}
+
+// fetchPart fetches the remote file into partPath and verifies its checksum unless empty.
+// Returns CompareFailed if the file does not match the checksum
+// or the partial download in partPath cannot be resumed
+func (r *Cache) fetchPart(ctx context.Context, u *url.URL, env map[string]string, partPath string, v version, checksum string) (*Entry, error) {
+	var err error
+	switch u.Scheme {
+	case "s3":
+		_, err = r.command(ctx, env, "aws", "s3", "cp", "--only-show-errors", u.String(), partPath)
+	case "gs":
+		_, err = r.command(ctx, env, "gsutil", "-q", "cp", u.String(), partPath)
+	default:
+		err = download(ctx, u.String(), partPath, v)
+	}
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to fetch %v", u)
+	}
+
+	entry, err := fileEntry(partPath)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if checksum != "" && checksum != entry.Checksum {
+		return nil, trace.CompareFailed("SHA-256 checksum mismatch of %v: expected %v, got %v",
+			u, checksum, entry.Checksum)
+	}
+	return entry, nil
+}
+
+// remoteVersion returns the revision of the remote file as reported by
+// a HEAD request, aws s3api head-object or gsutil stat.
+// The revision is unknown if the server reports neither ETag nor modification time
+func (r *Cache) remoteVersion(ctx context.Context, u *url.URL, env map[string]string) (version, error) {
+	switch u.Scheme {
+	case "s3":
+		out, err := r.command(ctx, env, "aws", "s3api", "head-object", "--output", "json",
+			"--bucket", u.Host, "--key", strings.TrimPrefix(u.Path, "/"))
+		if err != nil {
+			return version{}, trace.Wrap(err)
+		}
+		var object struct {
+			ETag         string `json:"ETag"`
+			LastModified string `json:"LastModified"`
+		}
+		if err := json.Unmarshal(out, &object); err != nil {
+			return version{}, trace.BadParameter("unexpected output of aws s3api head-object: %s", out)
+		}
+		return version{ETag: object.ETag, LastModified: object.LastModified}, nil
+	case "gs":
+		out, err := r.command(ctx, env, "gsutil", "stat", u.String())
+		if err != nil {
+			return version{}, trace.Wrap(err)
+		}
+		return parseGsutilStat(out), nil
+	}
+
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return version{}, trace.Wrap(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return version{}, trace.ConnectionProblem(err, "failed to query %v", u)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return version{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}, nil
+	case http.StatusNotFound, http.StatusGone:
+		return version{}, trace.NotFound("%v not found", u)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return version{}, trace.AccessDenied("access to %v denied", u)
+	default:
+		// the server does not support HEAD requests
+		return version{}, nil
+	}
+}
+
+// parseGsutilStat returns the revision of the object from the output of gsutil stat
+func parseGsutilStat(out []byte) version {
+	var v version
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "ETag":
+			v.ETag = strings.TrimSpace(parts[1])
+		case "Update time":
+			v.LastModified = strings.TrimSpace(parts[1])
+		}
+	}
+	return v
+}
+
+// local returns the entry of the local file
+func (r *Cache) local(u *url.URL, checksum string) (*Entry, error) {
+	entry, err := fileEntry(u.Path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if checksum != "" && checksum != entry.Checksum {
+		return nil, trace.CompareFailed("SHA-256 checksum mismatch of %v: expected %v, got %v",
+			u.Path, checksum, entry.Checksum)
+	}
+	entry.URL, entry.Name, entry.Path = u.String(), filepath.Base(u.Path), u.Path
+	return entry, nil
+}
+
+// command runs the command that fetches or queries a file and returns its output
+func (r *Cache) command(ctx context.Context, env map[string]string, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, trace.NotFound("%v is not installed", name)
+	}
+	var envs []string
+	for k, v := range env {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	}
+	var out bytes.Buffer
+	err := system.Exec(exec.CommandContext(ctx, name, args...), &out, system.SetEnv(envs...))
+	if err != nil {
+		return nil, trace.Wrap(err, "%v failed: %s", name, bytes.TrimSpace(out.Bytes()))
+	}
+	return out.Bytes(), nil
+}
+
+func (r *Cache) readIndex(path string) (*Entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, trace.Wrap(err, "failed to read cache index %v", path)
+	}
+	entry.Path = r.blobPath(entry.Checksum)
+	fi, err := os.Stat(entry.Path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	if fi.Size() != entry.Size {
+		return nil, trace.CompareFailed("size of %v has changed", entry.Path)
+	}
+	return &entry, nil
+}
+
+func (r *Cache) writeIndex(path string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.ConvertSystemError(ioutil.WriteFile(path, data, constants.SharedReadMask))
+}
+
+// indexPath returns the location of the index record of the URL
+func (r *Cache) indexPath(fileUrl string) string {
+	return filepath.Join(r.dir, urlsDir, hash(fileUrl)+".json")
+}
+
+// blobPath returns the location of the file with the specified checksum
+func (r *Cache) blobPath(checksum string) string {
+	return filepath.Join(r.dir, blobsDir, checksum)
+}
+
+// download downloads the http(s) URL to path, resuming a previous partial download
+// unless the remote file is no longer of revision v
+func download(ctx context.Context, fileUrl, path string, v version) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, constants.SharedReadMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if offset != 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if v.ETag != "" {
+			req.Header.Set("If-Range", v.ETag)
+		} else if v.LastModified != "" {
+			req.Header.Set("If-Range", v.LastModified)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return trace.ConnectionProblem(err, "failed to download %v", fileUrl)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server does not support ranges or the file has changed
+		if err := f.Truncate(0); err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return trace.ConvertSystemError(err)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial download is as large as the file or larger,
+		// which cannot be told apart from a changed file
+		return trace.CompareFailed("cannot resume download of %v at %v bytes", fileUrl, offset)
+	case http.StatusNotFound, http.StatusGone:
+		return trace.NotFound("%v not found", fileUrl)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return trace.AccessDenied("access to %v denied", fileUrl)
+	default:
+		return trace.BadParameter("failed to download %v: %v", fileUrl, resp.Status)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return trace.ConnectionProblem(err, "failed to download %v", fileUrl)
+	}
+	return trace.ConvertSystemError(f.Close())
+}
+
+// fileEntry returns the entry with the checksum and size of the file at path
+func fileEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	return &Entry{Checksum: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	// blobsDir is the directory with the cached files named by checksum
+	blobsDir = "sha256"
+	// urlsDir is the directory with the index records of the fetched URLs
+	urlsDir = "urls"
+	// tmpDir is the directory with the files being fetched
+	tmpDir = "tmp"
+
+	// checksumFragment names the URL fragment parameter with the expected checksum
+	checksumFragment = "sha256"
+)
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachesDownloads(t *testing.T) {
+	const content = "installer"
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&requests, 1)
+		}
+		http.ServeContent(w, r, "installer.tar", time.Time{}, strings.NewReader(content))
+	}))
+	fileUrl := srv.URL + "/builds/installer.tar#sha256=" + checksumOf(content)
+
+	dir, err := ioutil.TempDir("", "robotest-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := New(dir, testLog())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	entries := make([]*Entry, 4)
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry, err := c.Get(context.TODO(), fileUrl, nil)
+			require.NoError(t, err)
+			entries[i] = entry
+		}(i)
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests), "concurrent fetches of a URL are deduplicated")
+	for _, entry := range entries {
+		require.Equal(t, entries[0], entry)
+	}
+	require.Equal(t, "installer.tar", entries[0].Name)
+	require.Equal(t, checksumOf(content), entries[0].Checksum)
+	requireContent(t, entries[0].Path, content)
+
+	lookup, err := c.Lookup(entries[0].Checksum)
+	require.NoError(t, err)
+	require.Equal(t, entries[0], lookup)
+
+	// a later run finds the installer without the server
+	srv.Close()
+	c, err = New(dir, testLog())
+	require.NoError(t, err)
+	entry, err := c.Get(context.TODO(), fileUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, entries[0], entry)
+}
+
+func TestRevalidatesURLsWithoutChecksum(t *testing.T) {
+	var mu sync.Mutex
+	content, etag := "installer", `"1"`
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&requests, 1)
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "installer.tar", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "robotest-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := New(dir, testLog())
+	require.NoError(t, err)
+
+	entry, err := c.Get(context.TODO(), srv.URL+"/installer.tar", nil)
+	require.NoError(t, err)
+	require.Equal(t, checksumOf("installer"), entry.Checksum)
+	// later runs revalidate the file
+	c, err = New(dir, testLog())
+	require.NoError(t, err)
+	entry, err = c.Get(context.TODO(), srv.URL+"/installer.tar", nil)
+	require.NoError(t, err)
+	require.Equal(t, checksumOf("installer"), entry.Checksum)
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests), "unchanged file is served from the cache")
+
+	mu.Lock()
+	content, etag = "updated installer", `"2"`
+	mu.Unlock()
+	c, err = New(dir, testLog())
+	require.NoError(t, err)
+	entry, err = c.Get(context.TODO(), srv.URL+"/installer.tar", nil)
+	require.NoError(t, err)
+	require.Equal(t, checksumOf("updated installer"), entry.Checksum)
+	requireContent(t, entry.Path, "updated installer")
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
+
+func TestDiscardsStalePartialDownloads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1"`)
+		http.ServeContent(w, r, "installer.tar", time.Time{}, strings.NewReader("installer"))
+	}))
+	defer srv.Close()
+	fileUrl := srv.URL + "/installer.tar#sha256=" + checksumOf("installer")
+
+	var testCases = []struct {
+		comment string
+		part    string
+		version string
+	}{
+		{
+			comment: "Partial download of another revision",
+			part:    "inst",
+			version: `{"etag":"\"0\""}`,
+		},
+		{
+			comment: "Partial download larger than the file",
+			part:    "corrupted installer",
+			version: `{"etag":"\"1\""}`,
+		},
+		{
+			comment: "Corrupted partial download",
+			part:    "corr",
+			version: `{"etag":"\"1\""}`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		dir, err := ioutil.TempDir("", "robotest-cache")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		c, err := New(dir, testLog())
+		require.NoError(t, err)
+		partPath := filepath.Join(dir, tmpDir, hash(srv.URL+"/installer.tar")+".part")
+		require.NoError(t, ioutil.WriteFile(partPath, []byte(testCase.part), 0644))
+		require.NoError(t, ioutil.WriteFile(partPath+".json", []byte(testCase.version), 0644))
+
+		entry, err := c.Get(context.TODO(), fileUrl, nil)
+		require.NoError(t, err, testCase.comment)
+		requireContent(t, entry.Path, "installer")
+		_, err = os.Stat(partPath)
+		require.True(t, os.IsNotExist(err), testCase.comment)
+	}
+}
+
+func TestRejectsChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "installer.tar", time.Time{}, strings.NewReader("corrupted"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "robotest-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := New(dir, testLog())
+	require.NoError(t, err)
+
+	_, err = c.Get(context.TODO(), srv.URL+"/installer.tar#sha256="+checksumOf("installer"), nil)
+	require.True(t, trace.IsCompareFailed(err), "expected checksum mismatch but got %v", err)
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, blobsDir))
+	require.NoError(t, err)
+	require.Empty(t, blobs)
+	_, err = c.Lookup(checksumOf("corrupted"))
+	require.True(t, trace.IsNotFound(err))
+}
+
+func TestUsesLocalFilesInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "installer.tar")
+	require.NoError(t, ioutil.WriteFile(path, []byte("installer"), 0644))
+	c, err := New(filepath.Join(dir, "cache"), testLog())
+	require.NoError(t, err)
+
+	for _, fileUrl := range []string{path, "file://" + path} {
+		entry, err := c.Get(context.TODO(), fileUrl, nil)
+		require.NoError(t, err, fileUrl)
+		require.Equal(t, path, entry.Path)
+		require.Equal(t, "installer.tar", entry.Name)
+		require.Equal(t, checksumOf("installer"), entry.Checksum)
+	}
+
+	_, err = c.Get(context.TODO(), path+"#sha256="+checksumOf("other"), nil)
+	require.True(t, trace.IsCompareFailed(err), "expected checksum mismatch but got %v", err)
+	_, err = c.Get(context.TODO(), "scp://10.0.0.1/installer.tar", nil)
+	require.True(t, trace.IsNotImplemented(err), "expected scp:// URLs not to be cached but got %v", err)
+}
+
+func TestMirrorServesCachedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "installer.tar")
+	require.NoError(t, ioutil.WriteFile(path, []byte("installer"), 0644))
+	c, err := New(filepath.Join(dir, "cache"), testLog())
+	require.NoError(t, err)
+	entry, err := c.Get(context.TODO(), path, nil)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewMirror(c, testLog()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + entry.Checksum + "/installer.tar")
+	require.NoError(t, err)
+	requireResponse(t, resp, http.StatusOK, "installer")
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/"+entry.Checksum+"/installer.tar", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=5-")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	requireResponse(t, resp, http.StatusPartialContent, "ller")
+
+	for _, p := range []string{"/" + checksumOf("other") + "/installer.tar", "/" + entry.Checksum + "/other.tar", "/installer.tar"} {
+		resp, err = http.Get(srv.URL + p)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, p)
+	}
+}
+
+func requireResponse(t *testing.T, resp *http.Response, status int, content string) {
+	defer resp.Body.Close()
+	require.Equal(t, status, resp.StatusCode)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+}
+
+func requireContent(t *testing.T, path, content string) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+}
+
+func checksumOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func testLog() logrus.FieldLogger {
+	log := logrus.New()
+	log.Level = logrus.DebugLevel
+	return log
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gravitational/trace"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Mirror serves the files of the cache over HTTP to remote hosts.
+// Each remote host reaches the mirror on its loopback interface
+// through an SSH reverse tunnel, so nodes need no route to the runner
+type Mirror struct {
+	cache *Cache
+	log   logrus.FieldLogger
+
+	mu sync.Mutex
+	// listeners maps SSH clients to the listeners of their reverse tunnels
+	listeners map[*ssh.Client]net.Listener
+}
+
+// NewMirror returns a new mirror of the cache
+func NewMirror(cache *Cache, log logrus.FieldLogger) *Mirror {
+	return &Mirror{
+		cache:     cache,
+		log:       log,
+		listeners: make(map[*ssh.Client]net.Listener),
+	}
+}
+
+// URL returns the URL of the cached file on the remote host of client.
+// The reverse tunnel is opened on first use and lives as long as the client.
+// The URL carries the checksum of the file in its fragment
+func (r *Mirror) URL(client *ssh.Client, entry *Entry) (string, error) {
+	listener, err := r.listener(client)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return fmt.Sprintf("http://%v/%v/%v#%v=%v", listener.Addr(), entry.Checksum, entry.Name,
+		checksumFragment, entry.Checksum), nil
+}
+
+// Close closes all reverse tunnels
+func (r *Mirror) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for client, listener := range r.listeners {
+		listener.Close()
+		delete(r.listeners, client)
+	}
+}
+
+func (r *Mirror) listener(client *ssh.Client) (net.Listener, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if listener, ok := r.listeners[client]; ok {
+		return listener, nil
+	}
+	listener, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to open reverse tunnel, is TCP forwarding allowed by the SSH server?")
+	}
+	r.listeners[client] = listener
+	log := r.log.WithFields(logrus.Fields{"host": client.RemoteAddr(), "addr": listener.Addr()})
+	log.Debug("opened reverse tunnel")
+	go func() {
+		err := http.Serve(listener, r)
+		log.WithError(err).Debug("reverse tunnel closed")
+		r.mu.Lock()
+		if r.listeners[client] == listener {
+			delete(r.listeners, client)
+		}
+		r.mu.Unlock()
+	}()
+	return listener, nil
+}
+
+// ServeHTTP serves the cached file with the checksum and name from the path /<checksum>/<name>.
+// Range requests are supported so interrupted downloads resume
+func (r *Mirror) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	entry, err := r.cache.Lookup(parts[0])
+	if err != nil || entry.Name != parts[1] {
+		http.NotFound(w, req)
+		return
+	}
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		r.log.WithError(err).Warn("failed to open cached file")
+		http.Error(w, "failed to open cached file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to open cached file", http.StatusInternalServerError)
+		return
+	}
+	r.log.WithFields(logrus.Fields{"file": entry.Name, "range": req.Header.Get("Range")}).Debug("serve cached file")
+	http.ServeContent(w, req, entry.Name, fi.ModTime(), f)
+}
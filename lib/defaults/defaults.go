@@ -20,6 +20,18 @@ const (
 
 	// SSHConnectTimeout defines the timeout for establishing an SSH connection
 	SSHConnectTimeout = 30 * time.Second
+	// SSHKeepAliveInterval defines the interval between keepalives of cached SSH connections
+	SSHKeepAliveInterval = 30 * time.Second
+	// SSHKeepAliveCountMax defines the number of keepalives in a row left unanswered
+	// after which an SSH connection is considered dead
+	SSHKeepAliveCountMax = 3
+	// SSHReconnectAttempts defines the maximum number of attempts to reestablish a lost SSH connection
+	SSHReconnectAttempts = 5
+
+	// TransferAttempts defines the maximum number of attempts to transfer a file
+	TransferAttempts = 5
+	// TransferProgressInterval defines the interval between progress reports of file transfers
+	TransferProgressInterval = 10 * time.Second
 
 	// MinDiskSpeed is minimum write performance
 	MinDiskSpeed = uint64(1e7)
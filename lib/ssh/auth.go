@@ -0,0 +1,215 @@
+package sshutils
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthConfig defines how to authenticate SSH connections.
+// At least one of the private key, SSH agent or password is required
+type AuthConfig struct {
+	// KeyPath is the location of the SSH private key
+	KeyPath string
+	// KeyPassphrase decrypts the private key if it is encrypted
+	KeyPassphrase string
+	// CertPath is the location of the OpenSSH certificate issued for the private key
+	CertPath string
+	// Agent enables authentication with the keys of the SSH agent
+	// listening on SSH_AUTH_SOCK
+	Agent bool
+	// Password enables password authentication
+	Password string
+}
+
+// Auth authenticates SSH connections.
+// The private key is loaded on first use and reused for all connections.
+// The certificate and the private key are offered before the keys of the SSH agent,
+// followed by the password
+type Auth struct {
+	config AuthConfig
+
+	mu sync.Mutex
+	// signers are the signers of the private key, loaded on first use
+	signers []ssh.Signer
+	// agent is the client of the SSH agent, connected on first use
+	agent     agent.Agent
+	agentConn net.Conn
+}
+
+// NewAuth returns the authentication for the specified configuration
+func NewAuth(config AuthConfig) *Auth {
+	return &Auth{config: config}
+}
+
+// KeyAuth returns the authentication with the SSH private key read from keyInput
+func KeyAuth(keyInput io.Reader) (*Auth, error) {
+	keyBytes, err := ioutil.ReadAll(keyInput)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, err := parsePrivateKey(keyBytes, "")
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Auth{signers: []ssh.Signer{signer}}, nil
+}
+
+// methods returns the methods to authenticate with
+func (r *Auth) methods() ([]ssh.AuthMethod, error) {
+	if r == nil {
+		return nil, trace.BadParameter("SSH authentication is required")
+	}
+	// fail early on a missing or invalid key rather than during the handshake
+	signers, err := r.keySigners()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var methods []ssh.AuthMethod
+	// all public keys are offered within a single method as the client
+	// does not retry a method of the same kind
+	if len(signers) != 0 || r.config.Agent {
+		methods = append(methods, ssh.PublicKeysCallback(r.publicKeySigners))
+	}
+	if r.config.Password != "" {
+		methods = append(methods,
+			ssh.Password(r.config.Password),
+			ssh.KeyboardInteractive(r.answerPassword))
+	}
+	if len(methods) == 0 {
+		return nil, trace.BadParameter("no SSH authentication method configured: " +
+			"either private key, SSH agent or password is required")
+	}
+	return methods, nil
+}
+
+// publicKeySigners returns the signers of the private key and of the SSH agent
+func (r *Auth) publicKeySigners() ([]ssh.Signer, error) {
+	signers, err := r.keySigners()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if !r.config.Agent {
+		return signers, nil
+	}
+	agentSigners, err := r.agentSigners()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return append(signers, agentSigners...), nil
+}
+
+// keySigners returns the signers of the private key and its certificate
+func (r *Auth) keySigners() ([]ssh.Signer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signers != nil || r.config.KeyPath == "" {
+		return r.signers, nil
+	}
+
+	keyBytes, err := ioutil.ReadFile(r.config.KeyPath)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	signer, err := parsePrivateKey(keyBytes, r.config.KeyPassphrase)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to parse SSH key %v", r.config.KeyPath)
+	}
+	signers := []ssh.Signer{signer}
+	if r.config.CertPath != "" {
+		certSigner, err := newCertSigner(r.config.CertPath, signer)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		signers = []ssh.Signer{certSigner, signer}
+	}
+	r.signers = signers
+	return r.signers, nil
+}
+
+// agentSigners returns the signers of the SSH agent.
+// The agent is reconnected to if the connection has been lost
+func (r *Auth) agentSigners() ([]ssh.Signer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.agent != nil {
+		signers, err := r.agent.Signers()
+		if err == nil {
+			return signers, nil
+		}
+		r.agentConn.Close()
+		r.agent, r.agentConn = nil, nil
+	}
+
+	sock := os.Getenv(agentSockEnv)
+	if sock == "" {
+		return nil, trace.BadParameter("SSH agent is not available: %v is not set", agentSockEnv)
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to SSH agent at %v", sock)
+	}
+	r.agent, r.agentConn = agent.NewClient(conn), conn
+	signers, err := r.agent.Signers()
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list keys of SSH agent")
+	}
+	return signers, nil
+}
+
+// answerPassword answers the keyboard-interactive prompts
+// of servers that do not accept password authentication directly
+func (r *Auth) answerPassword(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i := range questions {
+		if !echos[i] {
+			answers[i] = r.config.Password
+		}
+	}
+	return answers, nil
+}
+
+// parsePrivateKey parses the PEM-encoded private key
+// decrypting it with passphrase unless empty
+func parsePrivateKey(keyBytes []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+		return signer, trace.Wrap(err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, trace.BadParameter("SSH key is encrypted: key passphrase is required")
+	}
+	return signer, trace.Wrap(err)
+}
+
+// newCertSigner returns the signer that authenticates with the OpenSSH certificate
+// at certPath issued for the key of signer
+func newCertSigner(certPath string, signer ssh.Signer) (ssh.Signer, error) {
+	certBytes, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to parse SSH certificate %v", certPath)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, trace.BadParameter("%v is not an SSH certificate", certPath)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, trace.Wrap(err, "SSH certificate %v does not match the SSH key", certPath)
+	}
+	return certSigner, nil
+}
+
+// agentSockEnv names the environment variable with the socket of the SSH agent
+const agentSockEnv = "SSH_AUTH_SOCK"
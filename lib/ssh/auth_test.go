@@ -0,0 +1,143 @@
+package sshutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadsEncryptedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := newPrivateKey(t)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY",
+		x509.MarshalPKCS1PrivateKey(key), []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+	keyPath := writeFile(t, dir, "id_rsa", pem.EncodeToMemory(block))
+
+	_, err = NewAuth(AuthConfig{KeyPath: keyPath}).methods()
+	require.True(t, trace.IsBadParameter(err), "requires passphrase: %v", err)
+
+	auth := NewAuth(AuthConfig{KeyPath: keyPath, KeyPassphrase: "secret"})
+	signers, err := auth.publicKeySigners()
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	requireSameKey(t, &key.PublicKey, signers[0].PublicKey())
+}
+
+func TestOffersCertificateBeforeKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, caKey := newPrivateKey(t), newPrivateKey(t)
+	keyPath := writeFile(t, dir, "id_rsa", pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	require.NoError(t, err)
+	cert := &ssh.Certificate{
+		Key:             publicKey,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"robotest"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, caSigner))
+	certPath := writeFile(t, dir, "id_rsa-cert.pub", ssh.MarshalAuthorizedKey(cert))
+
+	signers, err := NewAuth(AuthConfig{KeyPath: keyPath, CertPath: certPath}).publicKeySigners()
+	require.NoError(t, err)
+	require.Len(t, signers, 2)
+	require.Equal(t, cert.Type(), signers[0].PublicKey().Type())
+	requireSameKey(t, &key.PublicKey, signers[1].PublicKey())
+
+	otherCertPath := writeFile(t, dir, "other-cert.pub", ssh.MarshalAuthorizedKey(caSigner.PublicKey()))
+	_, err = NewAuth(AuthConfig{KeyPath: keyPath, CertPath: otherCertPath}).methods()
+	require.True(t, trace.IsBadParameter(err), "rejects plain key as certificate: %v", err)
+}
+
+func TestUsesAgentKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := newPrivateKey(t)
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	oldSock := os.Getenv(agentSockEnv)
+	defer os.Setenv(agentSockEnv, oldSock)
+	require.NoError(t, os.Setenv(agentSockEnv, sock))
+
+	auth := NewAuth(AuthConfig{Agent: true})
+	methods, err := auth.methods()
+	require.NoError(t, err)
+	require.Len(t, methods, 1)
+	signers, err := auth.publicKeySigners()
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	requireSameKey(t, &key.PublicKey, signers[0].PublicKey())
+
+	// lost agent connection is reestablished
+	auth.agentConn.Close()
+	signers, err = auth.publicKeySigners()
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+}
+
+func TestRequiresAuthMethod(t *testing.T) {
+	_, err := NewAuth(AuthConfig{}).methods()
+	require.True(t, trace.IsBadParameter(err), "expected bad parameter but got %v", err)
+
+	methods, err := NewAuth(AuthConfig{Password: "secret"}).methods()
+	require.NoError(t, err)
+	require.Len(t, methods, 2, "password and keyboard-interactive")
+}
+
+func newPrivateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	return key
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func requireSameKey(t *testing.T, expected *rsa.PublicKey, key ssh.PublicKey) {
+	expectedKey, err := ssh.NewPublicKey(expected)
+	require.NoError(t, err)
+	require.Equal(t, expectedKey.Marshal(), key.Marshal())
+}
@@ -0,0 +1,243 @@
+package sshutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gravitational/robotest/lib/defaults"
+	"github.com/gravitational/robotest/lib/wait"
+	"github.com/gravitational/trace"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// DialFunc establishes a new SSH connection, i.e. infra.Node.Client
+type DialFunc func() (*ssh.Client, error)
+
+// ClientManager caches a single SSH client per host.
+// Cached clients are probed with keepalives and redialed transparently
+// with backoff once their transport is found dead.
+// Cached clients are shared and must not be closed by callers
+type ClientManager struct {
+	log logrus.FieldLogger
+
+	// keepAliveInterval defines the interval between keepalives
+	keepAliveInterval time.Duration
+	// keepAliveCountMax defines the number of keepalives in a row
+	// left unanswered after which the client is closed
+	keepAliveCountMax int
+	// retry defines how to redial lost connections
+	retry wait.Retryer
+
+	mu      sync.Mutex
+	clients map[string]*managedClient
+}
+
+// NewClientManager returns a new manager of SSH clients
+func NewClientManager(log logrus.FieldLogger) *ClientManager {
+	return &ClientManager{
+		log:               log,
+		keepAliveInterval: defaults.SSHKeepAliveInterval,
+		keepAliveCountMax: defaults.SSHKeepAliveCountMax,
+		retry: wait.Retryer{
+			Delay:       defaults.RetryDelay,
+			Attempts:    defaults.SSHReconnectAttempts,
+			FieldLogger: log,
+		},
+		clients: make(map[string]*managedClient),
+	}
+}
+
+// Client returns the client connected to the host identified with key.
+// A new client is dialed with dial if there is none or the existing one is dead
+func (r *ClientManager) Client(ctx context.Context, key string, dial DialFunc) (*ssh.Client, error) {
+	client, err := r.get(key).client(ctx, dial)
+	return client, trace.Wrap(err)
+}
+
+// Session returns a new session of the client connected to the host identified with key.
+// A client failing to open a session is considered dead and is redialed once
+func (r *ClientManager) Session(ctx context.Context, key string, dial DialFunc) (*ssh.Session, error) {
+	managed := r.get(key)
+	client, err := managed.client(ctx, dial)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	managed.log.WithError(err).Debug("failed to open SSH session, reconnecting")
+	managed.invalidate(client)
+	client, err = managed.client(ctx, dial)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return session, nil
+}
+
+// Forget closes the client connected to the host identified with key
+// and removes it from the cache
+func (r *ClientManager) Forget(key string) {
+	r.mu.Lock()
+	managed, ok := r.clients[key]
+	delete(r.clients, key)
+	r.mu.Unlock()
+	if ok {
+		managed.close()
+	}
+}
+
+// Close closes all cached clients
+func (r *ClientManager) Close() {
+	r.mu.Lock()
+	clients := r.clients
+	r.clients = make(map[string]*managedClient)
+	r.mu.Unlock()
+	for _, managed := range clients {
+		managed.close()
+	}
+}
+
+func (r *ClientManager) get(key string) *managedClient {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	managed, ok := r.clients[key]
+	if !ok {
+		managed = &managedClient{
+			manager: r,
+			log:     r.log.WithField("host", key),
+		}
+		r.clients[key] = managed
+	}
+	return managed
+}
+
+// managedClient maintains the SSH client of a single host
+type managedClient struct {
+	manager *ClientManager
+	log     logrus.FieldLogger
+
+	// mu serializes dialing so concurrent users share a single client
+	mu      sync.Mutex
+	current *ssh.Client
+	// done is closed once the transport of the current client is dead
+	done chan struct{}
+}
+
+// client returns the current client unless it is dead or dials a new one
+func (r *managedClient) client(ctx context.Context, dial DialFunc) (*ssh.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		if !isClosed(r.done) {
+			return r.current, nil
+		}
+		r.log.Info("SSH connection lost, reconnecting")
+	}
+
+	var client *ssh.Client
+	retry := r.manager.retry
+	retry.FieldLogger = r.log
+	err := retry.Do(ctx, func() (err error) {
+		client, err = dial()
+		if trace.IsCompareFailed(err) {
+			// host key mismatch will not go away with retries
+			return wait.Abort(trace.Wrap(err))
+		}
+		return trace.Wrap(err)
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	done := make(chan struct{})
+	r.current, r.done = client, done
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	go r.keepAlive(client, done)
+	return client, nil
+}
+
+// invalidate closes client if it is still the current one
+// so the next user redials
+func (r *managedClient) invalidate(client *ssh.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == client {
+		client.Close()
+	}
+}
+
+func (r *managedClient) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+}
+
+// keepAlive probes the transport of client until it is closed
+// and closes the client once keepalives go unanswered
+func (r *managedClient) keepAlive(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(r.manager.keepAliveInterval)
+	defer ticker.Stop()
+	var missed int
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := sendKeepAlive(client, r.manager.keepAliveInterval)
+		if err == nil {
+			missed = 0
+			continue
+		}
+		missed++
+		if missed >= r.manager.keepAliveCountMax {
+			r.log.WithError(err).Warnf("no response to %v keepalives, closing SSH connection", missed)
+			client.Close()
+			return
+		}
+	}
+}
+
+// sendKeepAlive sends a keepalive request and waits for the reply for up to timeout.
+// Servers that do not recognize the request reply with a failure
+// which still proves the transport alive
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return trace.Wrap(err)
+	case <-time.After(timeout):
+		return trace.LimitExceeded("keepalive timed out after %v", timeout)
+	}
+}
+
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// keepAliveRequest names the global request sent as keepalive, as OpenSSH does
+const keepAliveRequest = "keepalive@openssh.com"
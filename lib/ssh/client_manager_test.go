@@ -0,0 +1,73 @@
+package sshutils
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/lib/ssh/sshtest"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCachesClients(t *testing.T) {
+	manager := newTestClientManager()
+	defer manager.Close()
+	server, err := sshtest.NewServer(nil)
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := manager.Client(context.TODO(), "node-1", server.Dial)
+	require.NoError(t, err)
+	cached, err := manager.Client(context.TODO(), "node-1", server.Dial)
+	require.NoError(t, err)
+	require.True(t, client == cached, "expected cached client")
+	require.EqualValues(t, 1, server.Dials())
+
+	// lost connection is redialed
+	server.CloseConns()
+	require.NoError(t, waitClosed(client))
+	// let the manager observe the closed transport
+	time.Sleep(100 * time.Millisecond)
+	redialed, err := manager.Client(context.TODO(), "node-1", server.Dial)
+	require.NoError(t, err)
+	require.False(t, client == redialed, "expected new client")
+	require.EqualValues(t, 2, server.Dials())
+
+	manager.Forget("node-1")
+	require.NoError(t, waitClosed(redialed))
+}
+
+func TestClosesUnresponsiveClients(t *testing.T) {
+	manager := newTestClientManager()
+	defer manager.Close()
+	server, err := sshtest.NewServer(nil, sshtest.IgnoreKeepAlives())
+	require.NoError(t, err)
+	defer server.Close()
+
+	client, err := manager.Client(context.TODO(), "node-1", server.Dial)
+	require.NoError(t, err)
+	require.NoError(t, waitClosed(client), "expected client closed after missed keepalives")
+}
+
+func TestDoesNotRetryHostKeyMismatch(t *testing.T) {
+	manager := newTestClientManager()
+	var dials int32
+	_, err := manager.Client(context.TODO(), "node-1", func() (*ssh.Client, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, trace.CompareFailed("host key has changed")
+	})
+	require.True(t, trace.IsCompareFailed(err), "expected compare failed but got %v", err)
+	require.EqualValues(t, 1, dials)
+}
+
+func newTestClientManager() *ClientManager {
+	manager := NewClientManager(logrus.NewEntry(logrus.StandardLogger()))
+	manager.keepAliveInterval = 20 * time.Millisecond
+	manager.retry.Delay = time.Millisecond
+	return manager
+}
@@ -0,0 +1,304 @@
+package sshutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Result describes the outcome of a remote command
+type Result struct {
+	// Command is the command as executed, without the environment
+	// which might contain secrets
+	Command string
+	// Stdout is the standard output of the command unless redirected with Stdout
+	Stdout []byte
+	// Stderr is the standard error of the command unless redirected with Stderr
+	Stderr []byte
+	// ExitStatus is the exit status of the command or -1 if it is unknown.
+	// Commands terminated by a signal exit with 128 + signal number
+	ExitStatus int
+	// Signal names the signal that terminated the command, i.e. TERM
+	Signal string
+	// Duration is how long the command has been running
+	Duration time.Duration
+}
+
+// ExecOptionSetter configures remote command execution
+type ExecOptionSetter func(config *execConfig)
+
+// Env sets the environment variables of the command.
+// Variables are passed on the command line as servers
+// rarely accept them with the AcceptEnv directive
+func Env(env map[string]string) ExecOptionSetter {
+	return func(config *execConfig) {
+		config.env = env
+	}
+}
+
+// Stdin provides the standard input of the command
+func Stdin(r io.Reader) ExecOptionSetter {
+	return func(config *execConfig) {
+		config.stdin = r
+	}
+}
+
+// Stdout streams the standard output of the command to w instead of capturing it
+func Stdout(w io.Writer) ExecOptionSetter {
+	return func(config *execConfig) {
+		config.stdout = w
+	}
+}
+
+// Stderr streams the standard error of the command to w instead of capturing it
+func Stderr(w io.Writer) ExecOptionSetter {
+	return func(config *execConfig) {
+		config.stderr = w
+	}
+}
+
+// Unlogged disables logging of the command output, i.e. of file contents streamed with Stdout.
+// By default, both streams are logged at debug level
+func Unlogged() ExecOptionSetter {
+	return func(config *execConfig) {
+		config.unlogged = true
+	}
+}
+
+// PTY runs the command in a pseudo-terminal, i.e. for commands that require a TTY.
+// With a PTY, the command's standard error is merged into its standard output
+func PTY() ExecOptionSetter {
+	return func(config *execConfig) {
+		config.pty = true
+	}
+}
+
+// Timeout limits the duration of the command
+func Timeout(timeout time.Duration) ExecOptionSetter {
+	return func(config *execConfig) {
+		config.timeout = timeout
+	}
+}
+
+type execConfig struct {
+	env            map[string]string
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	pty            bool
+	timeout        time.Duration
+	unlogged       bool
+}
+
+// Exec runs the command on the remote host and returns its result.
+// The result is returned along with errors once the command has been started.
+// Returns StartError if the command could not be started,
+// CanceledError if the command was terminated as the context expired
+// and ExitError if the command exited with non-zero status or was terminated by a signal
+func Exec(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, command string, opts ...ExecOptionSetter) (*Result, error) {
+	var config execConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+
+	result := &Result{
+		Command:    command,
+		ExitStatus: ExitStatusUndefined,
+	}
+	log = log.WithField("cmd", command)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, trace.Wrap(&StartError{Command: command, Err: err})
+	}
+	defer session.Close()
+
+	if config.pty {
+		err = session.RequestPty("xterm", ptyHeight, ptyWidth, ssh.TerminalModes{ssh.ECHO: 0})
+		if err != nil {
+			return nil, trace.Wrap(&StartError{Command: command, Err: err})
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = config.stdin
+	if session.Stdin == nil {
+		session.Stdin = new(bytes.Buffer)
+	}
+	session.Stdout = config.output(config.stdout, &stdout, log.WithField("stream", "stdout"))
+	session.Stderr = config.output(config.stderr, &stderr, log.WithField("stream", "stderr"))
+
+	log.Debug(command)
+	start := time.Now()
+	err = session.Start(withEnv(command, config.env))
+	if err != nil {
+		return nil, trace.Wrap(&StartError{Command: command, Err: err})
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- session.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+		result.Duration = time.Since(start)
+		log.WithError(ctx.Err()).Debug("context terminated, sent SIGTERM")
+		return result, trace.Wrap(&CanceledError{Result: result, Err: ctx.Err()})
+	case err = <-errCh:
+	}
+
+	result.Duration = time.Since(start)
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	switch exitErr := err.(type) {
+	case nil:
+		result.ExitStatus = 0
+		return result, nil
+	case *ssh.ExitError:
+		result.ExitStatus = exitErr.ExitStatus()
+		result.Signal = exitErr.Signal()
+		log.WithField("exit", result.ExitStatus).Debug("command failed")
+		return result, trace.Wrap(&ExitError{Result: result})
+	case *ssh.ExitMissingError:
+		return result, trace.ConnectionProblem(err, "%q exited without status, connection lost?", command)
+	default:
+		return result, trace.Wrap(err)
+	}
+}
+
+// StartError is returned by Exec if the command could not be started
+type StartError struct {
+	// Command is the command that could not be started
+	Command string
+	// Err is the original error
+	Err error
+}
+
+// Error returns the text of the error
+func (r *StartError) Error() string {
+	return fmt.Sprintf("failed to start %q: %v", r.Command, r.Err)
+}
+
+// ExitError is returned by Exec if the command exited with non-zero status
+// or was terminated by a signal
+type ExitError struct {
+	*Result
+}
+
+// Error returns the text of the error including the tail of the command's standard error
+func (r *ExitError) Error() string {
+	var msg string
+	if r.Signal != "" {
+		msg = fmt.Sprintf("%q terminated by signal %v", r.Command, r.Signal)
+	} else {
+		msg = fmt.Sprintf("%q exited with status %v", r.Command, r.ExitStatus)
+	}
+	if stderr := tail(r.Stderr, maxErrorOutput); stderr != "" {
+		msg = fmt.Sprintf("%v: %v", msg, stderr)
+	}
+	return msg
+}
+
+// CanceledError is returned by Exec if the command was terminated
+// as the context expired
+type CanceledError struct {
+	*Result
+	// Err is the error of the context
+	Err error
+}
+
+// Error returns the text of the error
+func (r *CanceledError) Error() string {
+	return fmt.Sprintf("%q terminated after %v: %v", r.Command, r.Duration, r.Err)
+}
+
+// IsStartError returns true if err is a StartError
+func IsStartError(err error) bool {
+	_, ok := trace.Unwrap(err).(*StartError)
+	return ok
+}
+
+// IsExitError returns true if err is an ExitError
+func IsExitError(err error) bool {
+	_, ok := trace.Unwrap(err).(*ExitError)
+	return ok
+}
+
+// IsCanceledError returns true if err is a CanceledError
+func IsCanceledError(err error) bool {
+	_, ok := trace.Unwrap(err).(*CanceledError)
+	return ok
+}
+
+// withEnv prefixes command with the environment variables sorted by name
+func withEnv(command string, env map[string]string) string {
+	if len(env) == 0 {
+		return command
+	}
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(vars)
+	return fmt.Sprintf("%s %s", strings.Join(vars, " "), command)
+}
+
+// output returns the writer of a command output stream: w if set, otherwise buf.
+// The output is also logged unless disabled with Unlogged
+func (r execConfig) output(w io.Writer, buf *bytes.Buffer, log logrus.FieldLogger) io.Writer {
+	if w == nil {
+		w = buf
+	}
+	if r.unlogged {
+		return w
+	}
+	return io.MultiWriter(w, &logWriter{log})
+}
+
+// tail returns the last lines of output up to max bytes
+func tail(output []byte, max int) string {
+	output = bytes.TrimSpace(output)
+	if len(output) > max {
+		output = output[len(output)-max:]
+		if i := bytes.IndexByte(output, '\n'); i >= 0 {
+			output = output[i+1:]
+		}
+	}
+	return string(output)
+}
+
+// logWriter logs the output of remote commands
+type logWriter struct {
+	log logrus.FieldLogger
+}
+
+func (r *logWriter) Write(p []byte) (int, error) {
+	r.log.Debug(string(p))
+	return len(p), nil
+}
+
+const (
+	// ExitStatusUndefined is the exit status of commands that have not exited
+	ExitStatusUndefined = -1
+
+	// maxErrorOutput limits the standard error quoted in errors
+	maxErrorOutput = 512
+
+	ptyWidth  = 80
+	ptyHeight = 40
+)
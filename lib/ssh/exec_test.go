@@ -0,0 +1,100 @@
+package sshutils
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/lib/ssh/sshtest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExecCapturesOutput(t *testing.T) {
+	client, cleanup := newExecClient(t)
+	defer cleanup()
+
+	result, err := Exec(context.TODO(), client, testLog(), "echo hello")
+	require.NoError(t, err)
+	require.Equal(t, "echo hello", result.Command)
+	require.Equal(t, "hello\n", string(result.Stdout))
+	require.Equal(t, 0, result.ExitStatus)
+
+	result, err = Exec(context.TODO(), client, testLog(), "cat", Stdin(strings.NewReader("input")))
+	require.NoError(t, err)
+	require.Equal(t, "input", string(result.Stdout))
+
+	var out string
+	exitStatus, err := RunAndParse(context.TODO(), client, testLog(), "echo hello", nil, ParseAsString(&out))
+	require.NoError(t, err)
+	require.Equal(t, 0, exitStatus)
+	require.Equal(t, "hello\n", out)
+}
+
+func TestExecReportsFailures(t *testing.T) {
+	client, cleanup := newExecClient(t)
+	defer cleanup()
+
+	result, err := Exec(context.TODO(), client, testLog(), "false")
+	require.True(t, IsExitError(err), "expected exit error but got %v", err)
+	require.Equal(t, 1, result.ExitStatus)
+	require.Equal(t, "failed\n", string(result.Stderr))
+	require.Contains(t, err.Error(), "exited with status 1: failed")
+
+	exitStatus, err := RunAndParse(context.TODO(), client, testLog(), "false", nil, ParseDiscard)
+	require.True(t, IsExitError(err), "expected exit error but got %v", err)
+	require.Equal(t, 1, exitStatus)
+
+	result, err = Exec(context.TODO(), client, testLog(), "sleep", Timeout(50*time.Millisecond))
+	require.True(t, IsCanceledError(err), "expected canceled error but got %v", err)
+	require.Equal(t, ExitStatusUndefined, result.ExitStatus)
+}
+
+func TestExecLogsOutput(t *testing.T) {
+	client, cleanup := newExecClient(t)
+	defer cleanup()
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out, log.Level = &buf, logrus.DebugLevel
+
+	var out string
+	_, err := RunAndParse(context.TODO(), client, log, "echo hello", nil, ParseAsString(&out))
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `msg="hello\n" cmd="echo hello" stream=stdout`)
+	_, err = Exec(context.TODO(), client, log, "false")
+	require.Error(t, err)
+	require.Contains(t, buf.String(), `msg="failed\n" cmd=false stream=stderr`)
+
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, PipeCommand(context.TODO(), client, log, "echo piped", dst))
+	require.NotContains(t, buf.String(), `msg="piped`)
+}
+
+func TestWithEnv(t *testing.T) {
+	require.Equal(t, "ls", withEnv("ls", nil))
+	require.Equal(t, "A=1 B=2 ls", withEnv("ls", map[string]string{"B": "2", "A": "1"}))
+}
+
+func TestTail(t *testing.T) {
+	output := []byte(strings.Repeat("line\n", 10) + "last\n")
+	require.Equal(t, "line\nlast", tail(output, 12))
+	require.Equal(t, "last", tail(output, 4))
+	require.Equal(t, "", tail(nil, 4))
+}
+
+// newExecClient returns the client of a test server
+// that runs a few scripted commands
+func newExecClient(t *testing.T) (*ssh.Client, func()) {
+	return newTestClient(t, sshtest.Script(map[string]sshtest.Handler{
+		"echo hello": sshtest.Respond("hello\n", "", 0),
+		"echo piped": sshtest.Respond("piped\n", "", 0),
+		"false":      sshtest.Respond("", "failed\n", 1),
+		"cat":        sshtest.Cat,
+		"sleep":      sshtest.Sleep(5 * time.Second),
+	}))
+}
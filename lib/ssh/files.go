@@ -2,56 +2,405 @@ package sshutils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
-	"path/filepath"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/gravitational/robotest/lib/defaults"
 	"github.com/gravitational/robotest/lib/wait"
 
 	"github.com/gravitational/trace"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
-// TransferFile takes file URL which may be S3 or HTTP or local file and transfers it to remote the machine
-// fileUrl - file to download, could be S3:// or http(s)://
-func TransferFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, fileUrl, dstDir string, env map[string]string) (path string, err error) {
+// TransferFile transfers the file at fileUrl to the directory dstDir on the remote host
+// and returns the path of the file on the remote host. fileUrl is one of:
+//
+//   - a local file path or a file:// URL, uploaded over SFTP
+//   - an s3://bucket/key URL, downloaded on the remote host with the aws CLI using the credentials from env
+//   - an http:// or https:// URL, downloaded on the remote host with curl
+//   - a gs://bucket/object URL, downloaded on the remote host with gsutil
+//   - an scp://node/path URL, copied from another node over SFTP, see NodeDialer
+//
+// The fragment #sha256=<checksum> of the URL sets the expected checksum of the file, see ExpectChecksum.
+// Failed transfers are retried and resume where they stopped unless the source does not allow it
+func TransferFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, fileUrl, dstDir string, env map[string]string, opts ...TransferOptionSetter) (remotePath string, err error) {
+	config := transferConfig{attempts: defaults.TransferAttempts}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	src, err := parseFileURL(fileUrl)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if config.checksum == "" {
+		config.checksum = src.checksum
+	}
+	if config.checksum != "" && !isChecksum(config.checksum) {
+		return "", trace.BadParameter("expected hex-encoded SHA-256 checksum of %v, got %q", src, config.checksum)
+	}
+
+	name := src.name()
+	if config.name != "" {
+		name = config.name
+	}
+	remotePath = path.Join(dstDir, name)
+	f := &fetcher{
+		ctx:    ctx,
+		client: client,
+		log:    log.WithFields(logrus.Fields{"file_url": src.String(), "dst_dir": dstDir}),
+		env:    env,
+		config: config,
+	}
+	if config.checksum != "" {
+		if checksum, err := RemoteChecksum(ctx, client, f.log, remotePath); err == nil && checksum == config.checksum {
+			f.log.Info("file already transferred")
+			return remotePath, nil
+		}
+	}
+
+	retry := wait.Retryer{
+		Delay:       defaults.RetryDelay,
+		Attempts:    config.attempts,
+		FieldLogger: f.log,
+	}
+	err = retry.Do(ctx, func() error {
+		err := f.fetch(src, remotePath)
+		if err == nil {
+			err = f.verify(remotePath)
+		}
+		if isPermanent(err) {
+			return wait.Abort(trace.Wrap(err))
+		}
+		return trace.Wrap(err)
+	})
+	if err != nil {
+		return "", trace.Wrap(err, "failed to transfer %v to %v", src, dstDir)
+	}
+	return remotePath, nil
+}
+
+// TransferOptionSetter configures TransferFile
+type TransferOptionSetter func(config *transferConfig)
+
+// ExpectChecksum verifies the transferred file against the hex-encoded SHA-256 checksum.
+// Overrides the checksum from the URL fragment
+func ExpectChecksum(checksum string) TransferOptionSetter {
+	return func(config *transferConfig) {
+		config.checksum = strings.ToLower(checksum)
+	}
+}
+
+// FileName stores the transferred file under name instead of the name from the URL
+func FileName(name string) TransferOptionSetter {
+	return func(config *transferConfig) {
+		config.name = name
+	}
+}
+
+// NodeDialer enables scp:// URLs which are copied from the node
+// connected to with dial
+func NodeDialer(dial NodeDialFunc) TransferOptionSetter {
+	return func(config *transferConfig) {
+		config.dialNode = dial
+	}
+}
+
+// NodeDialFunc returns the SSH client of the node with the specified address
+type NodeDialFunc func(ctx context.Context, addr string) (*ssh.Client, error)
+
+type transferConfig struct {
+	checksum string
+	name     string
+	dialNode NodeDialFunc
+	attempts int
+}
+
+// FetchCommand returns the command that downloads the s3, http(s) or gs URL
+// to dstPath on the remote host
+func FetchCommand(fileUrl, dstPath string) (string, error) {
+	src, err := parseFileURL(fileUrl)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	cmd, err := src.fetchCommand(dstPath, 0)
+	return cmd, trace.Wrap(err)
+}
+
+// fetcher transfers files to the remote host
+type fetcher struct {
+	ctx    context.Context
+	client *ssh.Client
+	log    logrus.FieldLogger
+	env    map[string]string
+	config transferConfig
+}
+
+func (r *fetcher) fetch(src *fileURL, dstPath string) error {
+	switch src.scheme {
+	case schemeLocal, schemeFile:
+		return trace.Wrap(Upload(r.ctx, r.client, r.log, src.path, dstPath))
+	case schemeSCP:
+		if r.config.dialNode == nil {
+			return trace.BadParameter("%v URLs are not supported here: no access to other nodes", src.scheme)
+		}
+		srcClient, err := r.config.dialNode(r.ctx, src.host)
+		if err != nil {
+			return trace.Wrap(err, "failed to connect to %v", src.host)
+		}
+		return trace.Wrap(CopyFile(r.ctx, srcClient, r.client, r.log, src.path, dstPath))
+	default:
+		return trace.Wrap(r.download(src, dstPath))
+	}
+}
+
+// download downloads src on the remote host
+func (r *fetcher) download(src *fileURL, dstPath string) error {
+	partPath := dstPath + partSuffix
+	_, err := Exec(r.ctx, r.client, r.log, fmt.Sprintf("mkdir -p %s", ShellQuote(path.Dir(dstPath))))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	offset := r.remoteSize(partPath)
+	cmd, err := src.fetchCommand(partPath, offset)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if offset != 0 {
+		r.log.Infof("resume download at %v", humanize.Bytes(uint64(offset)))
+	}
+	_, err = Exec(r.ctx, r.client, r.log, cmd, Env(r.env))
+	if err != nil {
+		if offset != 0 && cannotResume(err) {
+			r.log.WithError(err).Warn("download cannot be resumed, starting over")
+			Exec(r.ctx, r.client, r.log, fmt.Sprintf("rm -f %s", ShellQuote(partPath)))
+		}
+		return trace.Wrap(src.explain(err))
+	}
+	_, err = Exec(r.ctx, r.client, r.log, fmt.Sprintf("mv -f %s %s", ShellQuote(partPath), ShellQuote(dstPath)))
+	return trace.Wrap(err)
+}
+
+// verify compares the checksum of the remote file at path to the expected checksum if set.
+// The file is removed if the checksums do not match
+func (r *fetcher) verify(path string) error {
+	if r.config.checksum == "" {
+		return nil
+	}
+	checksum, err := RemoteChecksum(r.ctx, r.client, r.log, path)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if checksum == r.config.checksum {
+		return nil
+	}
+	Exec(r.ctx, r.client, r.log, fmt.Sprintf("rm -f %s", ShellQuote(path)))
+	return trace.CompareFailed("SHA-256 checksum mismatch of %v: expected %v, got %v",
+		path, r.config.checksum, checksum)
+}
+
+// remoteSize returns the size of the remote file at path or 0 if it does not exist
+func (r *fetcher) remoteSize(path string) int64 {
+	result, err := Exec(r.ctx, r.client, r.log, fmt.Sprintf("stat -c %%s %s", ShellQuote(path)))
+	if err != nil {
+		return 0
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(result.Stdout)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// fileURL is the parsed URL of a file to transfer
+type fileURL struct {
+	scheme string
+	// host is the bucket, host or node of the URL
+	host string
+	// path is the path of the file
+	path string
+	// url is the URL without the fragment
+	url string
+	// checksum is the expected checksum from the URL fragment
+	checksum string
+}
+
+func parseFileURL(fileUrl string) (*fileURL, error) {
 	u, err := url.Parse(fileUrl)
 	if err != nil {
-		return "", trace.Wrap(err, "parsing %s", fileUrl)
-	}
-
-	log = log.WithFields(logrus.Fields{"file_url": fileUrl, "dst_dir": dstDir})
-
-	fname := filepath.Base(u.Path)
-	dstPath := filepath.Join(dstDir, fname)
-	var cmd string
-	switch u.Scheme {
-	case "s3":
-		cmd = fmt.Sprintf(`aws s3 cp %s - > %s`, fileUrl, dstPath)
-	case "http":
-	case "https":
-		cmd = fmt.Sprintf("wget %s -O %s/", fileUrl, dstPath)
-	case "":
-		remotePath, err := PutFile(ctx, client, log, fileUrl, dstDir)
-		return remotePath, trace.Wrap(err)
-	case "gs":
+		return nil, trace.BadParameter("invalid file URL %q: %v", fileUrl, err)
+	}
+	var checksum string
+	if u.Fragment != "" {
+		values, err := url.ParseQuery(u.Fragment)
+		if err != nil || values.Get(checksumFragment) == "" {
+			return nil, trace.BadParameter("unsupported fragment of %q: expected #%v=<checksum>",
+				fileUrl, checksumFragment)
+		}
+		checksum = strings.ToLower(values.Get(checksumFragment))
+		u.Fragment = ""
+	}
+	src := &fileURL{
+		scheme:   u.Scheme,
+		host:     u.Host,
+		path:     u.Path,
+		url:      u.String(),
+		checksum: checksum,
+	}
+	switch src.scheme {
+	case schemeLocal:
+	case schemeFile:
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, trace.BadParameter("%v URLs must not specify a host: %v", src.scheme, src)
+		}
+	case schemeS3, schemeGS, schemeHTTP, schemeHTTPS, schemeSCP:
+		if u.Host == "" {
+			return nil, trace.BadParameter("%v URLs require a host: %v", src.scheme, src)
+		}
 	default:
-		// TODO : implement SCP and GCLOUD methods
-		return "", fmt.Errorf("unsupported URL schema %s", fileUrl)
+		return nil, trace.BadParameter("unsupported URL scheme %q of %v: "+
+			"expected a local path or a file://, s3://, gs://, http(s):// or scp:// URL", src.scheme, src)
 	}
+	if name := src.name(); name == "." || name == "/" {
+		return nil, trace.BadParameter("%v does not name a file", src)
+	}
+	return src, nil
+}
 
-	err = RunCommands(ctx, client, log, []Cmd{
-		{fmt.Sprintf("mkdir -p %s", dstDir), nil},
-		{cmd, env},
-	})
-	if err == nil {
-		return dstPath, nil
+func (r *fileURL) String() string {
+	return r.url
+}
+
+// name returns the name of the file
+func (r *fileURL) name() string {
+	return path.Base(r.path)
+}
+
+// fetchCommand returns the command that downloads the file to dstPath on the remote host.
+// The download resumes at offset if the source allows
+func (r *fileURL) fetchCommand(dstPath string, offset int64) (string, error) {
+	switch r.scheme {
+	case schemeS3:
+		if offset == 0 {
+			return fmt.Sprintf("aws s3 cp --only-show-errors %s %s", ShellQuote(r.url), ShellQuote(dstPath)), nil
+		}
+		rangePath := ShellQuote(dstPath + ".range")
+		return fmt.Sprintf("aws s3api get-object --bucket %s --key %s --range bytes=%d- %s > /dev/null && cat %s >> %s && rm -f %s",
+			ShellQuote(r.host), ShellQuote(strings.TrimPrefix(r.path, "/")), offset,
+			rangePath, rangePath, ShellQuote(dstPath), rangePath), nil
+	case schemeHTTP, schemeHTTPS:
+		return fmt.Sprintf("curl --fail --location --silent --show-error --continue-at - --output %s %s",
+			ShellQuote(dstPath), ShellQuote(r.url)), nil
+	case schemeGS:
+		// gsutil resumes interrupted downloads of large files on its own
+		return fmt.Sprintf("gsutil -q cp %s %s", ShellQuote(r.url), ShellQuote(dstPath)), nil
+	default:
+		return "", trace.BadParameter("%v URLs are not downloaded on the remote host", r.scheme)
+	}
+}
+
+// explain converts the error of the download command into an error
+// that tells the likely cause for the scheme
+func (r *fileURL) explain(err error) error {
+	exitErr, ok := trace.Unwrap(err).(*ExitError)
+	if !ok {
+		return trace.Wrap(err)
 	}
-	return "", trace.Wrap(err)
+	stderr := string(exitErr.Stderr)
+	if exitErr.ExitStatus == exitCommandNotFound {
+		return trace.NotFound("%v is required on the node to download %v URLs", downloaders[r.scheme], r.scheme)
+	}
+	switch r.scheme {
+	case schemeS3:
+		switch {
+		case strings.Contains(stderr, "NoSuchKey"), strings.Contains(stderr, "NoSuchBucket"), strings.Contains(stderr, "(404)"):
+			return trace.NotFound("%v not found: %v", r, tail(exitErr.Stderr, maxErrorOutput))
+		case strings.Contains(stderr, "AccessDenied"), strings.Contains(stderr, "(403)"), strings.Contains(stderr, "credentials"):
+			return trace.AccessDenied("access to %v denied, check AWS credentials and region: %v",
+				r, tail(exitErr.Stderr, maxErrorOutput))
+		}
+	case schemeHTTP, schemeHTTPS:
+		if match := reHTTPError.FindStringSubmatch(stderr); len(match) == 2 {
+			switch match[1] {
+			case "404", "410":
+				return trace.NotFound("%v not found: HTTP %v", r, match[1])
+			case "401", "403":
+				return trace.AccessDenied("access to %v denied: HTTP %v", r, match[1])
+			}
+		}
+	case schemeGS:
+		switch {
+		case strings.Contains(stderr, "No URLs matched"), strings.Contains(stderr, "NotFoundException"):
+			return trace.NotFound("%v not found: %v", r, tail(exitErr.Stderr, maxErrorOutput))
+		case strings.Contains(stderr, "AccessDeniedException"), strings.Contains(stderr, "401"):
+			return trace.AccessDenied("access to %v denied, check the service account of the node: %v",
+				r, tail(exitErr.Stderr, maxErrorOutput))
+		}
+	}
+	return trace.Wrap(err, "failed to download %v with %v", r, downloaders[r.scheme])
 }
 
+// cannotResume returns true if the download command failed as the server
+// does not support resuming the download at the offset
+func cannotResume(err error) bool {
+	exitErr, ok := trace.Unwrap(err).(*ExitError)
+	if !ok {
+		return false
+	}
+	return exitErr.ExitStatus == curlRangeError || exitErr.ExitStatus == curlResumeError ||
+		strings.Contains(string(exitErr.Stderr), "InvalidRange")
+}
+
+// isPermanent returns true if err will not go away with retries
+func isPermanent(err error) bool {
+	return err != nil && (trace.IsNotFound(err) || trace.IsAccessDenied(err) || trace.IsBadParameter(err))
+}
+
+// isChecksum returns true if checksum is a hex-encoded SHA-256 checksum
+func isChecksum(checksum string) bool {
+	decoded, err := hex.DecodeString(checksum)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+const (
+	schemeLocal = ""
+	schemeFile  = "file"
+	schemeS3    = "s3"
+	schemeGS    = "gs"
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+	schemeSCP   = "scp"
+
+	// checksumFragment names the URL fragment parameter with the expected checksum
+	checksumFragment = "sha256"
+
+	// exitCommandNotFound is the exit status of the shell if the command does not exist
+	exitCommandNotFound = 127
+	// curlRangeError is the exit status of curl if the server does not support ranges
+	curlRangeError = 33
+	// curlResumeError is the exit status of curl if the download cannot be resumed
+	curlResumeError = 36
+)
+
+// downloaders names the tools that download files on the remote host by URL scheme
+var downloaders = map[string]string{
+	schemeS3:    "aws CLI",
+	schemeGS:    "gsutil",
+	schemeHTTP:  "curl",
+	schemeHTTPS: "curl",
+}
+
+// reHTTPError matches the HTTP status in the errors of curl
+var reHTTPError = regexp.MustCompile(`returned error: (\d{3})`)
+
 const (
 	// TestRegularFile file exists and is a regular file
 	TestRegularFile = "-f"
@@ -75,7 +424,7 @@ func TestFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, p
 	case 0:
 		return nil
 	case 1:
-		return trace.NotFound(path)
+		return trace.NotFound("%v not found", path)
 	}
 
 	return trace.Wrap(err, cmd)
@@ -0,0 +1,154 @@
+package sshutils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravitational/robotest/lib/ssh/sshtest"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsesFileURLs(t *testing.T) {
+	const checksum = "d2a84f4b8b650937ec8f73cd8be2c74add5a911ba64df27458ed8229da804a26"
+	var tests = []struct {
+		url      string
+		expected *fileURL
+		err      string
+	}{
+		{
+			url:      "/robotest/installer.tar",
+			expected: &fileURL{path: "/robotest/installer.tar", url: "/robotest/installer.tar"},
+		},
+		{
+			url:      "file:///robotest/installer.tar",
+			expected: &fileURL{scheme: "file", path: "/robotest/installer.tar", url: "file:///robotest/installer.tar"},
+		},
+		{
+			url: "s3://s3.gravitational.io/builds/installer.tar#sha256=" + strings.ToUpper(checksum),
+			expected: &fileURL{scheme: "s3", host: "s3.gravitational.io", path: "/builds/installer.tar",
+				url: "s3://s3.gravitational.io/builds/installer.tar", checksum: checksum},
+		},
+		{
+			url: "https://get.gravitational.io/installer.tar?version=5.0",
+			expected: &fileURL{scheme: "https", host: "get.gravitational.io", path: "/installer.tar",
+				url: "https://get.gravitational.io/installer.tar?version=5.0"},
+		},
+		{
+			url:      "scp://10.0.0.1/home/centos/installer.tar",
+			expected: &fileURL{scheme: "scp", host: "10.0.0.1", path: "/home/centos/installer.tar", url: "scp://10.0.0.1/home/centos/installer.tar"},
+		},
+		{url: "ftp://example.com/installer.tar", err: "unsupported URL scheme"},
+		{url: "gs:///installer.tar", err: "require a host"},
+		{url: "https://example.com/", err: "does not name a file"},
+		{url: "https://example.com/installer.tar#md5=abc", err: "unsupported fragment"},
+	}
+	for _, tc := range tests {
+		src, err := parseFileURL(tc.url)
+		if tc.err != "" {
+			require.True(t, trace.IsBadParameter(err), "%v: expected bad parameter but got %v", tc.url, err)
+			require.Contains(t, err.Error(), tc.err, tc.url)
+			continue
+		}
+		require.NoError(t, err, tc.url)
+		require.Equal(t, tc.expected, src, tc.url)
+	}
+}
+
+func TestExplainsDownloadErrors(t *testing.T) {
+	var tests = []struct {
+		url    string
+		status int
+		stderr string
+		check  func(error) bool
+	}{
+		{url: "s3://bucket/installer.tar", status: 127, stderr: "aws: not found", check: trace.IsNotFound},
+		{url: "s3://bucket/installer.tar", status: 1, stderr: "An error occurred (NoSuchKey)", check: trace.IsNotFound},
+		{url: "s3://bucket/installer.tar", status: 1, stderr: "Unable to locate credentials", check: trace.IsAccessDenied},
+		{url: "https://example.com/installer.tar", status: 22, stderr: "The requested URL returned error: 404", check: trace.IsNotFound},
+		{url: "https://example.com/installer.tar", status: 22, stderr: "The requested URL returned error: 403", check: trace.IsAccessDenied},
+		{url: "https://example.com/installer.tar", status: 22, stderr: "The requested URL returned error: 503", check: isTransient},
+		{url: "gs://bucket/installer.tar", status: 1, stderr: "CommandException: No URLs matched", check: trace.IsNotFound},
+		{url: "gs://bucket/installer.tar", status: 1, stderr: "AccessDeniedException: 403", check: trace.IsAccessDenied},
+	}
+	for _, tc := range tests {
+		src, err := parseFileURL(tc.url)
+		require.NoError(t, err)
+		err = src.explain(trace.Wrap(&ExitError{Result: &Result{
+			Command:    "download",
+			ExitStatus: tc.status,
+			Stderr:     []byte(tc.stderr),
+		}}))
+		require.True(t, tc.check(err), "%v %q: unexpected error %v", tc.url, tc.stderr, err)
+	}
+}
+
+func TestTransfersFiles(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-transfer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("installer", 100000)
+	src := filepath.Join(dir, "installer.tar")
+	writeFileMode(t, src, content, 0644)
+	checksum, err := FileChecksum(src)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/installer.tar" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "installer.tar", time.Now(), strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	dialNode := NodeDialer(func(ctx context.Context, addr string) (*ssh.Client, error) {
+		require.Equal(t, "node-2", addr)
+		return client, nil
+	})
+	var tests = []struct {
+		comment string
+		url     string
+		// part is the partial file left by a previous attempt
+		part string
+	}{
+		{comment: "local path", url: src + "#sha256=" + checksum},
+		{comment: "file URL", url: "file://" + src},
+		{comment: "http URL", url: server.URL + "/installer.tar#sha256=" + checksum},
+		{comment: "resumed http URL", url: server.URL + "/installer.tar", part: content[:4321]},
+		{comment: "scp URL", url: "scp://node-2" + src},
+	}
+	for i, tc := range tests {
+		dstDir := filepath.Join(dir, "dst", tc.comment)
+		if tc.part != "" {
+			require.NoError(t, os.MkdirAll(dstDir, 0755))
+			writeFileMode(t, filepath.Join(dstDir, "installer.tar"+partSuffix), tc.part, 0644)
+		}
+		path, err := TransferFile(context.TODO(), client, testLog(), tc.url, dstDir, nil, dialNode)
+		require.NoError(t, err, tc.comment)
+		require.Equal(t, filepath.Join(dstDir, "installer.tar"), path, "%v: %v", i, tc.comment)
+		requireFile(t, path, content, 0644)
+	}
+
+	_, err = TransferFile(context.TODO(), client, testLog(), server.URL+"/missing.tar", dir, nil)
+	require.True(t, trace.IsNotFound(err), "expected not found but got %v", err)
+
+	_, err = TransferFile(context.TODO(), client, testLog(), "scp://node-2"+src, dir, nil)
+	require.True(t, trace.IsBadParameter(err), "expected bad parameter without node dialer but got %v", err)
+}
+
+func isTransient(err error) bool {
+	return err != nil && !isPermanent(err)
+}
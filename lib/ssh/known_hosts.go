@@ -0,0 +1,158 @@
+package sshutils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+	"golang.org/x/crypto/ssh"
+)
+
+// KnownHosts verifies host keys with trust on first use:
+// the key of a host seen for the first time is recorded in the known_hosts file
+// and every later connection to the host is verified against the recorded key.
+// The file uses the OpenSSH known_hosts format
+type KnownHosts struct {
+	path string
+
+	mu sync.Mutex
+	// keys maps the normalized host address to its key
+	keys map[string]ssh.PublicKey
+}
+
+// NewKnownHosts loads the known_hosts file at path.
+// The file is created once the first host key is recorded
+func NewKnownHosts(path string) (*KnownHosts, error) {
+	r := &KnownHosts{
+		path: path,
+		keys: make(map[string]ssh.PublicKey),
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, trace.ConvertSystemError(err)
+	}
+	for len(data) != 0 {
+		var hosts []string
+		var key ssh.PublicKey
+		_, hosts, key, _, data, err = ssh.ParseKnownHosts(data)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to parse %v", path)
+		}
+		for _, host := range hosts {
+			r.keys[normalizeHost(host)] = key
+		}
+	}
+	return r, nil
+}
+
+// VerifyHostKey verifies the key of the host at the specified address.
+// Records the key if the host is not known.
+// Returns trace.CompareFailed if the key does not match the recorded one
+func (r *KnownHosts) VerifyHostKey(addr string, remote net.Addr, key ssh.PublicKey) error {
+	host := normalizeHost(addr)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	known, ok := r.keys[host]
+	if !ok {
+		return trace.Wrap(r.record(host, key))
+	}
+	if !bytes.Equal(known.Marshal(), key.Marshal()) {
+		return trace.CompareFailed("host key of %v has changed: expected %v %v but got %v %v. "+
+			"Either the host has been rebuilt without the provisioner forgetting its key "+
+			"or the connection is being intercepted. To trust the new key, remove %v from %v",
+			host, known.Type(), ssh.FingerprintSHA256(known), key.Type(), ssh.FingerprintSHA256(key),
+			host, r.path)
+	}
+	return nil
+}
+
+// Forget removes the keys recorded for the specified host addresses,
+// i.e. after hosts have been rebuilt or their addresses reused.
+// The hosts are trusted on the next connection
+func (r *KnownHosts) Forget(addrs ...string) error {
+	if r == nil || len(addrs) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var forgotten bool
+	for _, addr := range addrs {
+		host := normalizeHost(addr)
+		if _, ok := r.keys[host]; ok {
+			delete(r.keys, host)
+			forgotten = true
+		}
+	}
+	if !forgotten {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for host, key := range r.keys {
+		buf.WriteString(knownHostsLine(host, key))
+	}
+	err := ioutil.WriteFile(r.path, buf.Bytes(), constants.SharedReadMask)
+	return trace.ConvertSystemError(err)
+}
+
+// record adds the key of the host to the known_hosts file.
+// Must be called with the lock held
+func (r *KnownHosts) record(host string, key ssh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(r.path), constants.SharedDirMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, constants.SharedReadMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(knownHostsLine(host, key))
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	r.keys[host] = key
+	return nil
+}
+
+// HostKeys returns the option to verify host keys against knownHosts.
+// Host keys are not verified if knownHosts is nil
+func HostKeys(knownHosts *KnownHosts) ClientOptionSetter {
+	return func(config *ssh.ClientConfig) {
+		if knownHosts != nil {
+			config.HostKeyCallback = knownHosts.VerifyHostKey
+		}
+	}
+}
+
+// knownHostsLine formats the known_hosts file entry for the host key
+func knownHostsLine(host string, key ssh.PublicKey) string {
+	// MarshalAuthorizedKey terminates the key with a newline
+	return fmt.Sprintf("%v %s", host, ssh.MarshalAuthorizedKey(key))
+}
+
+// normalizeHost returns the host address in known_hosts format:
+// the host for the standard SSH port or [host]:port otherwise
+func normalizeHost(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port or already normalized
+		return addr
+	}
+	if port == "22" {
+		return host
+	}
+	return fmt.Sprintf("[%v]:%v", host, port)
+}
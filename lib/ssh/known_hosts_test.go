@@ -0,0 +1,62 @@
+package sshutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerifiesHostKeysOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "robotest-known-hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "known_hosts")
+
+	key, otherKey := newHostKey(t), newHostKey(t)
+
+	knownHosts, err := NewKnownHosts(path)
+	require.NoError(t, err)
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:22", nil, key), "records unknown host")
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:22", nil, key), "accepts recorded key")
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:2222", nil, otherKey), "tells ports apart")
+
+	// keys are kept across runs
+	knownHosts, err = NewKnownHosts(path)
+	require.NoError(t, err)
+	err = knownHosts.VerifyHostKey("10.0.0.1:22", nil, otherKey)
+	require.True(t, trace.IsCompareFailed(err), "rejects changed key: %v", err)
+	require.NoError(t, knownHosts.VerifyHostKey("[10.0.0.1]:2222", nil, otherKey))
+
+	// rebuilt hosts are trusted again once forgotten
+	require.NoError(t, knownHosts.Forget("10.0.0.1"))
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:22", nil, otherKey))
+
+	knownHosts, err = NewKnownHosts(path)
+	require.NoError(t, err)
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:22", nil, otherKey))
+	require.NoError(t, knownHosts.VerifyHostKey("10.0.0.1:2222", nil, otherKey))
+}
+
+func TestForgetsWithoutKnownHosts(t *testing.T) {
+	var knownHosts *KnownHosts
+	require.NoError(t, knownHosts.Forget("10.0.0.1"))
+
+	config := &ssh.ClientConfig{}
+	HostKeys(knownHosts)(config)
+	require.Nil(t, config.HostKeyCallback)
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(&private.PublicKey)
+	require.NoError(t, err)
+	return key
+}
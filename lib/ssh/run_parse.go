@@ -2,12 +2,9 @@ package sshutils
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"io/ioutil"
-	"strings"
 
 	"github.com/gravitational/trace"
 
@@ -17,11 +14,6 @@ import (
 
 type OutputParseFn func(r *bufio.Reader) error
 
-const (
-	exitStatusUndefined = -1
-	exitCode            = "exit"
-)
-
 // Run is a simple method to run external program and don't care about its output or exit status
 func Run(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, cmd string, env map[string]string) error {
 	exit, err := RunAndParse(ctx, client, log, cmd, env, ParseDiscard)
@@ -37,90 +29,40 @@ func Run(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, cmd st
 }
 
 // RunAndParse runs remote SSH command with environment variables set by `env`
-// exitStatus is -1 if undefined
+// and streams its standard output to parse unless nil.
+// exitStatus is -1 if undefined.
+// See Exec for the errors returned
 func RunAndParse(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, cmd string, env map[string]string, parse OutputParseFn) (exitStatus int, err error) {
-	session, err := client.NewSession()
-	if err != nil {
-		return exitStatusUndefined, trace.Wrap(err)
-	}
-	defer session.Close()
-
-	envStrings := []string{}
-	if env != nil {
-		for k, v := range env {
-			envStrings = append(envStrings, fmt.Sprintf("%s=%s", k, v))
-		}
-	}
-
-	session.Stdin = new(bytes.Buffer)
-
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		return exitStatusUndefined, trace.Wrap(err)
-	}
-
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		return exitStatusUndefined, trace.Wrap(err)
-	}
-
-	log = log.WithField("cmd", cmd)
-
-	errCh := make(chan error, 2)
-	expectErrs := 1
+	opts := []ExecOptionSetter{Env(env)}
+	var parseErr chan error
+	var stdout *io.PipeWriter
 	if parse != nil {
-		expectErrs++
+		var r *io.PipeReader
+		r, stdout = io.Pipe()
+		opts = append(opts, Stdout(stdout))
+		parseErr = make(chan error, 1)
 		go func() {
-			err := parse(bufio.NewReader(
-				&readLogger{log.WithField("stream", "stdout"), stdout}))
-			errCh <- trace.Wrap(err)
+			err := parse(bufio.NewReader(r))
+			// drain the output the parser has not consumed so the command does not block
+			io.Copy(ioutil.Discard, r)
+			parseErr <- trace.Wrap(err)
 		}()
 	}
 
-	go func() {
-		log.Debug(cmd)
-		errCh <- session.Run(fmt.Sprintf("%s %s", strings.Join(envStrings, " "), cmd))
-	}()
-
-	go func() {
-		r := bufio.NewReader(stderr)
-		stderrLog := log.WithField("stream", "stderr")
-		for {
-			line, err := r.ReadString('\n')
-			if line != "" {
-				stderrLog.Debug(line)
-			}
-			if parse == nil {
-				session.Close()
-				errCh <- nil // FIXME : this is a hack; session closure does not unblock session.Run() wonder if there's a better way
-				return
-			}
-			if err != nil {
-				return
-			}
+	result, err := Exec(ctx, client, log, cmd, opts...)
+	if parse != nil {
+		stdout.Close()
+		if errParse := <-parseErr; err == nil && errParse != nil {
+			return result.ExitStatus, trace.Wrap(errParse)
 		}
-	}()
-
-	for i := 0; i < expectErrs; i++ {
-		select {
-		case <-ctx.Done():
-			session.Signal(ssh.SIGTERM)
-			log.WithError(ctx.Err()).Debug("context terminated, sent SIGTERM")
-			return exitStatusUndefined, err
-		case err = <-errCh:
-			if exitErr, isExitErr := err.(*ssh.ExitError); isExitErr {
-				err = trace.Wrap(exitErr)
-				log.WithError(err).Debugf("%s : %s", cmd, exitErr.Error())
-				return exitErr.ExitStatus(), err
-			}
-			if err != nil {
-				err = trace.Wrap(err)
-				log.WithError(err).Debug("unexpected error")
-				return exitStatusUndefined, err
-			}
+	}
+	if err != nil {
+		if result != nil {
+			return result.ExitStatus, trace.Wrap(err)
 		}
+		return ExitStatusUndefined, trace.Wrap(err)
 	}
-	return 0, nil
+	return result.ExitStatus, nil
 }
 
 func ParseDiscard(r *bufio.Reader) error {
@@ -138,18 +80,3 @@ func ParseAsString(out *string) OutputParseFn {
 		return nil
 	}
 }
-
-type readLogger struct {
-	log logrus.FieldLogger
-	r   io.Reader
-}
-
-func (l *readLogger) Read(p []byte) (n int, err error) {
-	n, err = l.r.Read(p)
-	if err != nil && err != io.EOF {
-		l.log.WithError(err).Debug("unexpected I/O error")
-	} else if n > 0 {
-		l.log.Debug(string(p[0:n]))
-	}
-	return n, err
-}
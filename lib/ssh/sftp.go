@@ -0,0 +1,491 @@
+package sshutils
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gravitational/robotest/lib/constants"
+	"github.com/gravitational/robotest/lib/defaults"
+	"github.com/gravitational/trace"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Upload copies the local file or directory srcPath to dstPath on the remote host over SFTP.
+// Directories are copied recursively, permissions of files and directories are preserved.
+// Files are written to dstPath.part first and renamed once their SHA-256 checksum matches,
+// so an interrupted upload of a large file is resumed where it stopped
+func Upload(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, srcPath, dstPath string) error {
+	fi, err := os.Stat(srcPath)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	t, err := newTransfer(ctx, client, log)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer t.close()
+
+	if !fi.IsDir() {
+		err = t.upload(localSource(srcPath), dstPath, fi)
+		return trace.Wrap(t.interrupted(err), "failed to upload %v to %v", srcPath, dstPath)
+	}
+
+	var dirs []dirMode
+	err = filepath.Walk(srcPath, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		relPath, err := filepath.Rel(srcPath, localPath)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		remotePath := path.Join(dstPath, filepath.ToSlash(relPath))
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, dirMode{path: remotePath, mode: fi.Mode().Perm()})
+			return trace.Wrap(t.sftp.MkdirAll(remotePath))
+		case fi.Mode().IsRegular():
+			return trace.Wrap(t.upload(localSource(localPath), remotePath, fi))
+		default:
+			log.WithField("path", localPath).Warn("skip file that is neither regular file nor directory")
+			return nil
+		}
+	})
+	if err == nil {
+		// directories are made read-only only after their contents have been written
+		for i := len(dirs) - 1; i >= 0 && err == nil; i-- {
+			err = t.sftp.Chmod(dirs[i].path, dirs[i].mode)
+		}
+	}
+	return trace.Wrap(t.interrupted(err), "failed to upload %v to %v", srcPath, dstPath)
+}
+
+// CopyFile copies the file srcPath on the remote host of srcClient to dstPath on the remote host
+// of client over SFTP, streaming it through this process. See Upload for details
+func CopyFile(ctx context.Context, srcClient, client *ssh.Client, log logrus.FieldLogger, srcPath, dstPath string) error {
+	src, err := newTransfer(ctx, srcClient, log)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer src.close()
+	dst, err := newTransfer(ctx, client, log)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer dst.close()
+
+	fi, err := src.sftp.Stat(srcPath)
+	if err != nil {
+		return trace.Wrap(src.interrupted(trace.ConvertSystemError(err)), "failed to stat remote %v", srcPath)
+	}
+	if !fi.Mode().IsRegular() {
+		return trace.BadParameter("%v is not a regular file", srcPath)
+	}
+	err = dst.upload(src.remoteSource(srcPath), dstPath, fi)
+	return trace.Wrap(dst.interrupted(err), "failed to copy %v to %v", srcPath, dstPath)
+}
+
+// Download copies the remote file or directory srcPath to the local dstPath over SFTP.
+// See Upload for how permissions, checksums and partial transfers are handled
+func Download(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, srcPath, dstPath string) error {
+	t, err := newTransfer(ctx, client, log)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer t.close()
+
+	srcPath = path.Clean(srcPath)
+	fi, err := t.sftp.Stat(srcPath)
+	if err != nil {
+		return trace.Wrap(t.interrupted(trace.ConvertSystemError(err)), "failed to stat remote %v", srcPath)
+	}
+	if !fi.IsDir() {
+		err = t.download(srcPath, dstPath, fi)
+		return trace.Wrap(t.interrupted(err), "failed to download %v to %v", srcPath, dstPath)
+	}
+
+	var dirs []dirMode
+	walker := t.sftp.Walk(srcPath)
+	for walker.Step() && err == nil {
+		if err = walker.Err(); err != nil {
+			break
+		}
+		remotePath, fi := walker.Path(), walker.Stat()
+		relPath := strings.TrimPrefix(strings.TrimPrefix(remotePath, srcPath), "/")
+		localPath := filepath.Join(dstPath, filepath.FromSlash(relPath))
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, dirMode{path: localPath, mode: fi.Mode().Perm()})
+			err = trace.ConvertSystemError(os.MkdirAll(localPath, constants.SharedDirMask))
+		case fi.Mode().IsRegular():
+			err = t.download(remotePath, localPath, fi)
+		default:
+			log.WithField("path", remotePath).Warn("skip file that is neither regular file nor directory")
+		}
+	}
+	for i := len(dirs) - 1; i >= 0 && err == nil; i-- {
+		err = trace.ConvertSystemError(os.Chmod(dirs[i].path, dirs[i].mode))
+	}
+	return trace.Wrap(t.interrupted(err), "failed to download %v to %v", srcPath, dstPath)
+}
+
+// transfer copies files between the local and the remote host
+type transfer struct {
+	ctx    context.Context
+	client *ssh.Client
+	sftp   *sftp.Client
+	log    logrus.FieldLogger
+	// done stops the goroutine that interrupts the transfer once the context expires
+	done chan struct{}
+}
+
+func newTransfer(ctx context.Context, client *ssh.Client, log logrus.FieldLogger) (*transfer, error) {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to start SFTP session")
+	}
+	t := &transfer{
+		ctx:    ctx,
+		client: client,
+		sftp:   sftpClient,
+		log:    log,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			sftpClient.Close()
+		case <-t.done:
+		}
+	}()
+	return t, nil
+}
+
+func (t *transfer) close() {
+	close(t.done)
+	t.sftp.Close()
+}
+
+// interrupted returns the error of the context if the transfer failed as the context expired
+func (t *transfer) interrupted(err error) error {
+	if err != nil && t.ctx.Err() != nil {
+		return trace.Wrap(t.ctx.Err(), "transfer interrupted")
+	}
+	return trace.Wrap(err)
+}
+
+// upload copies the file src to the remote dstPath
+func (t *transfer) upload(src source, dstPath string, fi os.FileInfo) error {
+	partPath := dstPath + partSuffix
+	var offset int64
+	if part, err := t.sftp.Stat(partPath); err == nil && part.Size() <= fi.Size() {
+		offset = part.Size()
+	}
+	err := t.sftp.MkdirAll(path.Dir(dstPath))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	log := t.log.WithFields(logrus.Fields{"src": src.path, "dst": dstPath})
+	for {
+		err = t.copyToRemote(log, src, partPath, offset, fi.Size())
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = t.verifyChecksum(src.checksum, partPath)
+		if err == nil {
+			break
+		}
+		if !trace.IsCompareFailed(err) {
+			return trace.Wrap(err)
+		}
+		t.sftp.Remove(partPath)
+		if offset == 0 {
+			return trace.Wrap(err)
+		}
+		log.WithError(err).Warn("resumed upload is corrupted, restarting")
+		offset = 0
+	}
+
+	err = t.sftp.Chmod(partPath, fi.Mode().Perm())
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(t.rename(partPath, dstPath))
+}
+
+func (t *transfer) copyToRemote(log logrus.FieldLogger, from source, dstPath string, offset, size int64) error {
+	src, err := from.open()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer src.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := t.sftp.OpenFile(dstPath, flags)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer dst.Close()
+
+	if offset != 0 {
+		log.Infof("resume upload at %v", humanize.Bytes(uint64(offset)))
+		if _, err = src.Seek(offset, io.SeekStart); err != nil {
+			return trace.Wrap(err)
+		}
+		if _, err = dst.Seek(offset, io.SeekStart); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	// writes are sequential so an interrupted upload leaves no gaps to resume from
+	_, err = dst.ReadFrom(io.TeeReader(src, newProgress(log, "uploaded", offset, size)))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(dst.Close())
+}
+
+// download copies the remote file srcPath to the local dstPath
+func (t *transfer) download(srcPath, dstPath string, fi os.FileInfo) error {
+	partPath := dstPath + partSuffix
+	var offset int64
+	if part, err := os.Stat(partPath); err == nil && part.Size() <= fi.Size() {
+		offset = part.Size()
+	}
+	err := os.MkdirAll(filepath.Dir(dstPath), constants.SharedDirMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+
+	log := t.log.WithFields(logrus.Fields{"src": srcPath, "dst": dstPath})
+	for {
+		err = t.copyFromRemote(log, srcPath, partPath, offset, fi.Size())
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = t.verifyChecksum(localChecksum(partPath), srcPath)
+		if err == nil {
+			break
+		}
+		if !trace.IsCompareFailed(err) {
+			return trace.Wrap(err)
+		}
+		os.Remove(partPath)
+		if offset == 0 {
+			return trace.Wrap(err)
+		}
+		log.WithError(err).Warn("resumed download is corrupted, restarting")
+		offset = 0
+	}
+
+	err = os.Chmod(partPath, fi.Mode().Perm())
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	return trace.ConvertSystemError(os.Rename(partPath, dstPath))
+}
+
+func (t *transfer) copyFromRemote(log logrus.FieldLogger, srcPath, dstPath string, offset, size int64) error {
+	src, err := t.sftp.Open(srcPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer src.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	dst, err := os.OpenFile(dstPath, flags, constants.SharedReadMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer dst.Close()
+
+	if offset != 0 {
+		log.Infof("resume download at %v", humanize.Bytes(uint64(offset)))
+		if _, err = src.Seek(offset, io.SeekStart); err != nil {
+			return trace.Wrap(err)
+		}
+		if _, err = dst.Seek(offset, io.SeekStart); err != nil {
+			return trace.ConvertSystemError(err)
+		}
+	}
+	_, err = src.WriteTo(io.MultiWriter(dst, newProgress(log, "downloaded", offset, size)))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.ConvertSystemError(dst.Close())
+}
+
+// rename moves the remote file oldPath to newPath replacing newPath if it exists
+func (t *transfer) rename(oldPath, newPath string) error {
+	if _, ok := t.sftp.HasExtension(posixRenameExtension); ok {
+		return trace.Wrap(t.sftp.PosixRename(oldPath, newPath))
+	}
+	// SFTP rename fails if the target exists
+	if err := t.sftp.Remove(newPath); err != nil && !os.IsNotExist(err) {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(t.sftp.Rename(oldPath, newPath))
+}
+
+// verifyChecksum compares the checksum of the local file computed with checksum
+// to the checksum of the remote file at remotePath.
+// Returns CompareFailed if the checksums do not match
+func (t *transfer) verifyChecksum(checksum func() (string, error), remotePath string) error {
+	local, err := checksum()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	remote, err := RemoteChecksum(t.ctx, t.client, t.log, remotePath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if local != remote {
+		return trace.CompareFailed("SHA-256 checksum mismatch of %v: expected %v, got %v",
+			remotePath, local, remote)
+	}
+	return nil
+}
+
+// RemoteChecksum returns the hex-encoded SHA-256 checksum of the file at path on the remote host
+func RemoteChecksum(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, path string) (string, error) {
+	result, err := Exec(ctx, client, log, fmt.Sprintf("sha256sum %s", ShellQuote(path)))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	fields := strings.Fields(string(result.Stdout))
+	if len(fields) == 0 {
+		return "", trace.BadParameter("unexpected output of %q: %s", result.Command, result.Stdout)
+	}
+	return fields[0], nil
+}
+
+// FileChecksum returns the hex-encoded SHA-256 checksum of the local file at path
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, bufio.NewReader(f)); err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func localChecksum(path string) func() (string, error) {
+	return func() (string, error) {
+		return FileChecksum(path)
+	}
+}
+
+// source is a file to upload
+type source struct {
+	path string
+	// open opens the file for reading
+	open func() (readSeekCloser, error)
+	// checksum computes the checksum of the file
+	checksum func() (string, error)
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+func localSource(path string) source {
+	return source{
+		path: path,
+		open: func() (readSeekCloser, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, trace.ConvertSystemError(err)
+			}
+			return f, nil
+		},
+		checksum: localChecksum(path),
+	}
+}
+
+// remoteSource returns the file at path on the remote host of the transfer
+func (t *transfer) remoteSource(path string) source {
+	return source{
+		path: path,
+		open: func() (readSeekCloser, error) {
+			f, err := t.sftp.Open(path)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return f, nil
+		},
+		checksum: func() (string, error) {
+			return RemoteChecksum(t.ctx, t.client, t.log, path)
+		},
+	}
+}
+
+// ShellQuote quotes s as a single word for the remote shell
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+type dirMode struct {
+	path string
+	mode os.FileMode
+}
+
+// progress logs the progress of a transfer periodically
+type progress struct {
+	log      logrus.FieldLogger
+	action   string
+	done     int64
+	total    int64
+	reported time.Time
+}
+
+func newProgress(log logrus.FieldLogger, action string, done, total int64) *progress {
+	return &progress{
+		log:      log,
+		action:   action,
+		done:     done,
+		total:    total,
+		reported: time.Now(),
+	}
+}
+
+func (r *progress) Write(p []byte) (int, error) {
+	r.done += int64(len(p))
+	switch {
+	case r.done == r.total:
+		r.log.Debugf("%v %v", r.action, humanize.Bytes(uint64(r.total)))
+	case time.Since(r.reported) >= defaults.TransferProgressInterval:
+		r.reported = time.Now()
+		r.log.Infof("%v %v of %v (%v%%)", r.action, humanize.Bytes(uint64(r.done)),
+			humanize.Bytes(uint64(r.total)), r.done*100/r.total)
+	}
+	return len(p), nil
+}
+
+const (
+	// partSuffix is appended to the names of files being transferred
+	partSuffix = ".part"
+	// posixRenameExtension names the OpenSSH extension that renames over existing files
+	posixRenameExtension = "posix-rename@openssh.com"
+)
@@ -0,0 +1,121 @@
+package sshutils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/robotest/lib/ssh/sshtest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfersDirectories(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-sftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0700))
+	writeFileMode(t, filepath.Join(src, "app.yaml"), "name: app\n", 0640)
+	writeFileMode(t, filepath.Join(src, "bin", "gravity"), "#!/bin/sh\n", 0755)
+	writeFileMode(t, filepath.Join(src, "empty"), "", 0600)
+
+	remote := filepath.Join(dir, "remote")
+	require.NoError(t, Upload(context.TODO(), client, testLog(), src, remote))
+	requireSameTree(t, src, remote)
+
+	local := filepath.Join(dir, "local")
+	require.NoError(t, Download(context.TODO(), client, testLog(), remote, local))
+	requireSameTree(t, src, local)
+}
+
+func TestResumesPartialUploads(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-sftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("installer", 100000)
+	src := filepath.Join(dir, "installer.tar")
+	writeFileMode(t, src, content, 0644)
+
+	var tests = []struct {
+		comment string
+		part    string
+	}{
+		{comment: "resumes partial upload", part: content[:12345]},
+		{comment: "restarts corrupted upload", part: "corrupted"},
+		{comment: "restarts upload of larger file", part: content + "extra"},
+	}
+	for _, tc := range tests {
+		dst := filepath.Join(dir, "remote", "installer.tar")
+		require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+		writeFileMode(t, dst+partSuffix, tc.part, 0644)
+
+		path, err := PutFile(context.TODO(), client, testLog(), src, filepath.Dir(dst))
+		require.NoError(t, err, tc.comment)
+		require.Equal(t, dst, path, tc.comment)
+		requireFile(t, dst, content, 0644)
+		_, err = os.Stat(dst + partSuffix)
+		require.True(t, os.IsNotExist(err), tc.comment)
+	}
+}
+
+func TestResumesPartialDownloads(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-sftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("installer", 100000)
+	src := filepath.Join(dir, "installer.tar")
+	writeFileMode(t, src, content, 0600)
+	dst := filepath.Join(dir, "local", "installer.tar")
+	require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+	writeFileMode(t, dst+partSuffix, content[:54321], 0644)
+
+	require.NoError(t, Download(context.TODO(), client, testLog(), src, dst))
+	requireFile(t, dst, content, 0600)
+}
+
+func writeFileMode(t *testing.T, path, content string, mode os.FileMode) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), mode))
+	require.NoError(t, os.Chmod(path, mode))
+}
+
+func requireFile(t *testing.T, path, content string, mode os.FileMode) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(data), path)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, mode, fi.Mode().Perm(), path)
+}
+
+func requireSameTree(t *testing.T, expected, actual string) {
+	err := filepath.Walk(expected, func(path string, fi os.FileInfo, err error) error {
+		require.NoError(t, err)
+		relPath, err := filepath.Rel(expected, path)
+		require.NoError(t, err)
+		actualPath := filepath.Join(actual, relPath)
+		if fi.IsDir() {
+			actualFi, err := os.Stat(actualPath)
+			require.NoError(t, err)
+			require.Equal(t, fi.Mode(), actualFi.Mode(), actualPath)
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		requireFile(t, actualPath, string(data), fi.Mode().Perm())
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -3,9 +3,9 @@ package sshutils
 import (
 	"bufio"
 	"io"
-	"io/ioutil"
 	"net"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -15,36 +15,164 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// JumpHost defines an SSH bastion host to tunnel connections to nodes through
+type JumpHost struct {
+	// Addr is the address of the jump host as host:port
+	Addr string
+	// User is the SSH user to connect to the jump host as
+	User string
+	// Auth authenticates connections to the jump host
+	Auth *Auth
+}
+
+// ClientOptionSetter customizes the configuration of SSH connections
+type ClientOptionSetter func(config *ssh.ClientConfig)
+
 // Client creates a new SSH client specified by
-// addr and user. keyInput defines the SSH key to use for authentication.
+// addr and user. auth defines how to authenticate.
 // Returns a SSH client
-func Client(addr, user string, keyInput io.Reader) (*ssh.Client, error) {
-	keyBytes, err := ioutil.ReadAll(keyInput)
+func Client(addr, user string, auth *Auth, opts ...ClientOptionSetter) (*ssh.Client, error) {
+	return ClientVia(nil, addr, user, auth, opts...)
+}
+
+// ClientVia creates a new SSH client specified by addr and user
+// with the connection tunneled through jumpHost unless it is nil.
+// auth defines how to authenticate on the node.
+// Options apply to connections to both the jump host and the node.
+// The connection to the jump host is closed along with the client
+func ClientVia(jumpHost *JumpHost, addr, user string, auth *Auth, opts ...ClientOptionSetter) (*ssh.Client, error) {
+	conf, err := clientConfig(user, auth, opts...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if jumpHost == nil {
+		return dial(addr, conf)
+	}
+
+	jumpClient, err := jumpHost.dial(opts...)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to connect to jump host %v", jumpHost.Addr)
+	}
+
+	conn, err := jumpClient.Dial("tcp", addr)
+	if err != nil {
+		jumpClient.Close()
+		return nil, trace.Wrap(err, "failed to dial %v via jump host %v", addr, jumpHost.Addr)
+	}
+
+	// jump host connection does not observe the timeout of the client configuration
+	conn = &deadlineConn{Conn: conn}
+	conn.SetDeadline(time.Now().Add(defaults.SSHConnectTimeout))
+	hostKeyErr := captureHostKeyError(conf)
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
 	if err != nil {
+		conn.Close()
+		jumpClient.Close()
+		if *hostKeyErr != nil {
+			return nil, trace.Wrap(*hostKeyErr)
+		}
 		return nil, trace.Wrap(err)
 	}
-	key, err := ssh.ParsePrivateKey(keyBytes)
+	conn.SetDeadline(time.Time{})
+
+	client := ssh.NewClient(clientConn, chans, reqs)
+	go func() {
+		client.Wait()
+		jumpClient.Close()
+	}()
+	return client, nil
+}
+
+// dial connects to the jump host
+func (r *JumpHost) dial(opts ...ClientOptionSetter) (*ssh.Client, error) {
+	conf, err := clientConfig(r.User, r.Auth, opts...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return dial(r.Addr, conf)
+}
+
+// dial connects to the SSH server at addr.
+// Host key verification failures are returned as is
+func dial(addr string, conf *ssh.ClientConfig) (*ssh.Client, error) {
+	hostKeyErr := captureHostKeyError(conf)
+	client, err := ssh.Dial("tcp", addr, conf)
+	if err != nil {
+		if *hostKeyErr != nil {
+			return nil, trace.Wrap(*hostKeyErr)
+		}
+		return nil, trace.Wrap(err)
+	}
+	return client, nil
+}
+
+// captureHostKeyError wraps the host key callback of the configuration
+// to record the verification error which the handshake only reports as text
+func captureHostKeyError(conf *ssh.ClientConfig) *error {
+	var hostKeyErr error
+	callback := conf.HostKeyCallback
+	conf.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKeyErr = callback(hostname, remote, key)
+		return hostKeyErr
+	}
+	return &hostKeyErr
+}
+
+// clientConfig returns the configuration to connect as user
+// authenticated with auth
+func clientConfig(user string, auth *Auth, opts ...ClientOptionSetter) (*ssh.ClientConfig, error) {
+	methods, err := auth.methods()
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 
 	conf := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(key),
-		},
+		User:    user,
+		Auth:    methods,
 		Timeout: defaults.SSHConnectTimeout,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			return nil
 		},
 	}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	return conf, nil
+}
 
-	return ssh.Dial("tcp", addr, conf)
+// deadlineConn is a net.Conn for connections tunneled through a jump host.
+// Channel connections returned by ssh.Client.Dial do not support deadlines
+// so deadlineConn closes the connection once the deadline expires instead
+type deadlineConn struct {
+	net.Conn
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// SetDeadline closes the connection at t unless t is zero
+func (r *deadlineConn) SetDeadline(t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if !t.IsZero() {
+		r.timer = time.AfterFunc(time.Until(t), func() { r.Conn.Close() })
+	}
+	return nil
 }
 
 // Connect connects to remote SSH server and returns new session
-func Connect(addr, user string, keyInput io.Reader) (*ssh.Session, error) {
-	client, err := Client(addr, user, keyInput)
+func Connect(addr, user string, auth *Auth, opts ...ClientOptionSetter) (*ssh.Session, error) {
+	return ConnectVia(nil, addr, user, auth, opts...)
+}
+
+// ConnectVia connects to remote SSH server through the optional jump host
+// and returns new session
+func ConnectVia(jumpHost *JumpHost, addr, user string, auth *Auth, opts ...ClientOptionSetter) (*ssh.Session, error) {
+	client, err := ClientVia(jumpHost, addr, user, auth, opts...)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
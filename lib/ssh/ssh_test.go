@@ -3,117 +3,179 @@ package sshutils
 import (
 	"bufio"
 	"context"
-	"flag"
-	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/gravitational/robotest/lib/ssh/sshtest"
 
 	"github.com/gravitational/trace"
-	"github.com/stretchr/testify/assert"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
-var sshTestHost = flag.String("host", "", "SSH test host address")
-var sshTestUser = flag.String("user", "robotest", "SSH test user")
-var sshTestKeyPath = flag.String("key", "", "Path to SSH private key")
+func TestRunAndParse(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
 
-func TestSshUtils(t *testing.T) {
-	flag.Parse()
-
-	require.NotEmpty(t, *sshTestHost, "ssh host")
-	require.NotEmpty(t, *sshTestKeyPath, "ssh key")
-	require.NotEmpty(t, *sshTestUser, "ssh user")
+	var out string
+	exit, err := RunAndParse(context.TODO(), client, testLog(), "printenv SECURE_KEY", map[string]string{"SECURE_KEY": "secret"},
+		func(r *bufio.Reader) (err error) {
+			out, err = r.ReadString('\n')
+			return trace.Wrap(err)
+		})
+	require.NoError(t, err)
+	require.Zero(t, exit)
+	require.Equal(t, "secret\n", out)
 
-	keyFile, err := os.Open(*sshTestKeyPath)
-	require.NoError(t, err, "SSH file")
-	defer keyFile.Close()
+	exit, err = RunAndParse(context.TODO(), client, testLog(), "exit 3", nil, ParseDiscard)
+	require.True(t, IsExitError(err), "expected exit error but got %v", err)
+	require.Equal(t, 3, exit)
 
-	client, err := Client(fmt.Sprintf("%s:22", *sshTestHost), *sshTestUser, keyFile)
-	require.NoError(t, err, "ssh client")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	exit, err = RunAndParse(ctx, client, testLog(), "sleep 100", nil, ParseDiscard)
+	require.Error(t, err)
+	require.Equal(t, ExitStatusUndefined, exit)
 
-	t.Run("environment", func(t *testing.T) {
-		t.Parallel()
-		t.Skip() // this requires setup on sshd side, and we no longer use this method
-		testEnv(t, client)
+	_, err = RunAndParse(context.TODO(), client, testLog(), "echo invalid", nil, func(r *bufio.Reader) error {
+		return trace.BadParameter("unexpected output")
 	})
+	require.True(t, trace.IsBadParameter(err), "expected parse error but got %v", err)
+}
 
-	t.Run("timeout", func(t *testing.T) {
-		t.Parallel()
-		testTimeout(t, client)
-	})
+func TestPutFile(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-put")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "script.sh")
+	writeFileMode(t, src, "echo hello", 0755)
+	path, err := PutFile(context.TODO(), client, testLog(), src, filepath.Join(dir, "dst"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "dst", "script.sh"), path)
+	requireFile(t, path, "echo hello", 0755)
+}
 
-	t.Run("exit error", func(t *testing.T) {
-		t.Parallel()
-		testExitErr(t, client)
-	})
+func TestPipeCommand(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "robotest-pipe")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
 
-	t.Run("test file", func(t *testing.T) {
-		t.Parallel()
-		testFile(t, client)
-	})
+	dst := filepath.Join(dir, "logs", "output.txt")
+	err = PipeCommand(context.TODO(), client, testLog(), "printf 'line 1\nline 2\n'", dst)
+	require.NoError(t, err)
+	requireContent(t, dst, "line 1\nline 2\n")
 
-	t.Run("scp", func(t *testing.T) {
-		t.Parallel()
-		testPutFile(t, client)
-	})
+	err = PipeCommand(context.TODO(), client, testLog(), "echo partial; exit 1", dst)
+	require.True(t, IsExitError(err), "expected exit error but got %v", err)
 }
 
-func testPutFile(t *testing.T, client *ssh.Client) {
-	p, err := PutFile(context.Background(), t.Logf, client,
-		"/bin/echo", "/tmp")
-	assert.NoError(t, err)
-	assert.EqualValues(t, "/tmp/echo", p, "path")
+func TestTestFile(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Script(map[string]sshtest.Handler{
+		"sudo test -d /":           sshtest.Respond("", "", 0),
+		"sudo test -f /nosuchfile": sshtest.Respond("", "", 1),
+		"sudo test -nosuchflag /":  sshtest.Respond("", "test: -nosuchflag: unary operator expected\n", 2),
+	}))
+	defer cleanup()
+
+	err := TestFile(context.TODO(), client, testLog(), "/", TestDir)
+	require.NoError(t, err)
+
+	err = TestFile(context.TODO(), client, testLog(), "/nosuchfile", TestRegularFile)
+	require.True(t, trace.IsNotFound(err), "expected not found but got %v", err)
+
+	err = TestFile(context.TODO(), client, testLog(), "/", "-nosuchflag")
+	require.True(t, err != nil && !trace.IsNotFound(err), "expected failure of invalid flag but got %v", err)
 }
 
-func testEnv(t *testing.T, client *ssh.Client) {
-	var out string
-	exit, err := RunAndParse(context.Background(),
-		t.Logf,
-		client,
-		"echo $AWS_SECURE_KEY",
-		// NOTE: add `AcceptEnv AWS_*` to /etc/ssh/sshd.conf
-		map[string]string{"AWS_SECURE_KEY": "SECUREKEY"},
-		func(r *bufio.Reader) (err error) {
-			out, err = r.ReadString('\n')
-			return trace.Wrap(err)
-		})
-	assert.NoError(t, err)
-	assert.Zero(t, exit, "exit code")
-	assert.Equal(t, "SECUREKEY\n", out)
+func TestWaitForFile(t *testing.T) {
+	var missing int32
+	client, cleanup := newTestClient(t, sshtest.Script(map[string]sshtest.Handler{
+		"sudo test -f /robotest/ready": sshtest.Respond("", "", 0),
+		"sudo test -f /robotest/missing": func(cmd sshtest.Command) sshtest.Exit {
+			atomic.AddInt32(&missing, 1)
+			return sshtest.Exit{Status: 1}
+		},
+		"sudo test -f /robotest/denied": sshtest.Respond("", "sudo: a password is required\n", 2),
+	}))
+	defer cleanup()
+
+	err := WaitForFile(context.TODO(), client, testLog(), "/robotest/ready", TestRegularFile)
+	require.NoError(t, err)
+
+	err = WaitForFile(context.TODO(), client, testLog(), "/robotest/denied", TestRegularFile)
+	require.Error(t, err, "expected failed test to abort waiting")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = WaitForFile(ctx, client, testLog(), "/robotest/missing", TestRegularFile)
+	require.Error(t, err)
+	require.Equal(t, context.DeadlineExceeded, ctx.Err(), "expected waiting until the context expires")
+	require.True(t, atomic.LoadInt32(&missing) >= 1, "expected the missing file to be tested")
 }
 
-func testTimeout(t *testing.T, client *ssh.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+func TestWaitTimeSync(t *testing.T) {
+	client, cleanup := newTestClient(t, sshtest.Shell)
+	defer cleanup()
+	skewed, cleanupSkewed := newTestClient(t, sshtest.Script(map[string]sshtest.Handler{
+		"date +%s%3N": sshtest.Respond("1000\n", "", 0),
+	}))
+	defer cleanupSkewed()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	err := WaitTimeSync(ctx, []SshNode{{client, testLog()}, {client, testLog()}})
+	require.NoError(t, err)
 
-	_, exit, err := RunAndParse(ctx,
-		t.Logf,
-		client,
-		"sleep 100",
-		nil,
-		ParseDiscard)
-	assert.Error(t, err)
-	assert.Equal(t, exitStatusUndefined, exit)
+	err = CheckTimeSync(ctx, []SshNode{{client, testLog()}, {skewed, testLog()}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not all system clocks updated")
 }
 
-func testExitErr(t *testing.T, client *ssh.Client) {
-	_, exit, err := RunAndParse(context.Background(), t.Logf, client, "false", nil, ParseDiscard)
-	assert.NoError(t, err)
-	assert.NotZero(t, exit)
+// newTestClient returns the client of a test server that runs commands with handler
+func newTestClient(t *testing.T, handler sshtest.Handler) (*ssh.Client, func()) {
+	server, err := sshtest.NewServer(handler)
+	require.NoError(t, err)
+	client, err := server.Dial()
+	require.NoError(t, err)
+	return client, func() {
+		client.Close()
+		server.Close()
+	}
 }
 
-func testFile(t *testing.T, client *ssh.Client) {
-	ctx := context.Background()
-
-	err := TestFile(ctx, t.Logf, client, "/", TestDir)
-	assert.NoError(t, err, TestDir)
+// waitClosed waits for the transport of client to close
+func waitClosed(client *ssh.Client) error {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(5 * time.Second):
+		return trace.LimitExceeded("client has not been closed")
+	}
+}
 
-	err = TestFile(ctx, t.Logf, client, "/nosuchfile", TestRegularFile)
-	assert.True(t, trace.IsNotFound(err))
+func requireContent(t *testing.T, path, content string) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+}
 
-	err = TestFile(ctx, t.Logf, client, "/", "-nosuchflag")
-	assert.True(t, err != nil && !trace.IsNotFound(err), "invalid flag")
+func testLog() logrus.FieldLogger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return logrus.NewEntry(log)
 }
@@ -0,0 +1,110 @@
+package sshtest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Command is a command run by a client
+type Command struct {
+	// Command is the command line
+	Command string
+	// Env lists the environment variables set by the client with env requests
+	Env map[string]string
+	// Channel is the session channel of the command.
+	// Reads return the standard input of the command, writes go to its standard output
+	// and writes to Channel.Stderr() to its standard error
+	Channel ssh.Channel
+	// Signals receives the names of signals sent to the command, i.e. TERM
+	Signals <-chan string
+}
+
+// Exit describes how a command exited
+type Exit struct {
+	// Status is the exit status of the command
+	Status uint32
+	// Signal names the signal that terminated the command, i.e. KILL
+	Signal string
+}
+
+// Handler runs the command and returns how it exited
+type Handler func(cmd Command) Exit
+
+// Shell runs commands with the local shell, adding the environment variables
+// of the command to the environment of the test.
+// A signal kills the command along with its children
+func Shell(cmd Command) Exit {
+	c := exec.Command("/bin/sh", "-c", cmd.Command)
+	c.Env = os.Environ()
+	for k, v := range cmd.Env {
+		c.Env = append(c.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	c.Stdin, c.Stdout, c.Stderr = cmd.Channel, cmd.Channel, cmd.Channel.Stderr()
+	// signals kill the whole process group so no child keeps the output open
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := c.Start(); err != nil {
+		fmt.Fprintln(cmd.Channel.Stderr(), err)
+		return Exit{Status: 127}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	select {
+	case signal := <-cmd.Signals:
+		syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		<-done
+		return Exit{Signal: signal}
+	case err := <-done:
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return Exit{Status: uint32(exitErr.Sys().(syscall.WaitStatus).ExitStatus())}
+		}
+		return Exit{}
+	}
+}
+
+// Script runs commands with the handlers of their command lines.
+// Other commands fail with exit status 127, like missing commands in a shell
+func Script(handlers map[string]Handler) Handler {
+	return func(cmd Command) Exit {
+		handler, ok := handlers[cmd.Command]
+		if !ok {
+			fmt.Fprintf(cmd.Channel.Stderr(), "%v: command not found\n", cmd.Command)
+			return Exit{Status: 127}
+		}
+		return handler(cmd)
+	}
+}
+
+// Respond returns a handler that writes stdout and stderr and exits with status
+func Respond(stdout, stderr string, status uint32) Handler {
+	return func(cmd Command) Exit {
+		io.WriteString(cmd.Channel, stdout)
+		io.WriteString(cmd.Channel.Stderr(), stderr)
+		return Exit{Status: status}
+	}
+}
+
+// Cat copies the standard input of the command to its standard output
+func Cat(cmd Command) Exit {
+	io.Copy(cmd.Channel, cmd.Channel)
+	return Exit{}
+}
+
+// Sleep returns a handler that runs for d or until it receives a signal
+func Sleep(d time.Duration) Handler {
+	return func(cmd Command) Exit {
+		select {
+		case signal := <-cmd.Signals:
+			return Exit{Signal: signal}
+		case <-time.After(d):
+			return Exit{}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package sshtest provides an in-process SSH server for tests of code that runs
+// commands and transfers files over SSH.
+//
+// The server listens on the loopback interface and accepts any client.
+// Commands are served by a Handler: Shell runs them with the local shell,
+// Script answers them with scripted responses. The server implements exec, env, pty-req
+// and signal requests of sessions, exit statuses and signals, and the SFTP subsystem
+// that serves the local filesystem
+package sshtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gravitational/trace"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an in-process SSH server
+type Server struct {
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+	listener net.Listener
+	handler  Handler
+	// keepAlive defines whether the server answers global requests, i.e. keepalives
+	keepAlive bool
+	numDials  int32
+
+	mu sync.Mutex
+	// conns lists the accepted connections that have not been closed by CloseConns
+	conns []net.Conn
+	// commands lists the commands run by clients
+	commands []Command
+}
+
+// ServerOptionSetter configures the server
+type ServerOptionSetter func(s *Server)
+
+// IgnoreKeepAlives makes the server leave global requests unanswered,
+// like an unresponsive host
+func IgnoreKeepAlives() ServerOptionSetter {
+	return func(s *Server) {
+		s.keepAlive = false
+	}
+}
+
+// NewServer starts a new server that runs commands with handler.
+// handler can be nil if the server is not expected to run commands
+func NewServer(handler Handler, opts ...ServerOptionSetter) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	server := &Server{
+		config:    config,
+		hostKey:   signer.PublicKey(),
+		listener:  listener,
+		handler:   handler,
+		keepAlive: true,
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the address the server listens on
+func (r *Server) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// HostKey returns the public host key of the server
+func (r *Server) HostKey() ssh.PublicKey {
+	return r.hostKey
+}
+
+// Dial connects to the server
+func (r *Server) Dial() (*ssh.Client, error) {
+	atomic.AddInt32(&r.numDials, 1)
+	client, err := ssh.Dial("tcp", r.Addr(), &ssh.ClientConfig{
+		User:            "robotest",
+		HostKeyCallback: ssh.FixedHostKey(r.hostKey),
+	})
+	return client, trace.Wrap(err)
+}
+
+// Dials returns how many times Dial has been called
+func (r *Server) Dials() int {
+	return int(atomic.LoadInt32(&r.numDials))
+}
+
+// Commands returns the commands run by clients so far, in the order they were started
+func (r *Server) Commands() []Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Command(nil), r.commands...)
+}
+
+// CloseConns drops all client connections, like a lost network connection.
+// The server keeps accepting new connections
+func (r *Server) CloseConns() {
+	r.mu.Lock()
+	conns := r.conns
+	r.conns = nil
+	r.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and drops all client connections
+func (r *Server) Close() error {
+	err := r.listener.Close()
+	r.CloseConns()
+	return trace.Wrap(err)
+}
+
+func (r *Server) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.conns = append(r.conns, conn)
+		r.mu.Unlock()
+		go r.serveConn(conn)
+	}
+}
+
+func (r *Server) serveConn(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, r.config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for newChan := range chans {
+			if newChan.ChannelType() != "session" {
+				newChan.Reject(ssh.UnknownChannelType, "only session channels are supported")
+				continue
+			}
+			go r.serveSession(newChan)
+		}
+	}()
+	if r.keepAlive {
+		ssh.DiscardRequests(reqs)
+	}
+	// otherwise requests are left unanswered
+}
+
+func (r *Server) serveSession(newChan ssh.NewChannel) {
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	env := make(map[string]string)
+	signals := make(chan string, 1)
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			env[payload.Name] = payload.Value
+			req.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || r.handler == nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			cmd := Command{Command: payload.Command, Env: env}
+			r.mu.Lock()
+			r.commands = append(r.commands, cmd)
+			r.mu.Unlock()
+			cmd.Channel, cmd.Signals = ch, signals
+			go func() {
+				exit(ch, r.handler(cmd))
+			}()
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			if payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				// serves the local filesystem
+				server, err := sftp.NewServer(ch)
+				if err == nil {
+					server.Serve()
+				}
+				exit(ch, Exit{})
+			}()
+		case "signal":
+			var payload struct{ Signal string }
+			ssh.Unmarshal(req.Payload, &payload)
+			select {
+			case signals <- payload.Signal:
+			default:
+			}
+		default:
+			// accept pty-req and others
+			req.Reply(true, nil)
+		}
+	}
+}
+
+// exit reports the exit status or signal of the command and closes the channel
+func exit(ch ssh.Channel, e Exit) {
+	if e.Signal != "" {
+		ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{Signal: e.Signal}))
+	} else {
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{e.Status}))
+	}
+	ch.Close()
+}
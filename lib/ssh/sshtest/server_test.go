@@ -0,0 +1,79 @@
+package sshtest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunsShellCommands(t *testing.T) {
+	server, err := NewServer(Shell)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := server.Dial()
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	require.NoError(t, session.Setenv("ROBOTEST_NAME", "world"))
+	session.Stdin = strings.NewReader("stdin")
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	err = session.Run(`echo "hello $ROBOTEST_NAME"; cat; echo failed >&2; exit 3`)
+	session.Close()
+	exitErr, ok := err.(*ssh.ExitError)
+	require.True(t, ok, "expected exit error but got %v", err)
+	require.Equal(t, 3, exitErr.ExitStatus())
+	require.Equal(t, "hello world\nstdin", stdout.String())
+	require.Equal(t, "failed\n", stderr.String())
+
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	require.NoError(t, session.Start("sleep 10"))
+	// let the command start before the signal
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, session.Signal(ssh.SIGTERM))
+	err = session.Wait()
+	session.Close()
+	exitErr, ok = err.(*ssh.ExitError)
+	require.True(t, ok, "expected exit error but got %v", err)
+	require.Equal(t, "TERM", exitErr.Signal())
+
+	commands := server.Commands()
+	require.Len(t, commands, 2)
+	require.Equal(t, map[string]string{"ROBOTEST_NAME": "world"}, commands[0].Env)
+	require.Equal(t, "sleep 10", commands[1].Command)
+}
+
+func TestScriptsResponses(t *testing.T) {
+	server, err := NewServer(Script(map[string]Handler{
+		"hostname": Respond("node-1\n", "", 0),
+	}))
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := server.Dial()
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	out, err := session.Output("hostname")
+	session.Close()
+	require.NoError(t, err)
+	require.Equal(t, "node-1\n", string(out))
+
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	out, err = session.CombinedOutput("uptime")
+	session.Close()
+	exitErr, ok := err.(*ssh.ExitError)
+	require.True(t, ok, "expected exit error but got %v", err)
+	require.Equal(t, 127, exitErr.ExitStatus())
+	require.Equal(t, "uptime: command not found\n", string(out))
+	require.Equal(t, 1, server.Dials())
+}
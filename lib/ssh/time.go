@@ -63,14 +63,14 @@ func checkTimeInSync(ctx context.Context, nodes []SshNode) func() error {
 
 		values, errors := utils.Collect(ctx, nil, errCh, valueCh)
 		if errors != nil {
-			return wait.AbortRetry{errors}
+			return wait.AbortRetry{Err: errors}
 		}
 
 		if timeInRange(values) {
 			return nil
 		}
 
-		return wait.ContinueRetry{fmt.Sprintf("not all system clocks updated with NTP: %v", values)}
+		return wait.ContinueRetry{Message: fmt.Sprintf("not all system clocks updated with NTP: %v", values)}
 	}
 }
 
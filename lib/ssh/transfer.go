@@ -0,0 +1,45 @@
+package sshutils
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gravitational/robotest/lib/constants"
+
+	"github.com/gravitational/trace"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// PutFile uploads the local file to the remote directory dstDir and returns its remote path.
+// See Upload for details
+func PutFile(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, srcPath, dstDir string) (remotePath string, err error) {
+	remotePath = path.Join(dstDir, filepath.Base(srcPath))
+	err = Upload(ctx, client, log, srcPath, remotePath)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return remotePath, nil
+}
+
+// PipeCommand runs the remote command and stores its standard output in the local file dst
+func PipeCommand(ctx context.Context, client *ssh.Client, log logrus.FieldLogger, cmd, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), constants.SharedDirMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, constants.SharedReadMask)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	_, err = Exec(ctx, client, log, cmd, Stdout(f), Unlogged())
+	if err != nil {
+		return trace.Wrap(err, "failed to store output of %q in %v", cmd, dst)
+	}
+	return trace.ConvertSystemError(f.Close())
+}
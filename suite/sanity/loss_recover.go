@@ -72,15 +72,13 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 	param := p.(lossAndRecoveryParam)
 
 	return func(g *gravity.TestContext, baseConfig gravity.ProvisionerConfig) {
-		config := baseConfig.WithNodes(param.NodeCount + 1)
+		config := baseConfig.WithNodes(param.NodeCount).WithSpareNodes(1)
 
-		allNodes, destroyFn, err := g.Provision(config)
+		nodes, destroyFn, err := g.Provision(config)
 		g.OK("provision nodes", err)
 		defer destroyFn()
 
-		g.OK("download installer", g.SetInstaller(allNodes, config.InstallerURL, "install"))
-
-		nodes := allNodes[0:param.NodeCount]
+		g.OK("download installer", g.SetInstaller(nodes, config.InstallerURL, "install"))
 		g.OK("install", g.OfflineInstall(nodes, param.InstallParam))
 		g.OK("install status", g.Status(nodes))
 
@@ -93,9 +91,9 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 			Info("cluster is available")
 
 		if param.ExpandBeforeShrink {
-			g.OK("expand before shrinking",
-				g.Expand(nodes, allNodes[param.NodeCount:param.NodeCount+1], param.InstallParam))
-			nodes = append(nodes, allNodes[param.NodeCount])
+			extra := addNode(g, config)
+			g.OK("expand before shrinking", g.Expand(nodes, extra, param.InstallParam))
+			nodes = append(nodes, extra...)
 
 			roles, err := g.NodesByRole(nodes)
 			g.OK("node roles after expand", err)
@@ -111,9 +109,9 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 			g.Logger().WithFields(logrus.Fields{"roles": roles, "nodes": nodes}).
 				Info("Roles after remove")
 
-			g.OK("replace node",
-				g.Expand(nodes, allNodes[param.NodeCount:param.NodeCount+1], param.InstallParam))
-			nodes = append(nodes, allNodes[param.NodeCount])
+			extra := addNode(g, config)
+			g.OK("replace node", g.Expand(nodes, extra, param.InstallParam))
+			nodes = append(nodes, extra...)
 		}
 
 		roles, err := g.NodesByRole(nodes)
@@ -123,6 +121,14 @@ func lossAndRecovery(p interface{}) (gravity.TestFunc, error) {
 	}, nil
 }
 
+// addNode provisions the replacement node of a lost one and downloads the installer to it
+func addNode(g *gravity.TestContext, config gravity.ProvisionerConfig) []gravity.Gravity {
+	extra, err := g.AddNodes(1)
+	g.OK("add replacement node", err)
+	g.OK("download installer", g.SetInstaller(extra, config.InstallerURL, "install"))
+	return extra
+}
+
 func removeNode(g *gravity.TestContext,
 	nodes []gravity.Gravity,
 	nodeRoleType string, powerOff bool) (remaining []gravity.Gravity, removed gravity.Gravity, err error) {
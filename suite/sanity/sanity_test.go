@@ -0,0 +1,153 @@
+package sanity
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/robotest/infra/gravity"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveNodeRoles(t *testing.T) {
+	roleTypes := []string{nodeApiMaster, nodeClusterMaster, nodeClusterBackup, nodeRegularNode}
+
+	roles := make([]*gravity.ClusterNodesByRole, len(roleTypes))
+	removed := make([]gravity.Gravity, len(roleTypes))
+	remaining := make([][]gravity.Gravity, len(roleTypes))
+	clusters := make([]*gravity.FakeCluster, len(roleTypes))
+	var tests []fakeTest
+	for i, roleType := range roleTypes {
+		i, roleType := i, roleType
+		tests = append(tests, fakeTest{
+			tag: roleType,
+			fn: func(g *gravity.TestContext, cfg gravity.ProvisionerConfig) {
+				nodes := installFake(g, cfg, 4)
+				var err error
+				roles[i], err = g.NodesByRole(nodes)
+				g.OK("node roles", err)
+				remaining[i], removed[i], err = removeNode(g, nodes, roleType, true)
+				g.OK("remove node", err)
+			},
+			setup: func(cluster *gravity.FakeCluster) { clusters[i] = cluster },
+		})
+	}
+	runFake(t, tests...)
+
+	for i, roleType := range roleTypes {
+		switch roleType {
+		case nodeApiMaster:
+			require.Equal(t, roles[i].ApiMaster, removed[i])
+		case nodeClusterMaster:
+			// the API master ran the cluster master that has been relocated
+			require.Equal(t, roles[i].ApiMaster, roles[i].ClusterMaster)
+			require.NotEqual(t, roles[i].ApiMaster, removed[i])
+			require.Equal(t, clusters[i].ClusterMaster(), removed[i].Node().PrivateAddr())
+		case nodeClusterBackup:
+			require.Contains(t, roles[i].ClusterBackup, removed[i])
+			require.NotEqual(t, roles[i].ApiMaster, removed[i])
+		case nodeRegularNode:
+			require.Equal(t, []gravity.Gravity{removed[i]}, roles[i].Regular)
+		}
+		require.True(t, removed[i].Offline(), "expected %v node to be powered off", roleType)
+		require.Len(t, remaining[i], 3, roleType)
+		require.NotContains(t, remaining[i], removed[i], roleType)
+	}
+}
+
+func TestLossAndRecoveryOrder(t *testing.T) {
+	var testCases = []struct {
+		tag                string
+		expandBeforeShrink bool
+		operations         []string
+	}{
+		{tag: "expBfr", expandBeforeShrink: true, operations: []string{"install", "join", "join", "join", "remove"}},
+		{tag: "expAft", expandBeforeShrink: false, operations: []string{"install", "join", "join", "remove", "join"}},
+	}
+
+	clusters := make([]*gravity.FakeCluster, len(testCases))
+	var tests []fakeTest
+	for i, testCase := range testCases {
+		i := i
+		fn, err := lossAndRecovery(lossAndRecoveryParam{
+			installParam:       installParam{NodeCount: 3},
+			ReplaceNodeType:    nodeClusterBackup,
+			ExpandBeforeShrink: testCase.expandBeforeShrink,
+			PowerOff:           true,
+		})
+		require.NoError(t, err)
+		tests = append(tests, fakeTest{
+			tag:   testCase.tag,
+			fn:    fn,
+			setup: func(cluster *gravity.FakeCluster) { clusters[i] = cluster },
+		})
+	}
+	runFake(t, tests...)
+
+	for i, testCase := range testCases {
+		var operations []string
+		for _, op := range clusters[i].Operations() {
+			operations = append(operations, op.Type)
+		}
+		require.Equal(t, testCase.operations, operations, "expand before shrink: %v", testCase.expandBeforeShrink)
+		members := clusters[i].Members()
+		require.Len(t, members, 3)
+		require.Contains(t, members, "10.0.0.4", "expected the spare node to replace the lost one")
+	}
+}
+
+func TestSuiteFunctions(t *testing.T) {
+	param := installParam{NodeCount: 1}
+	installFn, err := install(param)
+	require.NoError(t, err)
+	resizeFn, err := resize(resizeParam{installParam: param, ToNodes: 3})
+	require.NoError(t, err)
+	upgradeFn, err := upgrade(upgradeParam{installParam: param, BaseInstallerURL: "base.tar"})
+	require.NoError(t, err)
+
+	var installed, resized, upgraded *gravity.FakeCluster
+	runFake(t,
+		fakeTest{tag: "install", fn: installFn, setup: func(cluster *gravity.FakeCluster) { installed = cluster }},
+		fakeTest{tag: "resize", fn: resizeFn, setup: func(cluster *gravity.FakeCluster) { resized = cluster }},
+		fakeTest{tag: "upgrade", fn: upgradeFn, setup: func(cluster *gravity.FakeCluster) { upgraded = cluster }},
+	)
+
+	require.Equal(t, []string{"10.0.0.1"}, installed.Members())
+	require.Len(t, resized.Members(), 3)
+	var operations []string
+	for _, op := range upgraded.Operations() {
+		operations = append(operations, op.Type)
+	}
+	require.Equal(t, []string{"install", "upload", "upgrade"}, operations)
+}
+
+// fakeTest is a test function run on simulated nodes
+type fakeTest struct {
+	tag string
+	fn  gravity.TestFunc
+	// setup optionally scripts the simulated cluster
+	setup func(*gravity.FakeCluster)
+}
+
+// runFake runs the tests on simulated nodes and expects them to pass
+func runFake(t *testing.T, tests ...fakeTest) {
+	suite := gravity.NewSuite(context.Background(), t, "", logrus.Fields{}, false)
+	for _, test := range tests {
+		cfg := gravity.ProvisionerConfig{InstallerURL: "installer.tar"}.WithTag(test.tag).WithFake(test.setup)
+		suite.Schedule(test.fn, cfg, nil)
+	}
+	for _, status := range suite.Run() {
+		require.Equal(t, gravity.TestStatusPassed, status.Status, status.Name)
+	}
+}
+
+// installFake installs a cluster on count simulated nodes
+func installFake(g *gravity.TestContext, cfg gravity.ProvisionerConfig, count uint) []gravity.Gravity {
+	cfg = cfg.WithNodes(count)
+	nodes, _, err := g.Provision(cfg)
+	g.OK("provision nodes", err)
+	g.OK("installer", g.SetInstaller(nodes, cfg.InstallerURL, "install"))
+	g.OK("install", g.OfflineInstall(nodes, gravity.InstallParam{}))
+	return nodes
+}
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gravitational/robotest/infra"
 	"github.com/gravitational/robotest/infra/gravity"
 	"github.com/gravitational/robotest/lib/config"
 	"github.com/gravitational/robotest/lib/xlog"
@@ -45,12 +46,16 @@ var destroyOnFailure = flag.Bool("destroy-on-failure", false, "remove resources
 var resourceListFile = flag.String("resourcegroup-file", "", "file with list of resources created")
 var collectLogs = flag.Bool("always-collect-logs", true, "collect logs from nodes once tests are finished. otherwise they will only be pulled for failed tests")
 
+var installerCacheMode = flag.String("installer-cache", gravity.InstallerCacheOff, "how installers cached by the runner get to nodes: off (download on each node), mirror (download over SSH reverse tunnels) or push (upload over SFTP)")
+var installerDistribution = flag.String("installer-distribution", gravity.DistributeDirect, "how installers get to nodes: direct (each node transfers the installer) or tree (nodes copy the installer from each other over the private network)")
+var installerCacheDir = flag.String("installer-cache-dir", "", "directory of the installer cache, reused across runs")
+
 var cloudLogProjectID = flag.String("gcl-project-id", "", "enable logging to the cloud")
 
 var testSets, osFlavors, storageDrivers valueList
 
 func init() {
-	flag.Var(&osFlavors, "os", "comma delimited list of OS")
+	flag.Var(&osFlavors, "os", "comma delimited list of OS or node group mixes, i.e. centos/c4.2xlarge:1+ubuntu")
 	flag.Var(&storageDrivers, "storage-driver", "comma delimited list of Docker storage drivers: devicemapper,loopback,overlay,overlay2")
 }
 
@@ -120,6 +125,21 @@ func TestMain(t *testing.T) {
 	}
 	gravity.SetProvisionerPolicy(policy)
 
+	err = gravity.SetInstallerCache(gravity.InstallerCacheConfig{
+		Dir:  *installerCacheDir,
+		Mode: *installerCacheMode,
+	})
+	if err != nil {
+		t.Fatalf("failed to set up installer cache: %v", err)
+	}
+
+	switch *installerDistribution {
+	case gravity.DistributeDirect, gravity.DistributeTree:
+		gravity.DefaultInstallerDistribution.Strategy = *installerDistribution
+	default:
+		t.Fatalf("unknown installer distribution %q", *installerDistribution)
+	}
+
 	suite := gravity.NewSuite(ctx, t, *cloudLogProjectID, logrus.Fields{
 		"test_suite":         *testSuite,
 		"test_set":           testSet,
@@ -133,12 +153,21 @@ func TestMain(t *testing.T) {
 	defer suite.Close()
 	setupSignals(suite)
 
+	nodeGroups := make(map[string][]infra.NodeGroup, len(osFlavors))
+	for _, osFlavor := range osFlavors {
+		groups, err := gravity.ParseNodeGroups(osFlavor)
+		if err != nil {
+			t.Fatalf("invalid OS %q: %v", osFlavor, err)
+		}
+		nodeGroups[osFlavor] = groups
+	}
+
 	for r := 1; r <= *repeat; r++ {
 		for _, osFlavor := range osFlavors {
 			for ts, entry := range testSet {
 				for _, drv := range storageDrivers {
 					cfg := config.WithTag(fmt.Sprintf("%s-%d", ts, r)).
-						WithOS(osFlavor).WithStorageDriver(drv)
+						WithNodeGroups(nodeGroups[osFlavor]...).WithStorageDriver(drv)
 					suite.Schedule(entry.TestFunc, cfg, entry.Param)
 				}
 			}